@@ -0,0 +1,225 @@
+package analytics
+
+import "github.com/jacobgreenleaf/yeti/book"
+import "fmt"
+import "sort"
+import "time"
+
+// Analyzer sums up the order flow in a book over the Window leading up to
+// any time t, that is after t-Window and up to and including t.
+//
+// It walks the book's History, so orders vacuumed out of the book still count
+// for as long as their history is kept. It also moves the book's snapshot
+// cursor, so like SnapshotAt it needs the book to itself while it runs.
+type Analyzer struct {
+	Window time.Duration
+
+	// Depth is how many levels on each side DepthImbalance looks at
+	Depth int
+
+	// DistanceBucket is the width, in price units, of the buckets FillRates
+	// groups orders into by how far from the mid they were placed
+	DistanceBucket int64
+}
+
+// Report is the order flow in a book between Start and End.
+//
+// Cancels are orders voided in the window and Fills are the matches of resting
+// orders; CancelToFill is their ratio, or zero without any fills. Lifetimes
+// are the times from placement to being filled or voided of the orders that
+// finished in the window. Imbalance and DepthImbalance are as of End, and only
+// if ImbalanceOk. OrderFlowImbalance is between the book at Start and at End.
+type Report struct {
+	Start time.Time
+	End   time.Time
+
+	Placed       int64
+	Cancels      int64
+	Fills        int64
+	FilledSize   int64
+	CancelToFill float64
+
+	Finished       int64
+	MedianLifetime time.Duration
+
+	Imbalance          float64
+	DepthImbalance     float64
+	ImbalanceOk        bool
+	OrderFlowImbalance int64
+
+	FillRates []FillRate
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("<Report from %s to %s; %d placed, %d cancels, %d fills, median lifetime %s, ofi=%d>", r.Start.String(), r.End.String(), r.Placed, r.Cancels, r.Fills, r.MedianLifetime, r.OrderFlowImbalance)
+}
+
+// FillRate is how many of the orders placed in a window between Distance and
+// Distance+DistanceBucket behind the mid just before they were placed had been
+// matched by the end of it.
+// Distance is negative for orders placed through the mid.
+type FillRate struct {
+	Distance int64
+	Orders   int64
+	Filled   int64
+}
+
+func (r FillRate) String() string {
+	return fmt.Sprintf("<FillRate of %d/%d orders at distance %d>", r.Filled, r.Orders, r.Distance)
+}
+
+// Rate is the fraction of orders that were matched, or zero without any orders.
+func (r FillRate) Rate() float64 {
+	if r.Orders == 0 {
+		return 0
+	}
+	return float64(r.Filled) / float64(r.Orders)
+}
+
+type placement struct {
+	time    time.Time
+	order   *book.StatefulOrder
+	matched bool
+}
+
+type placementsByTime []placement
+
+func (a placementsByTime) Len() int           { return len(a) }
+func (a placementsByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a placementsByTime) Less(i, j int) bool { return a[i].time.Before(a[j].time) }
+
+type fillRatesByDistance []FillRate
+
+func (a fillRatesByDistance) Len() int           { return len(a) }
+func (a fillRatesByDistance) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a fillRatesByDistance) Less(i, j int) bool { return a[i].Distance < a[j].Distance }
+
+type durations []time.Duration
+
+func (a durations) Len() int           { return len(a) }
+func (a durations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a durations) Less(i, j int) bool { return a[i] < a[j] }
+
+// Report sums up the order flow in b over the window leading up to t.
+func (a *Analyzer) Report(b *book.InMemoryOrderBook, t time.Time) *Report {
+	start := t.Add(-a.Window)
+	inWindow := func(when time.Time) bool { return when.After(start) && !when.After(t) }
+
+	r := &Report{Start: start, End: t, FillRates: []FillRate{}}
+
+	placements := make([]placement, 0)
+	lifetimes := make([]time.Duration, 0)
+
+	for _, history := range b.History {
+		placed := history.Mutations[0].GetTime()
+		matched := false
+
+		for _, mut := range history.Mutations {
+			if mut.GetTime().After(t) {
+				continue
+			}
+
+			switch m := mut.(type) {
+			case *book.OrderStateMutation:
+				if m.State == book.STATE_VOID && inWindow(m.Time) {
+					r.Cancels += 1
+				}
+			case *book.OrderMatchMutation:
+				matched = true
+				if m.WasMaker && inWindow(m.Time) {
+					r.Fills += 1
+					r.FilledSize += m.Size
+				}
+			}
+		}
+
+		if inWindow(placed) {
+			r.Placed += 1
+			// Market orders don't have a price to be any distance from the mid
+			if history.FirstVersion.Price != 0 {
+				placements = append(placements, placement{placed, history.FirstVersion, matched})
+			}
+		}
+
+		order := history.VersionAt(t)
+		if (order.State == book.STATE_FILLED || order.State == book.STATE_VOID) && inWindow(order.LatestMutationTime) {
+			lifetimes = append(lifetimes, order.LatestMutationTime.Sub(placed))
+		}
+	}
+
+	if r.Fills > 0 {
+		r.CancelToFill = float64(r.Cancels) / float64(r.Fills)
+	}
+
+	r.Finished = int64(len(lifetimes))
+	if len(lifetimes) > 0 {
+		sort.Sort(durations(lifetimes))
+		r.MedianLifetime = lifetimes[len(lifetimes)/2]
+	}
+
+	// Walk the book forward in time from start to t, so the cursor is reused
+	before := b.SnapshotAt(start)
+
+	sort.Sort(placementsByTime(placements))
+	r.FillRates = a.fillRates(b, placements)
+
+	after := b.SnapshotAt(t)
+
+	r.OrderFlowImbalance = OrderFlowImbalance(before, after)
+	r.Imbalance, r.ImbalanceOk = TopOfBookImbalance(after)
+	r.DepthImbalance, _ = DepthImbalance(after, a.Depth)
+
+	return r
+}
+
+// fillRates buckets placements, which must be in time order, by how far
+// behind the mid at the time they were.
+func (a *Analyzer) fillRates(b *book.InMemoryOrderBook, placements []placement) []FillRate {
+	width := a.DistanceBucket
+	if width < 1 {
+		width = 1
+	}
+
+	buckets := make(map[int64]*FillRate)
+
+	for _, p := range placements {
+		// The mid from just before the order, so that it doesn't count itself
+		bid, ask, ok := b.BestBidAskAt(p.time.Add(-time.Nanosecond))
+		if !ok {
+			continue
+		}
+
+		mid := bid + (ask-bid)/2
+
+		distance := mid - p.order.Price
+		if p.order.Side == book.SIDE_SELL {
+			distance = -distance
+		}
+
+		// Round towards negative infinity so that buckets are all the same width
+		bucket := distance / width
+		if distance%width != 0 && distance < 0 {
+			bucket -= 1
+		}
+		bucket *= width
+
+		rate, ok := buckets[bucket]
+		if !ok {
+			rate = &FillRate{Distance: bucket}
+			buckets[bucket] = rate
+		}
+
+		rate.Orders += 1
+		if p.matched {
+			rate.Filled += 1
+		}
+	}
+
+	rates := make([]FillRate, 0, len(buckets))
+	for _, rate := range buckets {
+		rates = append(rates, *rate)
+	}
+	sort.Sort(fillRatesByDistance(rates))
+
+	return rates
+}
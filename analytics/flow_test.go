@@ -0,0 +1,75 @@
+package analytics
+
+import "github.com/jacobgreenleaf/yeti/book"
+import "testing"
+import "time"
+
+func TestReport(t *testing.T) {
+	b := book.NewInMemoryOrderBook()
+
+	// Before the window
+	openOrder(b, "bid", book.SIDE_BUY, 100, 10, time.Unix(0, 0))
+	openOrder(b, "ask", book.SIDE_SELL, 110, 10, time.Unix(0, 0))
+
+	// Mid is 105 for near, 1 behind it, and then 107 for far, 3 behind, and cancelled, 6 behind
+	openOrder(b, "near", book.SIDE_BUY, 104, 2, time.Unix(11, 0))
+	openOrder(b, "far", book.SIDE_SELL, 110, 3, time.Unix(12, 0))
+	openOrder(b, "cancelled", book.SIDE_BUY, 101, 1, time.Unix(13, 0))
+
+	b.MutateOrder("near", []book.OrderMutation{&book.OrderMatchMutation{Size: 2, WasMaker: true, Time: time.Unix(15, 0)}})
+	b.MutateOrder("cancelled", []book.OrderMutation{&book.OrderStateMutation{State: book.STATE_VOID, Time: time.Unix(14, 0)}})
+	b.MutateOrder("ask", []book.OrderMutation{&book.OrderMatchMutation{Size: 1, WasMaker: true, Time: time.Unix(16, 0)}})
+
+	// After the window
+	b.MutateOrder("far", []book.OrderMutation{&book.OrderStateMutation{State: book.STATE_VOID, Time: time.Unix(30, 0)}})
+
+	analyzer := &Analyzer{Window: 10 * time.Second, Depth: 5, DistanceBucket: 2}
+	r := analyzer.Report(b, time.Unix(20, 0))
+
+	if r.Placed != 3 {
+		t.Fatalf("Expected 3 orders placed in the window, instead %d", r.Placed)
+	}
+	if r.Cancels != 1 || r.Fills != 2 || r.FilledSize != 3 {
+		t.Fatalf("Expected 1 cancel and 2 fills for 3 units, instead %s", r)
+	}
+	if r.CancelToFill != 0.5 {
+		t.Fatalf("Expected cancel to fill ratio of 0.5, instead %f", r.CancelToFill)
+	}
+
+	// near lived 4 seconds and cancelled 1
+	if r.Finished != 2 || r.MedianLifetime != 4*time.Second {
+		t.Fatalf("Expected 2 orders finished with a median lifetime of 4s, instead %d and %s", r.Finished, r.MedianLifetime)
+	}
+
+	// The bid is back where it started, and the ask lost 1 to a match but gained far's 3
+	if r.OrderFlowImbalance != -2 {
+		t.Fatalf("Expected order flow imbalance of -2, instead %d", r.OrderFlowImbalance)
+	}
+	if !r.ImbalanceOk {
+		t.Fatal("Expected an imbalance with both sides open")
+	}
+
+	// Bid of 100 for 10 against an ask of 110 for 9 + 3
+	if r.Imbalance != (10.0-12.0)/22.0 {
+		t.Fatalf("Expected imbalance of %f, instead %f", (10.0-12.0)/22.0, r.Imbalance)
+	}
+
+	expected := []FillRate{
+		{Distance: 0, Orders: 1, Filled: 1},
+		{Distance: 2, Orders: 1, Filled: 0},
+		{Distance: 6, Orders: 1, Filled: 0},
+	}
+
+	if len(r.FillRates) != len(expected) {
+		t.Fatalf("Expected %d fill rate buckets, instead %v", len(expected), r.FillRates)
+	}
+	for i, e := range expected {
+		if r.FillRates[i] != e {
+			t.Fatalf("Expected %s, instead %s", e, r.FillRates[i])
+		}
+	}
+
+	if r.FillRates[0].Rate() != 1 || r.FillRates[1].Rate() != 0 {
+		t.Fatalf("Expected fill rates of 1 and 0, instead %f and %f", r.FillRates[0].Rate(), r.FillRates[1].Rate())
+	}
+}
@@ -0,0 +1,76 @@
+package analytics
+
+import "github.com/jacobgreenleaf/yeti/book"
+
+// TopOfBookImbalance compares the size at the best bid with the size at the
+// best ask. It is between -1, when there's nothing to buy with, and 1, when
+// there's nothing to sell into, and only ok if both sides have open orders.
+func TopOfBookImbalance(source book.DepthSource) (float64, bool) {
+	return DepthImbalance(source, 1)
+}
+
+// DepthImbalance is like TopOfBookImbalance over the best n levels on each
+// side, weighting the ith best level by 1/i so that size further from the
+// touch counts for less.
+func DepthImbalance(source book.DepthSource, n int) (float64, bool) {
+	bids := source.Depth(book.SIDE_BUY, n)
+	asks := source.Depth(book.SIDE_SELL, n)
+
+	if len(bids) == 0 || len(asks) == 0 {
+		return 0, false
+	}
+
+	bid := weightedSize(bids)
+	ask := weightedSize(asks)
+
+	return (bid - ask) / (bid + ask), true
+}
+
+func weightedSize(levels []book.AggregatedPriceLevel) float64 {
+	var size float64 = 0
+
+	for i, level := range levels {
+		size += float64(level.Size) / float64(i+1)
+	}
+
+	return size
+}
+
+// OrderFlowImbalance is the net size added to the bid minus the net size
+// added to the ask at the touch between two states of a book, after Cont,
+// Kukanov and Stoikov. A bid moving up counts its whole size as added, and a
+// bid moving down counts the size it had as removed; the same goes for asks
+// the other way around. Positive values mean buying pressure.
+func OrderFlowImbalance(before, after book.DepthSource) int64 {
+	return touchFlow(before, after, book.SIDE_BUY) - touchFlow(before, after, book.SIDE_SELL)
+}
+
+// touchFlow is the net size added at the best price on one side.
+func touchFlow(before, after book.DepthSource, side string) int64 {
+	previous, previousOk := best(before, side)
+	latest, latestOk := best(after, side)
+
+	switch {
+	case !previousOk && !latestOk:
+		return 0
+	case !previousOk:
+		return latest.Size
+	case !latestOk:
+		return -previous.Size
+	case latest.Price == previous.Price:
+		return latest.Size - previous.Size
+	case (latest.Price > previous.Price) == (side == book.SIDE_BUY):
+		// Improved on the previous best
+		return latest.Size
+	default:
+		return -previous.Size
+	}
+}
+
+func best(source book.DepthSource, side string) (book.AggregatedPriceLevel, bool) {
+	levels := source.Depth(side, 1)
+	if len(levels) == 0 {
+		return book.AggregatedPriceLevel{}, false
+	}
+	return levels[0], true
+}
@@ -0,0 +1,62 @@
+package analytics
+
+import "github.com/jacobgreenleaf/yeti/book"
+import "testing"
+import "time"
+
+func openOrder(b *book.InMemoryOrderBook, id book.OrderID, side string, price, size int64, t time.Time) {
+	b.PlaceOrder(book.Order{ID: id, Price: price, Side: side}, size, t)
+	b.MutateOrder(id, []book.OrderMutation{&book.OrderStateMutation{State: book.STATE_OPEN, Time: t}})
+}
+
+func TestImbalance(t *testing.T) {
+	b := book.NewInMemoryOrderBook()
+
+	if _, ok := TopOfBookImbalance(b); ok {
+		t.Fatal("Expected no imbalance for an empty book")
+	}
+
+	openOrder(b, "bid1", book.SIDE_BUY, 99, 30, time.Unix(0, 0))
+	openOrder(b, "bid2", book.SIDE_BUY, 98, 40, time.Unix(0, 0))
+	openOrder(b, "ask1", book.SIDE_SELL, 101, 10, time.Unix(0, 0))
+	openOrder(b, "ask2", book.SIDE_SELL, 102, 80, time.Unix(0, 0))
+
+	imbalance, ok := TopOfBookImbalance(b)
+	if !ok || imbalance != 0.5 {
+		t.Fatalf("Expected top of book imbalance of 0.5, instead %f", imbalance)
+	}
+
+	// 30 + 40/2 = 50 against 10 + 80/2 = 50
+	if imbalance, _ := DepthImbalance(b, 2); imbalance != 0 {
+		t.Fatalf("Expected depth weighted imbalance of 0, instead %f", imbalance)
+	}
+}
+
+func TestOrderFlowImbalance(t *testing.T) {
+	b := book.NewInMemoryOrderBook()
+
+	openOrder(b, "bid1", book.SIDE_BUY, 99, 30, time.Unix(0, 0))
+	openOrder(b, "ask1", book.SIDE_SELL, 101, 10, time.Unix(0, 0))
+	before := b.Snapshot()
+
+	// A better bid, and some of the best ask taken
+	openOrder(b, "bid2", book.SIDE_BUY, 100, 5, time.Unix(1, 0))
+	b.MutateOrder("ask1", []book.OrderMutation{&book.OrderMatchMutation{Size: 4, WasMaker: true, Time: time.Unix(1, 0)}})
+	after := b.Snapshot()
+
+	if ofi := OrderFlowImbalance(before, after); ofi != 5+4 {
+		t.Fatalf("Expected order flow imbalance of 9, instead %d", ofi)
+	}
+
+	// The ask moving away counts what was there as removed
+	b.MutateOrder("ask1", []book.OrderMutation{&book.OrderStateMutation{State: book.STATE_VOID, Time: time.Unix(2, 0)}})
+	openOrder(b, "ask2", book.SIDE_SELL, 103, 50, time.Unix(2, 0))
+
+	if ofi := OrderFlowImbalance(after, b.Snapshot()); ofi != 6 {
+		t.Fatalf("Expected order flow imbalance of 6, instead %d", ofi)
+	}
+
+	if ofi := OrderFlowImbalance(after, after); ofi != 0 {
+		t.Fatalf("Expected no order flow imbalance without changes, instead %d", ofi)
+	}
+}
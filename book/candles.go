@@ -0,0 +1,248 @@
+package book
+
+import "fmt"
+import "sort"
+import "sync"
+import "sync/atomic"
+import "time"
+
+// DEFAULT_CANDLE_GRANULARITIES are the bars a CandleAggregator keeps unless told otherwise.
+var DEFAULT_CANDLE_GRANULARITIES = []time.Duration{time.Second, time.Minute, 5 * time.Minute, time.Hour}
+
+// Candle is an open/high/low/close/volume bar of the trades from Start until
+// Start+Granularity. Revision is zero the first time a finished bar is sent
+// and goes up every time a late trade amends it. Partial is set on a bar that
+// was sent by Close before it ended.
+type Candle struct {
+	Start       time.Time
+	Granularity time.Duration
+	Open        int64
+	High        int64
+	Low         int64
+	Close       int64
+	Volume      int64
+	Trades      int64
+	Revision    int
+	Partial     bool
+
+	openTime  time.Time
+	closeTime time.Time
+	finished  bool
+}
+
+func (c Candle) String() string {
+	return fmt.Sprintf("<Candle of %s at %s; o=%d h=%d l=%d c=%d v=%d; %d trades>", c.Granularity, c.Start.String(), c.Open, c.High, c.Low, c.Close, c.Volume, c.Trades)
+}
+
+func (c *Candle) add(trade Trade) {
+	if c.Trades == 0 {
+		c.Open, c.High, c.Low, c.Close = trade.Price, trade.Price, trade.Price, trade.Price
+		c.openTime, c.closeTime = trade.Time, trade.Time
+	}
+
+	if trade.Price > c.High {
+		c.High = trade.Price
+	}
+	if trade.Price < c.Low {
+		c.Low = trade.Price
+	}
+	if trade.Time.Before(c.openTime) {
+		c.Open, c.openTime = trade.Price, trade.Time
+	}
+	if !trade.Time.Before(c.closeTime) {
+		c.Close, c.closeTime = trade.Price, trade.Time
+	}
+
+	c.Volume += trade.Size
+	c.Trades += 1
+}
+
+// CandleAggregator builds candles at several granularities at once from a
+// stream of trades, like the one recorded by a TradeTape. Time only moves
+// forward with the trades themselves, or with Advance.
+//
+// A bar is finished, and sent on C, once time has moved past its end. Trades
+// that turn up within Grace of the end of a finished bar amend it and send it
+// again; anything later than that is only counted in LateTrades. Bars without
+// trades are never sent. Read LateTrades and Dropped with atomic.LoadInt64.
+//
+// Close sends whatever bars are still in progress and closes C, so whoever is
+// ranging over C gets everything and then stops.
+type CandleAggregator struct {
+	C     <-chan Candle
+	Grace time.Duration
+
+	LateTrades int64
+	// Dropped counts the bars that didn't fit in C's buffer
+	Dropped int64
+
+	lock          sync.Mutex
+	candles       chan Candle
+	granularities []time.Duration
+	bars          map[time.Duration]map[int64]*Candle
+	latest        time.Time
+	closed        bool
+}
+
+func NewCandleAggregator(granularities []time.Duration, grace time.Duration, bufLen int) *CandleAggregator {
+	candles := make(chan Candle, bufLen)
+
+	a := &CandleAggregator{
+		C:             candles,
+		Grace:         grace,
+		candles:       candles,
+		granularities: granularities,
+		bars:          make(map[time.Duration]map[int64]*Candle),
+	}
+
+	for _, g := range granularities {
+		a.bars[g] = make(map[int64]*Candle)
+	}
+
+	return a
+}
+
+func (a *CandleAggregator) String() string {
+	return fmt.Sprintf("<CandleAggregator of %v with %s grace>", a.granularities, a.Grace)
+}
+
+// Add counts a trade towards the bars it falls in. It doesn't check for
+// duplicates, so trades should come from somewhere that does.
+func (a *CandleAggregator) Add(trade Trade) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.closed {
+		return
+	}
+
+	if trade.Time.After(a.latest) {
+		a.latest = trade.Time
+	}
+
+	for _, g := range a.granularities {
+		start := trade.Time.Truncate(g)
+
+		if !start.Add(g).Add(a.Grace).After(a.latest) {
+			atomic.AddInt64(&a.LateTrades, 1)
+			continue
+		}
+
+		bar, ok := a.bars[g][start.UnixNano()]
+		if !ok {
+			bar = &Candle{Start: start, Granularity: g}
+			a.bars[g][start.UnixNano()] = bar
+		}
+
+		bar.add(trade)
+
+		if bar.finished {
+			bar.Revision += 1
+			a.send(*bar)
+		}
+	}
+
+	a.finish()
+}
+
+// Advance moves time forward to now without a trade, finishing the bars
+// that end by then.
+func (a *CandleAggregator) Advance(now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.closed {
+		return
+	}
+
+	if now.After(a.latest) {
+		a.latest = now
+	}
+
+	a.finish()
+}
+
+// Close sends the bars that haven't ended yet, oldest first and marked
+// Partial, and then closes C. Trades added after Close are ignored.
+func (a *CandleAggregator) Close() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.closed {
+		return
+	}
+	a.closed = true
+
+	for _, g := range a.granularities {
+		unfinished := make([]*Candle, 0)
+
+		for _, bar := range a.bars[g] {
+			if !bar.finished {
+				unfinished = append(unfinished, bar)
+			}
+		}
+
+		sort.Sort(candlesByStart(unfinished))
+
+		for _, bar := range unfinished {
+			partial := *bar
+			partial.Partial = true
+			a.send(partial)
+		}
+	}
+
+	close(a.candles)
+}
+
+// Current returns the bar that the latest trade went into for a granularity.
+func (a *CandleAggregator) Current(g time.Duration) (Candle, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	bar, ok := a.bars[g][a.latest.Truncate(g).UnixNano()]
+	if !ok {
+		return Candle{}, false
+	}
+	return *bar, true
+}
+
+// finish sends the bars that have ended and forgets the ones that can no
+// longer be amended. The caller must hold the lock.
+func (a *CandleAggregator) finish() {
+	for _, g := range a.granularities {
+		ended := make([]*Candle, 0)
+
+		for key, bar := range a.bars[g] {
+			end := bar.Start.Add(g)
+
+			if !bar.finished && !end.After(a.latest) {
+				ended = append(ended, bar)
+			}
+			if !end.Add(a.Grace).After(a.latest) {
+				delete(a.bars[g], key)
+			}
+		}
+
+		// Oldest first, so the channel reads in order
+		sort.Sort(candlesByStart(ended))
+
+		for _, bar := range ended {
+			bar.finished = true
+			a.send(*bar)
+		}
+	}
+}
+
+func (a *CandleAggregator) send(c Candle) {
+	select {
+	case a.candles <- c:
+	default:
+		atomic.AddInt64(&a.Dropped, 1)
+	}
+}
+
+type candlesByStart []*Candle
+
+func (s candlesByStart) Len() int           { return len(s) }
+func (s candlesByStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s candlesByStart) Less(i, j int) bool { return s[i].Start.Before(s[j].Start) }
@@ -0,0 +1,143 @@
+package book
+
+import "testing"
+import "time"
+
+func receive(t *testing.T, a *CandleAggregator) Candle {
+	select {
+	case c := <-a.C:
+		return c
+	default:
+		t.Fatal("Expected a finished candle")
+	}
+	return Candle{}
+}
+
+func TestAggregatingCandles(t *testing.T) {
+	a := NewCandleAggregator([]time.Duration{time.Second, time.Minute}, 0, 100)
+
+	a.Add(Trade{ID: 1, Price: 100, Size: 1, Time: time.Unix(60, 0)})
+	a.Add(Trade{ID: 2, Price: 105, Size: 2, Time: time.Unix(60, 300)})
+	a.Add(Trade{ID: 3, Price: 95, Size: 3, Time: time.Unix(60, 600)})
+	a.Add(Trade{ID: 4, Price: 101, Size: 4, Time: time.Unix(60, 900)})
+
+	if len(a.C) != 0 {
+		t.Fatal("Expected no finished candles before time moves on")
+	}
+
+	a.Add(Trade{ID: 5, Price: 110, Size: 5, Time: time.Unix(62, 0)})
+
+	c := receive(t, a)
+	if c.Granularity != time.Second || !c.Start.Equal(time.Unix(60, 0)) || c.Open != 100 || c.High != 105 || c.Low != 95 || c.Close != 101 || c.Volume != 10 || c.Trades != 4 {
+		t.Fatalf("Expected a 1s candle of 100/105/95/101 with 10 units in 4 trades, instead %s", c)
+	}
+	if len(a.C) != 0 {
+		t.Fatal("Expected the 1m candle to still be open")
+	}
+
+	if current, ok := a.Current(time.Minute); !ok || current.Trades != 5 || current.Close != 110 {
+		t.Fatalf("Expected the current 1m candle to have 5 trades closing at 110, instead %s", current)
+	}
+
+	a.Advance(time.Unix(120, 0))
+
+	c = receive(t, a)
+	if c.Granularity != time.Second || !c.Start.Equal(time.Unix(62, 0)) {
+		t.Fatalf("Expected the 1s candle at 62 to finish next, instead %s", c)
+	}
+	c = receive(t, a)
+	if c.Granularity != time.Minute || c.Open != 100 || c.High != 110 || c.Close != 110 || c.Volume != 15 {
+		t.Fatalf("Expected a 1m candle of 100/110/95/110 with 15 units, instead %s", c)
+	}
+}
+
+func TestAmendingCandlesWithLateTrades(t *testing.T) {
+	a := NewCandleAggregator([]time.Duration{time.Second, time.Minute}, 2*time.Second, 100)
+
+	a.Add(Trade{ID: 1, Price: 100, Size: 1, Time: time.Unix(60, 500)})
+	a.Add(Trade{ID: 2, Price: 102, Size: 1, Time: time.Unix(61, 500)})
+
+	c := receive(t, a)
+	if c.Revision != 0 || c.Close != 100 {
+		t.Fatalf("Expected first revision of the 1s candle at 60, instead %s", c)
+	}
+
+	// Late, but within the grace window
+	a.Add(Trade{ID: 3, Price: 90, Size: 2, Time: time.Unix(60, 100)})
+
+	c = receive(t, a)
+	if c.Revision != 1 || c.Open != 90 || c.Low != 90 || c.Close != 100 || c.Volume != 3 {
+		t.Fatalf("Expected an amended 1s candle opening at 90, instead %s", c)
+	}
+
+	a.Add(Trade{ID: 4, Price: 103, Size: 1, Time: time.Unix(63, 0)})
+	receive(t, a)
+
+	// Too late for the 1s candle but not for the 1m one
+	a.Add(Trade{ID: 5, Price: 80, Size: 1, Time: time.Unix(60, 200)})
+
+	if a.LateTrades != 1 {
+		t.Fatalf("Expected 1 late trade, instead %d", a.LateTrades)
+	}
+	if len(a.C) != 0 {
+		t.Fatalf("Expected nothing to be amended, instead %s", <-a.C)
+	}
+	if current, _ := a.Current(time.Minute); current.Low != 80 || current.Open != 90 || current.Trades != 5 {
+		t.Fatalf("Expected the 1m candle to take the late trade, instead %s", current)
+	}
+}
+
+func TestCandlesFromTape(t *testing.T) {
+	tape := NewTradeTape(10)
+	a := NewCandleAggregator(DEFAULT_CANDLE_GRANULARITIES, 0, 10)
+	tape.OnTrade = a.Add
+
+	tape.Record(Trade{ID: 1, Price: 100, Size: 1, Time: time.Unix(0, 0)})
+	tape.Record(Trade{ID: 1, Price: 100, Size: 1, Time: time.Unix(0, 0)})
+
+	if current, _ := a.Current(time.Hour); current.Trades != 1 {
+		t.Fatalf("Expected a trade seen twice to count once, instead %d", current.Trades)
+	}
+}
+
+func TestDroppingCandles(t *testing.T) {
+	a := NewCandleAggregator([]time.Duration{time.Second}, 0, 1)
+
+	for i := int64(0); i < 3; i++ {
+		a.Add(Trade{ID: i, Price: 100, Size: 1, Time: time.Unix(i, 0)})
+	}
+
+	if a.Dropped != 1 {
+		t.Fatalf("Expected 1 candle to be dropped from a full channel, instead %d", a.Dropped)
+	}
+}
+
+func TestClosingCandleAggregators(t *testing.T) {
+	a := NewCandleAggregator([]time.Duration{time.Second, time.Minute}, 0, 10)
+
+	a.Add(Trade{ID: 1, Price: 100, Size: 1, Time: time.Unix(60, 0)})
+	a.Add(Trade{ID: 2, Price: 101, Size: 1, Time: time.Unix(61, 500000000)})
+	a.Close()
+
+	// Ignored once closed
+	a.Add(Trade{ID: 3, Price: 102, Size: 1, Time: time.Unix(62, 0)})
+	a.Close()
+
+	candles := make([]Candle, 0)
+	for candle := range a.C {
+		candles = append(candles, candle)
+	}
+
+	if len(candles) != 3 {
+		t.Fatalf("Expected the finished second and the two in progress, instead %v", candles)
+	}
+	if candles[0].Partial || candles[0].Start.Unix() != 60 {
+		t.Fatalf("Expected the bar at 60s to have finished before closing, instead %s", candles[0])
+	}
+	if !candles[1].Partial || candles[1].Start.Unix() != 61 || candles[1].Close != 101 {
+		t.Fatalf("Expected the bar at 61s to be sent partial, instead %s", candles[1])
+	}
+	if !candles[2].Partial || candles[2].Granularity != time.Minute || candles[2].Trades != 2 {
+		t.Fatalf("Expected the minute bar to be sent partial with both trades, instead %s", candles[2])
+	}
+}
@@ -0,0 +1,94 @@
+package book
+
+import "errors"
+import "math"
+import "strconv"
+import "strings"
+
+var (
+	errInvalidDecimal    = errors.New("Not a decimal number.")
+	errDecimalTooPrecise = errors.New("Decimal has more precision than its minor unit.")
+	errDecimalOutOfRange = errors.New("Decimal is out of range.")
+)
+
+// ParseDecimal turns a decimal string like "400.23" into an integer number
+// of minor units with the given number of decimal places, e.g. 40023 for two
+// decimals, without going through a float. Trailing zeros past the minor unit
+// are fine; any other digit there is an error rather than being truncated.
+func ParseDecimal(s string, decimals int) (int64, error) {
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	}
+
+	whole, fraction := s, ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		whole, fraction = s[:dot], s[dot+1:]
+		if fraction == "" {
+			return 0, errInvalidDecimal
+		}
+	}
+
+	if whole == "" || !isDigits(whole) || !isDigits(fraction) {
+		return 0, errInvalidDecimal
+	}
+
+	if len(fraction) > decimals {
+		if strings.TrimRight(fraction[decimals:], "0") != "" {
+			return 0, errDecimalTooPrecise
+		}
+		fraction = fraction[:decimals]
+	}
+
+	fraction += strings.Repeat("0", decimals-len(fraction))
+
+	// Parse the whole thing as one integer so overflow is caught in one place
+	digits := strings.TrimLeft(whole+fraction, "0")
+	if digits == "" {
+		return 0, nil
+	}
+
+	v, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || (v > math.MaxInt64 && !(negative && v == math.MaxInt64+1)) {
+		return 0, errDecimalOutOfRange
+	}
+
+	if negative {
+		return -int64(v), nil
+	}
+
+	return int64(v), nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatDecimal renders an integer number of minor units as a decimal string
+// with the given number of decimal places, e.g. FormatDecimal(1050, 2) is "10.50".
+func FormatDecimal(v int64, decimals int) string {
+	sign := ""
+	u := uint64(v)
+	if v < 0 {
+		sign = "-"
+		u = -u
+	}
+
+	digits := strconv.FormatUint(u, 10)
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+
+	whole := digits[:len(digits)-decimals]
+	if decimals == 0 {
+		return sign + whole
+	}
+
+	return sign + whole + "." + digits[len(digits)-decimals:]
+}
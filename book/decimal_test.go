@@ -0,0 +1,139 @@
+package book
+
+import "fmt"
+import "math"
+import "strings"
+import "testing"
+import "testing/quick"
+
+func TestParsingDecimals(t *testing.T) {
+	cases := []struct {
+		s        string
+		decimals int
+		expected int64
+		err      error
+	}{
+		{"0.29", 2, 29, nil},
+		{"0.10", 2, 10, nil},
+		{"400.23", 2, 40023, nil},
+		{"400.05000000", 2, 40005, nil},
+		{"200.2", 2, 20020, nil},
+		{"7", 2, 700, nil},
+		{"0", 8, 0, nil},
+		{"-1.5", 2, -150, nil},
+		{"5.23512", 8, 523512000, nil},
+		{"0.00000001", 8, 1, nil},
+		{"12.234412", 8, 1223441200, nil},
+		{"92233720368.54775807", 8, math.MaxInt64, nil},
+		{"-92233720368.54775808", 8, math.MinInt64, nil},
+		{"0.105", 2, 0, errDecimalTooPrecise},
+		{"0.000000001", 8, 0, errDecimalTooPrecise},
+		{"92233720368.54775808", 8, 0, errDecimalOutOfRange},
+		{"", 2, 0, errInvalidDecimal},
+		{".5", 2, 0, errInvalidDecimal},
+		{"5.", 2, 0, errInvalidDecimal},
+		{"1e5", 2, 0, errInvalidDecimal},
+		{"+1", 2, 0, errInvalidDecimal},
+		{"1.2.3", 2, 0, errInvalidDecimal},
+		{"NaN", 2, 0, errInvalidDecimal},
+	}
+
+	for _, c := range cases {
+		v, err := ParseDecimal(c.s, c.decimals)
+		if err != c.err {
+			t.Fatalf("Expected parsing %q to return error %v, instead %v", c.s, c.err, err)
+		}
+		if v != c.expected {
+			t.Fatalf("Expected %q to parse as %d, instead %d", c.s, c.expected, v)
+		}
+	}
+}
+
+func TestFormattingDecimals(t *testing.T) {
+	cases := map[int64]string{
+		0:             "0.00",
+		5:             "0.05",
+		1050:          "10.50",
+		-1050:         "-10.50",
+		1234567:       "12345.67",
+		math.MinInt64: "-92233720368547758.08",
+	}
+
+	for v, expected := range cases {
+		if FormatDecimal(v, 2) != expected {
+			t.Fatalf("Expected %d to format as %s, instead %s", v, expected, FormatDecimal(v, 2))
+		}
+	}
+
+	if FormatDecimal(42, 0) != "42" {
+		t.Fatalf("Expected 42 with no decimals to format as 42, instead %s", FormatDecimal(42, 0))
+	}
+}
+
+func TestDecimalRoundTrip(t *testing.T) {
+	roundTrips := func(v int64, decimals uint8) bool {
+		d := int(decimals % 19)
+		parsed, err := ParseDecimal(FormatDecimal(v, d), d)
+		return err == nil && parsed == v
+	}
+
+	edges := []struct {
+		v        int64
+		decimals uint8
+	}{
+		{0, 2},
+		{29, 2},
+		{523512000, 8},
+		{math.MaxInt64, 8},
+		{math.MinInt64, 0},
+		{math.MinInt64, 18},
+	}
+
+	for _, c := range edges {
+		if !roundTrips(c.v, c.decimals) {
+			t.Fatalf("Expected %d with %d decimals to round trip, instead %s", c.v, c.decimals, FormatDecimal(c.v, int(c.decimals)))
+		}
+	}
+
+	if err := quick.Check(roundTrips, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParsedDecimalsRoundTrip(t *testing.T) {
+	// Anything accepted must format and parse back to the same value
+	roundTrips := func(s string, decimals uint8) bool {
+		d := int(decimals % 19)
+
+		v, err := ParseDecimal(s, d)
+		if err != nil {
+			return true
+		}
+
+		again, err := ParseDecimal(FormatDecimal(v, d), d)
+		return err == nil && again == v
+	}
+
+	for _, s := range []string{"0.29", "400.05000000", "-1.5", "1e5", "00.10", "-0"} {
+		if !roundTrips(s, 2) || !roundTrips(s, 8) {
+			t.Fatalf("Expected %s to round trip", s)
+		}
+	}
+
+	if err := quick.Check(roundTrips, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Arbitrary strings are almost never decimals, so try some that are
+	decimal := func(negative bool, whole, fraction uint32, zeros, decimals uint8) bool {
+		s := fmt.Sprintf("%d.%s%d", whole, strings.Repeat("0", int(zeros%8)), fraction)
+		if negative {
+			s = "-" + s
+		}
+		return roundTrips(s, decimals)
+	}
+
+	if err := quick.Check(decimal, nil); err != nil {
+		t.Fatal(err)
+	}
+}
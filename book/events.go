@@ -0,0 +1,359 @@
+package book
+
+import "fmt"
+import "sync"
+import "sync/atomic"
+import "time"
+
+const (
+	// Drop events that don't fit in a subscriber's buffer, counting them in Dropped
+	SLOW_CONSUMER_DROP = "drop"
+	// Close the subscriber's channel and forget about it once its buffer is full
+	SLOW_CONSUMER_DISCONNECT = "disconnect"
+	// Wait for the subscriber to make room or unsubscribe, holding up whoever is
+	// changing the book
+	SLOW_CONSUMER_BLOCK = "block"
+)
+
+// BookEvent is something that changed in the book. Every event happens on
+// one side of the book at one price, which is what subscriptions filter on.
+type BookEvent interface {
+	GetTime() time.Time
+	GetSide() string
+	GetPrice() int64
+}
+
+// OrderAddedEvent is sent when an order is placed.
+type OrderAddedEvent struct {
+	Order *StatefulOrder
+}
+
+func (e *OrderAddedEvent) String() string {
+	return fmt.Sprintf("<OrderAddedEvent of %s>", e.Order.String())
+}
+
+func (e *OrderAddedEvent) GetTime() time.Time { return e.Order.LatestMutationTime }
+func (e *OrderAddedEvent) GetSide() string    { return e.Order.Side }
+func (e *OrderAddedEvent) GetPrice() int64    { return e.Order.Price }
+
+// OrderSizeChangedEvent is sent when the size of an order changes.
+type OrderSizeChangedEvent struct {
+	Order        *StatefulOrder
+	PreviousSize int64
+}
+
+func (e *OrderSizeChangedEvent) String() string {
+	return fmt.Sprintf("<OrderSizeChangedEvent from %d units of %s>", e.PreviousSize, e.Order.String())
+}
+
+func (e *OrderSizeChangedEvent) GetTime() time.Time { return e.Order.LatestMutationTime }
+func (e *OrderSizeChangedEvent) GetSide() string    { return e.Order.Side }
+func (e *OrderSizeChangedEvent) GetPrice() int64    { return e.Order.Price }
+
+// OrderStateChangedEvent is sent when an order opens, fills or is voided.
+type OrderStateChangedEvent struct {
+	Order         *StatefulOrder
+	PreviousState string
+}
+
+func (e *OrderStateChangedEvent) String() string {
+	return fmt.Sprintf("<OrderStateChangedEvent from '%s' of %s>", e.PreviousState, e.Order.String())
+}
+
+func (e *OrderStateChangedEvent) GetTime() time.Time { return e.Order.LatestMutationTime }
+func (e *OrderStateChangedEvent) GetSide() string    { return e.Order.Side }
+func (e *OrderStateChangedEvent) GetPrice() int64    { return e.Order.Price }
+
+// LevelCreatedEvent is sent when the first order at a price opens.
+type LevelCreatedEvent struct {
+	Side  string
+	Level AggregatedPriceLevel
+	Time  time.Time
+}
+
+func (e *LevelCreatedEvent) String() string {
+	return fmt.Sprintf("<LevelCreatedEvent of %s %s>", e.Side, e.Level.String())
+}
+
+func (e *LevelCreatedEvent) GetTime() time.Time { return e.Time }
+func (e *LevelCreatedEvent) GetSide() string    { return e.Side }
+func (e *LevelCreatedEvent) GetPrice() int64    { return e.Level.Price }
+
+// LevelRemovedEvent is sent when the last open order at a price goes away.
+type LevelRemovedEvent struct {
+	Side  string
+	Price int64
+	Time  time.Time
+}
+
+func (e *LevelRemovedEvent) String() string {
+	return fmt.Sprintf("<LevelRemovedEvent of %s level at price %d>", e.Side, e.Price)
+}
+
+func (e *LevelRemovedEvent) GetTime() time.Time { return e.Time }
+func (e *LevelRemovedEvent) GetSide() string    { return e.Side }
+func (e *LevelRemovedEvent) GetPrice() int64    { return e.Price }
+
+// BestPriceChangedEvent is sent when the best bid or ask moves. Ok is false
+// when the side has emptied out, in which case Price is meaningless.
+type BestPriceChangedEvent struct {
+	Side          string
+	Price         int64
+	Ok            bool
+	PreviousPrice int64
+	PreviousOk    bool
+	Time          time.Time
+}
+
+func (e *BestPriceChangedEvent) String() string {
+	return fmt.Sprintf("<BestPriceChangedEvent of %s from %d to %d>", e.Side, e.PreviousPrice, e.Price)
+}
+
+func (e *BestPriceChangedEvent) GetTime() time.Time { return e.Time }
+func (e *BestPriceChangedEvent) GetSide() string    { return e.Side }
+func (e *BestPriceChangedEvent) GetPrice() int64    { return e.Price }
+
+// BookResetEvent is sent to every subscriber, whatever its filter, when the
+// book is replaced wholesale, for example after resynchronizing with an
+// exchange. Nothing is sent for the orders that changed along the way, so
+// subscribers keeping their own view of the book should rebuild it.
+type BookResetEvent struct {
+	Time time.Time
+}
+
+func (e *BookResetEvent) String() string {
+	return fmt.Sprintf("<BookResetEvent at %s>", e.Time)
+}
+
+func (e *BookResetEvent) GetTime() time.Time { return e.Time }
+func (e *BookResetEvent) GetSide() string    { return "" }
+func (e *BookResetEvent) GetPrice() int64    { return 0 }
+
+// TradeEvent is sent when a resting order is matched. Side and Price are the
+// maker's.
+type TradeEvent struct {
+	TradeID int64
+	MakerID OrderID
+	Side    string
+	Price   int64
+	Size    int64
+	Time    time.Time
+}
+
+func (e *TradeEvent) String() string {
+	return fmt.Sprintf("<TradeEvent of %d units at price %d; trade id=%d>", e.Size, e.Price, e.TradeID)
+}
+
+func (e *TradeEvent) GetTime() time.Time { return e.Time }
+func (e *TradeEvent) GetSide() string    { return e.Side }
+func (e *TradeEvent) GetPrice() int64    { return e.Price }
+
+// SubscriptionFilter picks the events a subscriber gets. An empty Side means
+// both sides, and a MaxPrice of zero means no upper bound.
+type SubscriptionFilter struct {
+	Side     string
+	MinPrice int64
+	MaxPrice int64
+}
+
+func (f SubscriptionFilter) matches(e BookEvent) bool {
+	if f.Side != "" && f.Side != e.GetSide() {
+		return false
+	}
+	if e.GetPrice() < f.MinPrice {
+		return false
+	}
+	if f.MaxPrice != 0 && e.GetPrice() > f.MaxPrice {
+		return false
+	}
+	return true
+}
+
+// Subscription delivers book events on C until it is unsubscribed, or
+// disconnected for being too slow, at which point C is closed. Read Dropped
+// with atomic.LoadInt64.
+type Subscription struct {
+	C       <-chan BookEvent
+	Dropped int64
+
+	events chan BookEvent
+	filter SubscriptionFilter
+	policy string
+
+	// done is closed on Unsubscribe, before waiting for the book's lock, so
+	// that a blocked send gives up and lets go of it
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func (s *Subscription) String() string {
+	return fmt.Sprintf("<Subscription with policy '%s'; %d events dropped>", s.policy, atomic.LoadInt64(&s.Dropped))
+}
+
+// cancel stops any send to s that is waiting, now or later. It doesn't need
+// the book's lock.
+func (s *Subscription) cancel() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// send returns false if the subscriber has to be disconnected.
+func (s *Subscription) send(e BookEvent) bool {
+	if _, reset := e.(*BookResetEvent); !reset && !s.filter.matches(e) {
+		return true
+	}
+
+	if s.policy == SLOW_CONSUMER_BLOCK {
+		select {
+		case s.events <- e:
+			return true
+		case <-s.done:
+			return false
+		}
+	}
+
+	select {
+	case s.events <- e:
+		return true
+	default:
+	}
+
+	if s.policy == SLOW_CONSUMER_DISCONNECT {
+		return false
+	}
+
+	atomic.AddInt64(&s.Dropped, 1)
+	return true
+}
+
+// Subscribe starts sending the events that match filter to a channel buffering
+// bufLen of them, dealing with a full buffer according to policy. Like every
+// other change to the book, it must not be called concurrently with one.
+func (book *InMemoryOrderBook) Subscribe(filter SubscriptionFilter, bufLen int, policy string) *Subscription {
+	events := make(chan BookEvent, bufLen)
+	sub := &Subscription{C: events, events: events, filter: filter, policy: policy, done: make(chan struct{})}
+	book.subscribers = append(book.subscribers, sub)
+	return sub
+}
+
+// Unsubscribe stops sending events to sub and closes its channel.
+func (book *InMemoryOrderBook) Unsubscribe(sub *Subscription) {
+	sub.cancel()
+
+	for i, s := range book.subscribers {
+		if s == sub {
+			book.subscribers = append(book.subscribers[:i], book.subscribers[i+1:]...)
+			close(sub.events)
+			return
+		}
+	}
+}
+
+func (book *InMemoryOrderBook) publish(events []BookEvent) {
+	for _, e := range events {
+		for i := 0; i < len(book.subscribers); i++ {
+			sub := book.subscribers[i]
+			if !sub.send(e) {
+				book.Unsubscribe(sub)
+				i -= 1
+			}
+		}
+	}
+}
+
+// bookChange collects the events for one change to an order while the book's
+// level index is updated from previous to latest.
+type bookChange struct {
+	book    *InMemoryOrderBook
+	bestBid int64
+	bidOk   bool
+	bestAsk int64
+	askOk   bool
+	levels  []levelPresence
+}
+
+// levelPresence is whether there was a level at a price before the change.
+type levelPresence struct {
+	side  string
+	price int64
+	had   bool
+}
+
+// beginChange notes the state of the levels an order change can touch. It
+// returns nil when nobody is listening, so the bookkeeping is free.
+func (book *InMemoryOrderBook) beginChange(previous, latest *StatefulOrder) *bookChange {
+	if len(book.subscribers) == 0 {
+		return nil
+	}
+
+	c := &bookChange{book: book}
+	c.bestBid, c.bidOk = book.BestBid()
+	c.bestAsk, c.askOk = book.BestAsk()
+
+	for _, order := range []*StatefulOrder{previous, latest} {
+		if order == nil || book.levels.side(order.Side) == nil {
+			continue
+		}
+		if len(c.levels) > 0 && c.levels[0].side == order.Side && c.levels[0].price == order.Price {
+			continue
+		}
+
+		_, had := book.levels.side(order.Side).levels[order.Price]
+		c.levels = append(c.levels, levelPresence{order.Side, order.Price, had})
+	}
+
+	return c
+}
+
+// finish publishes the events for an order that went from previous to
+// latest through muts. previous is nil for a new order.
+func (c *bookChange) finish(previous, latest *StatefulOrder, muts []OrderMutation) {
+	if c == nil {
+		return
+	}
+
+	events := make([]BookEvent, 0)
+	t := latest.LatestMutationTime
+
+	if previous == nil {
+		events = append(events, &OrderAddedEvent{Order: latest})
+	} else {
+		for _, mut := range muts {
+			if match, ok := mut.(*OrderMatchMutation); ok && match.WasMaker {
+				trade := TradeFromMatch(previous, match)
+				events = append(events, &TradeEvent{
+					TradeID: trade.ID,
+					MakerID: trade.MakerID,
+					Side:    latest.Side,
+					Price:   trade.Price,
+					Size:    trade.Size,
+					Time:    trade.Time,
+				})
+			}
+		}
+
+		if previous.Size != latest.Size {
+			events = append(events, &OrderSizeChangedEvent{Order: latest, PreviousSize: previous.Size})
+		}
+		if previous.State != latest.State {
+			events = append(events, &OrderStateChangedEvent{Order: latest, PreviousState: previous.State})
+		}
+	}
+
+	for _, level := range c.levels {
+		node, has := c.book.levels.side(level.side).levels[level.price]
+		if has && !level.had {
+			events = append(events, &LevelCreatedEvent{Side: level.side, Level: node.level, Time: t})
+		} else if level.had && !has {
+			events = append(events, &LevelRemovedEvent{Side: level.side, Price: level.price, Time: t})
+		}
+	}
+
+	if bid, ok := c.book.BestBid(); bid != c.bestBid || ok != c.bidOk {
+		events = append(events, &BestPriceChangedEvent{Side: SIDE_BUY, Price: bid, Ok: ok, PreviousPrice: c.bestBid, PreviousOk: c.bidOk, Time: t})
+	}
+	if ask, ok := c.book.BestAsk(); ask != c.bestAsk || ok != c.askOk {
+		events = append(events, &BestPriceChangedEvent{Side: SIDE_SELL, Price: ask, Ok: ok, PreviousPrice: c.bestAsk, PreviousOk: c.askOk, Time: t})
+	}
+
+	c.book.publish(events)
+}
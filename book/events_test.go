@@ -0,0 +1,143 @@
+package book
+
+import "testing"
+import "time"
+
+// drain reads whatever is buffered in a subscription without blocking.
+func drain(sub *Subscription) []BookEvent {
+	events := make([]BookEvent, 0)
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestBookEvents(t *testing.T) {
+	book := NewInMemoryOrderBook()
+	sub := book.Subscribe(SubscriptionFilter{}, 100, SLOW_CONSUMER_DROP)
+
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+
+	events := drain(sub)
+	if len(events) != 1 {
+		t.Fatalf("Expected a single event for placing an order, instead %v", events)
+	}
+	if added, ok := events[0].(*OrderAddedEvent); !ok || added.Order.ID != "a" {
+		t.Fatalf("Expected order a to be added, instead %s", events[0])
+	}
+
+	book.MutateOrder("a", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(1, 0)}})
+
+	events = drain(sub)
+	if len(events) != 3 {
+		t.Fatalf("Expected state, level and best bid events for opening an order, instead %v", events)
+	}
+	if changed, ok := events[0].(*OrderStateChangedEvent); !ok || changed.PreviousState != STATE_PENDING || changed.Order.State != STATE_OPEN {
+		t.Fatalf("Expected order a to go from pending to open, instead %s", events[0])
+	}
+	if created, ok := events[1].(*LevelCreatedEvent); !ok || created.Level != (AggregatedPriceLevel{100, 10, 1}) {
+		t.Fatalf("Expected level 100 to be created, instead %s", events[1])
+	}
+	if best, ok := events[2].(*BestPriceChangedEvent); !ok || best.Side != SIDE_BUY || best.Price != 100 || !best.Ok || best.PreviousOk {
+		t.Fatalf("Expected best bid to become 100, instead %s", events[2])
+	}
+
+	book.MutateOrder("a", []OrderMutation{&OrderMatchMutation{TradeID: 7, Size: 4, WasMaker: true, Time: time.Unix(2, 0)}})
+
+	events = drain(sub)
+	if len(events) != 2 {
+		t.Fatalf("Expected trade and size events for a partial fill, instead %v", events)
+	}
+	if trade, ok := events[0].(*TradeEvent); !ok || trade.TradeID != 7 || trade.Size != 4 || trade.Price != 100 || trade.MakerID != "a" {
+		t.Fatalf("Expected trade 7 of 4 units at 100, instead %s", events[0])
+	}
+	if changed, ok := events[1].(*OrderSizeChangedEvent); !ok || changed.PreviousSize != 10 || changed.Order.Size != 6 {
+		t.Fatalf("Expected size to go from 10 to 6, instead %s", events[1])
+	}
+
+	book.MutateOrder("a", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(3, 0)}})
+
+	events = drain(sub)
+	if len(events) != 3 {
+		t.Fatalf("Expected state, level and best bid events for voiding an order, instead %v", events)
+	}
+	if removed, ok := events[1].(*LevelRemovedEvent); !ok || removed.Price != 100 {
+		t.Fatalf("Expected level 100 to be removed, instead %s", events[1])
+	}
+	if best, ok := events[2].(*BestPriceChangedEvent); !ok || best.Ok || best.PreviousPrice != 100 {
+		t.Fatalf("Expected bids to empty out, instead %s", events[2])
+	}
+
+	book.Unsubscribe(sub)
+
+	if _, ok := <-sub.C; ok {
+		t.Fatal("Expected channel to be closed after unsubscribing")
+	}
+}
+
+func TestFilteringBookEvents(t *testing.T) {
+	book := NewInMemoryOrderBook()
+	asks := book.Subscribe(SubscriptionFilter{Side: SIDE_SELL}, 100, SLOW_CONSUMER_DROP)
+	near := book.Subscribe(SubscriptionFilter{MinPrice: 99, MaxPrice: 101}, 100, SLOW_CONSUMER_DROP)
+
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "b", Price: 105, Side: SIDE_SELL}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "c", Price: 101, Side: SIDE_SELL}, 10, time.Unix(0, 0))
+
+	events := drain(asks)
+	if len(events) != 2 || events[0].GetPrice() != 105 || events[1].GetPrice() != 101 {
+		t.Fatalf("Expected only the two asks, instead %v", events)
+	}
+
+	events = drain(near)
+	if len(events) != 2 || events[0].GetPrice() != 100 || events[1].GetPrice() != 101 {
+		t.Fatalf("Expected only orders between 99 and 101, instead %v", events)
+	}
+}
+
+func TestSlowConsumers(t *testing.T) {
+	book := NewInMemoryOrderBook()
+	dropping := book.Subscribe(SubscriptionFilter{}, 1, SLOW_CONSUMER_DROP)
+	disconnecting := book.Subscribe(SubscriptionFilter{}, 1, SLOW_CONSUMER_DISCONNECT)
+	blocking := book.Subscribe(SubscriptionFilter{}, 1, SLOW_CONSUMER_BLOCK)
+
+	received := make(chan int)
+	go func() {
+		n := 0
+		for range blocking.C {
+			n += 1
+		}
+		received <- n
+	}()
+
+	for _, id := range []OrderID{"a", "b", "c"} {
+		book.PlaceOrder(Order{ID: id, Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	}
+
+	if dropping.Dropped != 2 || len(drain(dropping)) != 1 {
+		t.Fatalf("Expected dropping subscriber to keep 1 event and drop 2, instead dropped %d", dropping.Dropped)
+	}
+
+	if events := drain(disconnecting); len(events) != 1 {
+		t.Fatalf("Expected disconnected subscriber to get 1 event before its channel closed, instead %d", len(events))
+	}
+	if _, ok := <-disconnecting.C; ok {
+		t.Fatal("Expected disconnected subscriber's channel to be closed")
+	}
+	if len(book.subscribers) != 2 {
+		t.Fatalf("Expected 2 subscribers left, instead %d", len(book.subscribers))
+	}
+
+	book.Unsubscribe(blocking)
+
+	if n := <-received; n != 3 {
+		t.Fatalf("Expected blocking subscriber to get all 3 events, instead %d", n)
+	}
+}
@@ -0,0 +1,164 @@
+package book
+
+import "fmt"
+import "sort"
+import "time"
+
+const (
+	// An OrderHistory caches the version of its order after every this many mutations
+	ORDER_HISTORY_CHECKPOINT_INTERVAL = 16
+)
+
+// OrderCheckpoint is the version of an order after the first Applied of its
+// mutations, the last of which happened at Time.
+type OrderCheckpoint struct {
+	Applied int
+	Time    time.Time
+	Version *StatefulOrder
+}
+
+func (c *OrderCheckpoint) String() string {
+	return fmt.Sprintf("<OrderCheckpoint after %d mutations at %s>", c.Applied, c.Time.String())
+}
+
+// replayMutations applies muts to order in turn, skipping the ones that fail,
+// and keeps LatestMutationTime at the latest of the ones that applied.
+func replayMutations(order StatefulOrder, muts []OrderMutation) StatefulOrder {
+	// Makers may be shared with other versions; make sure appending to it copies
+	order.Makers = order.Makers[:len(order.Makers):len(order.Makers)]
+
+	for _, mutation := range muts {
+		porder, err := mutation.Apply(&order)
+
+		if err != nil {
+			continue
+		}
+
+		order = *porder
+
+		if order.LatestMutationTime.Before(mutation.GetTime()) {
+			order.LatestMutationTime = mutation.GetTime()
+		}
+	}
+
+	return order
+}
+
+// base is the order before any mutations were applied.
+func (h *OrderHistory) base() StatefulOrder {
+	order := *h.FirstVersion
+	if len(h.Mutations) > 0 {
+		order.LatestMutationTime = h.Mutations[0].GetTime()
+	}
+	return order
+}
+
+// checkpointBefore returns the latest version with at most n mutations applied
+// and the number of mutations it has.
+func (h *OrderHistory) checkpointBefore(n int) (StatefulOrder, int) {
+	i := sort.Search(len(h.Checkpoints), func(i int) bool { return h.Checkpoints[i].Applied > n })
+	if i == 0 {
+		return h.base(), 0
+	}
+	return *h.Checkpoints[i-1].Version, h.Checkpoints[i-1].Applied
+}
+
+// replayFrom applies every mutation from index from onwards to order, which
+// must have exactly the ones before it applied, checkpointing along the way.
+func (h *OrderHistory) replayFrom(order StatefulOrder, from int) StatefulOrder {
+	for i := from; i < len(h.Mutations); i++ {
+		order = replayMutations(order, h.Mutations[i:i+1])
+
+		applied := i + 1
+		if applied%ORDER_HISTORY_CHECKPOINT_INTERVAL == 0 {
+			version := order
+			version.Makers = version.Makers[:len(version.Makers):len(version.Makers)]
+
+			h.Checkpoints = append(h.Checkpoints, &OrderCheckpoint{
+				Applied: applied,
+				Time:    h.Mutations[i].GetTime(),
+				Version: &version,
+			})
+		}
+	}
+
+	return order
+}
+
+// mutate adds mutations to the history and returns the new latest version.
+// Mutations at or after the latest one are applied straight on top of
+// LatestVersion; earlier ones are inserted in time order, which throws away
+// the checkpoints after them and replays from the one before.
+func (h *OrderHistory) mutate(muts []OrderMutation) *StatefulOrder {
+	appended := len(h.Mutations)
+	dirty := appended
+
+	for _, mut := range muts {
+		n := len(h.Mutations)
+
+		if n == 0 || !mut.GetTime().Before(h.Mutations[n-1].GetTime()) {
+			h.Mutations = append(h.Mutations, mut)
+			continue
+		}
+
+		// After anything at the same time, like a stable sort would put it
+		pos := sort.Search(n, func(i int) bool { return h.Mutations[i].GetTime().After(mut.GetTime()) })
+
+		h.Mutations = append(h.Mutations, nil)
+		copy(h.Mutations[pos+1:], h.Mutations[pos:])
+		h.Mutations[pos] = mut
+
+		if pos < dirty {
+			dirty = pos
+		}
+	}
+
+	var order StatefulOrder
+
+	if dirty == appended && appended > 0 {
+		order = h.replayFrom(*h.LatestVersion, appended)
+	} else {
+		i := sort.Search(len(h.Checkpoints), func(i int) bool { return h.Checkpoints[i].Applied > dirty })
+		h.Checkpoints = h.Checkpoints[:i]
+
+		start, applied := h.checkpointBefore(dirty)
+		order = h.replayFrom(start, applied)
+	}
+
+	return &order
+}
+
+// copy returns a history with its own Mutations and Checkpoints, which mutate
+// changes in place, sharing the mutations and versions themselves.
+func (h *OrderHistory) copy() *OrderHistory {
+	return &OrderHistory{
+		Mutations:     append([]OrderMutation(nil), h.Mutations...),
+		FirstVersion:  h.FirstVersion,
+		LatestVersion: h.LatestVersion,
+		Checkpoints:   append([]*OrderCheckpoint(nil), h.Checkpoints...),
+	}
+}
+
+// VersionAt returns the order with all mutations less than or equal to t
+// applied. Don't modify it; it may be shared with other versions.
+func (h *OrderHistory) VersionAt(t time.Time) *StatefulOrder {
+	// Short circuit to avoid replaying mutations
+	if t.After(h.LatestVersion.LatestMutationTime) {
+		return h.LatestVersion
+	}
+
+	n := sort.Search(len(h.Mutations), func(i int) bool { return h.Mutations[i].GetTime().After(t) })
+
+	if n == len(h.Mutations) {
+		return h.LatestVersion
+	}
+	if n == 0 {
+		order := *h.FirstVersion
+		return &order
+	}
+
+	start, applied := h.checkpointBefore(n)
+	order := replayMutations(start, h.Mutations[applied:n])
+
+	return &order
+}
@@ -0,0 +1,154 @@
+package book
+
+import "math/rand"
+import "sort"
+import "testing"
+import "time"
+
+// naiveVersion replays every mutation at or before t from the first version,
+// which is what OrderHistory did before it had checkpoints.
+func naiveVersion(first StatefulOrder, muts []OrderMutation, t time.Time) StatefulOrder {
+	filtered := make([]OrderMutation, 0)
+	for _, mut := range muts {
+		if !mut.GetTime().After(t) {
+			filtered = append(filtered, mut)
+		}
+	}
+	sort.Stable(OrderMutationByTime(filtered))
+
+	if len(filtered) == 0 {
+		return first
+	}
+
+	first.LatestMutationTime = filtered[0].GetTime()
+	return replayMutations(first, filtered)
+}
+
+func sameVersion(a, b *StatefulOrder) bool {
+	if a.Size != b.Size || a.State != b.State || !a.LatestMutationTime.Equal(b.LatestMutationTime) || len(a.Makers) != len(b.Makers) {
+		return false
+	}
+	for i := range a.Makers {
+		if a.Makers[i] != b.Makers[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCheckpointedHistoryMatchesReplay(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+
+	for round := 0; round < 20; round++ {
+		book := NewInMemoryOrderBook()
+		book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 100000, time.Unix(1000, 0))
+
+		muts := []OrderMutation{&OrderStateMutation{State: STATE_PENDING, Time: time.Unix(1000, 0)}}
+		clock := int64(1000)
+
+		for i := 0; i < 200; i++ {
+			clock += r.Int63n(3)
+			at := clock
+
+			// Every so often something arrives late
+			if r.Intn(5) == 0 {
+				at = 1000 + r.Int63n(clock-999)
+			}
+
+			var mut OrderMutation
+			switch r.Intn(3) {
+			case 0:
+				mut = &OrderStateMutation{State: STATE_OPEN, Time: time.Unix(at, 0)}
+			case 1:
+				mut = &OrderMatchMutation{Size: r.Int63n(1500), MakerID: OrderID(string(rune('a' + i%26))), Time: time.Unix(at, 0)}
+			default:
+				mut = &OrderSizeMutation{NewSize: r.Int63n(100000), Time: time.Unix(at, 0)}
+			}
+
+			muts = append(muts, mut)
+			book.MutateOrder("a", []OrderMutation{mut})
+
+			history := book.Book["a"]
+			expected := naiveVersion(*history.FirstVersion, muts, time.Unix(clock+1, 0))
+			if !sameVersion(history.LatestVersion, &expected) {
+				t.Fatalf("Expected latest version %s after %d mutations, instead %s", &expected, i+1, history.LatestVersion)
+			}
+		}
+
+		for at := int64(999); at <= clock+1; at++ {
+			order, _ := book.GetOrderVersion("a", time.Unix(at, 0))
+			expected := naiveVersion(*book.Book["a"].FirstVersion, muts, time.Unix(at, 0))
+
+			if !sameVersion(order, &expected) {
+				t.Fatalf("Expected version %s at t=%d, instead %s", &expected, at, order)
+			}
+		}
+	}
+}
+
+func TestVersionAt(t *testing.T) {
+	book := NewInMemoryOrderBook()
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 10, time.Unix(1, 0))
+	book.MutateOrder("a", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(2, 0)}})
+	book.MutateOrder("a", []OrderMutation{&OrderMatchMutation{Size: 4, WasMaker: true, Time: time.Unix(3, 0)}})
+
+	history := book.Book["a"]
+
+	if order := history.VersionAt(time.Unix(0, 0)); order == history.FirstVersion || order.State != STATE_PENDING || order.Size != 10 {
+		t.Fatalf("Expected a copy of the first version before any mutations, instead %s", order)
+	}
+	if order := history.VersionAt(time.Unix(2, 0)); order.State != STATE_OPEN || order.Size != 10 {
+		t.Fatalf("Expected an open order of 10 units at t=2, instead %s", order)
+	}
+	if order := history.VersionAt(time.Unix(3, 0)); order != history.LatestVersion {
+		t.Fatalf("Expected the latest version at the last mutation, instead %s", order)
+	}
+	if order := history.VersionAt(time.Unix(10, 0)); order != history.LatestVersion || order.Size != 6 {
+		t.Fatalf("Expected the latest version of 6 units after every mutation, instead %s", order)
+	}
+}
+
+func TestCheckpointing(t *testing.T) {
+	book := NewInMemoryOrderBook()
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 1000, time.Unix(0, 0))
+
+	for i := 1; i < 4*ORDER_HISTORY_CHECKPOINT_INTERVAL; i++ {
+		book.MutateOrder("a", []OrderMutation{&OrderMatchMutation{Size: 1, WasMaker: true, Time: time.Unix(int64(i), 0)}})
+	}
+
+	history := book.Book["a"]
+
+	// Including the pending mutation from placing it
+	if len(history.Checkpoints) != 4 {
+		t.Fatalf("Expected 4 checkpoints, instead %d", len(history.Checkpoints))
+	}
+	if history.Checkpoints[1].Applied != 2*ORDER_HISTORY_CHECKPOINT_INTERVAL || history.Checkpoints[1].Version.Size != 1000-2*ORDER_HISTORY_CHECKPOINT_INTERVAL+1 {
+		t.Fatalf("Expected second checkpoint after %d mutations, instead %s with %d units", 2*ORDER_HISTORY_CHECKPOINT_INTERVAL, history.Checkpoints[1], history.Checkpoints[1].Version.Size)
+	}
+
+	// A late fill invalidates every checkpoint after it
+	book.MutateOrder("a", []OrderMutation{&OrderMatchMutation{Size: 100, WasMaker: true, Time: time.Unix(20, 500)}})
+
+	if history.Checkpoints[0].Version.Size != 1000-ORDER_HISTORY_CHECKPOINT_INTERVAL+1 {
+		t.Fatalf("Expected first checkpoint to be kept, instead %d units", history.Checkpoints[0].Version.Size)
+	}
+	if history.Checkpoints[len(history.Checkpoints)-1].Version.Size != 1000-4*ORDER_HISTORY_CHECKPOINT_INTERVAL+2-100 {
+		t.Fatalf("Expected last checkpoint to include the late fill, instead %d units", history.Checkpoints[len(history.Checkpoints)-1].Version.Size)
+	}
+
+	order, _ := book.GetOrder("a")
+	if order.Size != 1000-4*ORDER_HISTORY_CHECKPOINT_INTERVAL+1-100 {
+		t.Fatalf("Expected latest version to include the late fill, instead %d units", order.Size)
+	}
+}
+
+// BenchmarkLongLivedOrder partially fills a single order over and over.
+func BenchmarkLongLivedOrder(b *testing.B) {
+	book := NewInMemoryOrderBook()
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, int64(b.N)+1, time.Unix(0, 0))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		book.MutateOrder("a", []OrderMutation{&OrderMatchMutation{Size: 1, WasMaker: true, Time: time.Unix(int64(i), 0)}})
+	}
+}
@@ -0,0 +1,195 @@
+package book
+
+import "fmt"
+import "math/rand"
+
+const (
+	LEVEL_INDEX_MAX_HEIGHT = 24
+)
+
+// AggregatedPriceLevel is the total size and number of the open orders
+// resting at one price on one side of the book.
+type AggregatedPriceLevel struct {
+	Price  int64
+	Size   int64
+	Orders int64
+}
+
+func (l AggregatedPriceLevel) String() string {
+	return fmt.Sprintf("<AggregatedPriceLevel at price %d; %d units in %d orders>", l.Price, l.Size, l.Orders)
+}
+
+type levelNode struct {
+	level AggregatedPriceLevel
+	next  []*levelNode
+}
+
+// levelIndex keeps the open price levels of one side of the book sorted best
+// first, which is highest first for bids and lowest first for asks. It is a
+// skiplist, so finding the best level is O(1) and adding or removing a level
+// is O(log n); levels that already exist are found through the map in O(1).
+type levelIndex struct {
+	descending bool
+	head       *levelNode
+	height     int
+	levels     map[int64]*levelNode
+	rand       *rand.Rand
+}
+
+func newLevelIndex(descending bool) *levelIndex {
+	return &levelIndex{
+		descending: descending,
+		head:       &levelNode{next: make([]*levelNode, LEVEL_INDEX_MAX_HEIGHT)},
+		height:     1,
+		levels:     make(map[int64]*levelNode),
+		rand:       rand.New(rand.NewSource(1)),
+	}
+}
+
+// before is whether price a sorts ahead of price b on this side.
+func (idx *levelIndex) before(a, b int64) bool {
+	if idx.descending {
+		return a > b
+	}
+	return a < b
+}
+
+func (idx *levelIndex) randomHeight() int {
+	height := 1
+	for height < LEVEL_INDEX_MAX_HEIGHT && idx.rand.Intn(4) == 0 {
+		height += 1
+	}
+	return height
+}
+
+// add adjusts the level at price by size and orders, creating the level if
+// it doesn't exist and removing it once it has no orders left.
+func (idx *levelIndex) add(price, size, orders int64) {
+	if node, ok := idx.levels[price]; ok {
+		node.level.Size += size
+		node.level.Orders += orders
+
+		if node.level.Orders <= 0 {
+			idx.remove(price)
+		}
+
+		return
+	}
+
+	if orders <= 0 {
+		return
+	}
+
+	var update [LEVEL_INDEX_MAX_HEIGHT]*levelNode
+	x := idx.head
+	for i := idx.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && idx.before(x.next[i].level.Price, price) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	height := idx.randomHeight()
+	if height > idx.height {
+		for i := idx.height; i < height; i++ {
+			update[i] = idx.head
+		}
+		idx.height = height
+	}
+
+	node := &levelNode{
+		level: AggregatedPriceLevel{Price: price, Size: size, Orders: orders},
+		next:  make([]*levelNode, height),
+	}
+
+	for i := 0; i < height; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+
+	idx.levels[price] = node
+}
+
+func (idx *levelIndex) remove(price int64) {
+	node, ok := idx.levels[price]
+	if !ok {
+		return
+	}
+
+	x := idx.head
+	for i := idx.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && idx.before(x.next[i].level.Price, price) {
+			x = x.next[i]
+		}
+		if x.next[i] == node {
+			x.next[i] = node.next[i]
+		}
+	}
+
+	for idx.height > 1 && idx.head.next[idx.height-1] == nil {
+		idx.height -= 1
+	}
+
+	delete(idx.levels, price)
+}
+
+// best returns the first level on this side, if there is one.
+func (idx *levelIndex) best() (AggregatedPriceLevel, bool) {
+	first := idx.head.next[0]
+	if first == nil {
+		return AggregatedPriceLevel{}, false
+	}
+	return first.level, true
+}
+
+// each calls fn with every level best first until fn returns false.
+func (idx *levelIndex) each(fn func(AggregatedPriceLevel) bool) {
+	for x := idx.head.next[0]; x != nil; x = x.next[0] {
+		if !fn(x.level) {
+			return
+		}
+	}
+}
+
+func (idx *levelIndex) len() int {
+	return len(idx.levels)
+}
+
+// levelBook indexes the open orders of both sides of a book.
+type levelBook struct {
+	bids       *levelIndex
+	asks       *levelIndex
+	openOrders int64
+}
+
+func newLevelBook() *levelBook {
+	return &levelBook{bids: newLevelIndex(true), asks: newLevelIndex(false)}
+}
+
+func (l *levelBook) side(side string) *levelIndex {
+	switch side {
+	case SIDE_BUY:
+		return l.bids
+	case SIDE_SELL:
+		return l.asks
+	}
+	return nil
+}
+
+// track moves an order's contribution to the index from its previous version
+// to its new one. Only open orders are counted.
+func (l *levelBook) track(previous, latest *StatefulOrder) {
+	if previous != nil && previous.State == STATE_OPEN {
+		if idx := l.side(previous.Side); idx != nil {
+			idx.add(previous.Price, -previous.Size, -1)
+			l.openOrders -= 1
+		}
+	}
+
+	if latest != nil && latest.State == STATE_OPEN {
+		if idx := l.side(latest.Side); idx != nil {
+			idx.add(latest.Price, latest.Size, 1)
+			l.openOrders += 1
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package book
+
+import "fmt"
+import "math/rand"
+import "sort"
+import "testing"
+import "time"
+
+func TestLevelIndexOrdering(t *testing.T) {
+	bids := newLevelIndex(true)
+	asks := newLevelIndex(false)
+
+	r := rand.New(rand.NewSource(42))
+	prices := make(map[int64]bool)
+
+	for i := 0; i < 1000; i++ {
+		price := r.Int63n(500)
+		if prices[price] {
+			bids.add(price, -1, -1)
+			asks.add(price, -1, -1)
+			delete(prices, price)
+		} else {
+			bids.add(price, 1, 1)
+			asks.add(price, 1, 1)
+			prices[price] = true
+		}
+	}
+
+	expected := make([]int64, 0, len(prices))
+	for price := range prices {
+		expected = append(expected, price)
+	}
+	sort.Sort(int64s(expected))
+
+	if asks.len() != len(expected) || bids.len() != len(expected) {
+		t.Fatalf("Expected %d levels, instead %d bids and %d asks", len(expected), bids.len(), asks.len())
+	}
+
+	i := 0
+	asks.each(func(level AggregatedPriceLevel) bool {
+		if level.Price != expected[i] {
+			t.Fatalf("Expected ask level %d to be at %d, instead %d", i, expected[i], level.Price)
+		}
+		i += 1
+		return true
+	})
+
+	i = len(expected) - 1
+	bids.each(func(level AggregatedPriceLevel) bool {
+		if level.Price != expected[i] {
+			t.Fatalf("Expected bid level to be at %d, instead %d", expected[i], level.Price)
+		}
+		i -= 1
+		return true
+	})
+}
+
+type int64s []int64
+
+func (a int64s) Len() int           { return len(a) }
+func (a int64s) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a int64s) Less(i, j int) bool { return a[i] < a[j] }
+
+func TestPriceLevelIndex(t *testing.T) {
+	book := NewInMemoryOrderBook()
+
+	if _, ok := book.BestBid(); ok {
+		t.Fatal("Expected no best bid in an empty book")
+	}
+
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "b", Price: 101, Side: SIDE_BUY}, 5, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "c", Price: 101, Side: SIDE_BUY}, 7, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "d", Price: 105, Side: SIDE_SELL}, 3, time.Unix(0, 0))
+
+	if _, ok := book.BestBid(); ok {
+		t.Fatal("Expected pending orders not to count towards the best bid")
+	}
+
+	for _, id := range []OrderID{"a", "b", "c", "d"} {
+		book.MutateOrder(id, []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(1, 0)}})
+	}
+
+	if bid, _ := book.BestBid(); bid != 101 {
+		t.Fatalf("Expected best bid to be 101, instead %d", bid)
+	}
+	if ask, _ := book.BestAsk(); ask != 105 {
+		t.Fatalf("Expected best ask to be 105, instead %d", ask)
+	}
+
+	levels := make([]AggregatedPriceLevel, 0)
+	book.EachPriceLevel(SIDE_BUY, func(level AggregatedPriceLevel) bool {
+		levels = append(levels, level)
+		return true
+	})
+
+	if len(levels) != 2 || levels[0] != (AggregatedPriceLevel{101, 12, 2}) || levels[1] != (AggregatedPriceLevel{100, 10, 1}) {
+		t.Fatalf("Expected bid levels 101x12 and 100x10, instead %v", levels)
+	}
+
+	// Partial fill, then a fill of the rest delivered out of order
+	book.MutateOrder("b", []OrderMutation{&OrderMatchMutation{Size: 2, WasMaker: true, Time: time.Unix(2, 0)}})
+	book.MutateOrder("b", []OrderMutation{&OrderStateMutation{State: STATE_FILLED, Time: time.Unix(4, 0)}})
+	book.MutateOrder("b", []OrderMutation{&OrderMatchMutation{Size: 3, WasMaker: true, Time: time.Unix(3, 0)}})
+	book.MutateOrder("c", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(3, 0)}})
+
+	if bid, _ := book.BestBid(); bid != 100 {
+		t.Fatalf("Expected best bid to fall back to 100, instead %d", bid)
+	}
+	if book.NumberOfOpenOrders() != 2 {
+		t.Fatalf("Expected 2 open orders, instead %d", book.NumberOfOpenOrders())
+	}
+}
+
+// randomBook builds a book with n orders around a price of 10000, most of
+// which are open and some of which have been partially filled or cancelled.
+func randomBook(n int) *InMemoryOrderBook {
+	book := NewInMemoryOrderBook()
+	r := rand.New(rand.NewSource(7))
+
+	for i := 0; i < n; i++ {
+		id := OrderID(fmt.Sprintf("order-%d", i))
+		side := SIDE_BUY
+		price := 10000 - r.Int63n(1000) - 1
+		if r.Intn(2) == 0 {
+			side = SIDE_SELL
+			price = 10000 + r.Int63n(1000)
+		}
+
+		placed := time.Unix(int64(i), 0)
+		book.PlaceOrder(Order{ID: id, Price: price, Side: side}, 100, placed)
+		book.MutateOrder(id, []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: placed}})
+
+		switch r.Intn(4) {
+		case 0:
+			book.MutateOrder(id, []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: placed.Add(time.Millisecond)}})
+		case 1:
+			book.MutateOrder(id, []OrderMutation{&OrderMatchMutation{Size: 40, WasMaker: true, Time: placed.Add(time.Millisecond)}})
+		}
+	}
+
+	return book
+}
+
+func TestPriceLevelIndexMatchesScan(t *testing.T) {
+	book := randomBook(2000)
+	now := book.LatestMutationTime
+
+	bid, median, ask, spread := CalculateBidMedianAskSpreadInMemory(book, now)
+	sbid, smedian, sask, sspread := scanBidMedianAskSpread(book, now)
+
+	if bid != sbid || median != smedian || ask != sask || spread != sspread {
+		t.Fatalf("Expected index %d/%d/%d/%d to match scan %d/%d/%d/%d", bid, median, ask, spread, sbid, smedian, sask, sspread)
+	}
+
+	if open := CalculateNumberOfOpenOrdersInMemory(book, now); open != scanNumberOfOpenOrders(book, now) {
+		t.Fatalf("Expected index to count %d open orders, instead %d", scanNumberOfOpenOrders(book, now), open)
+	}
+
+	var notional int64 = 0
+	book.EachPriceLevel(SIDE_BUY, func(level AggregatedPriceLevel) bool {
+		notional += level.Price * level.Size
+		return true
+	})
+	book.EachPriceLevel(SIDE_SELL, func(level AggregatedPriceLevel) bool {
+		notional += level.Price * level.Size
+		return true
+	})
+
+	if total := CalculateTotalCentsInPlayInMemory(book, now); notional != total {
+		t.Fatalf("Expected levels to hold %d cents, instead %d", total, notional)
+	}
+
+	// A later placement forces DepthVersion to replay every order up to now,
+	// which has to agree with the index
+	bids := book.Depth(SIDE_BUY, 0)
+	asks := book.Depth(SIDE_SELL, 0)
+
+	book.PlaceOrder(Order{ID: "later", Price: 1, Side: SIDE_BUY}, 1, now.Add(time.Hour))
+
+	for side, expected := range map[string][]AggregatedPriceLevel{SIDE_BUY: bids, SIDE_SELL: asks} {
+		replayed := book.DepthVersion(side, 0, now)
+		if len(replayed) != len(expected) {
+			t.Fatalf("Expected %d %s levels when replaying, instead %d", len(expected), side, len(replayed))
+		}
+		for i := range expected {
+			if replayed[i] != expected[i] {
+				t.Fatalf("Expected replayed %s level %d to be %s, instead %s", side, i, expected[i], replayed[i])
+			}
+		}
+	}
+}
+
+func BenchmarkBidMedianAskSpreadIndexed(b *testing.B) {
+	book := randomBook(10000)
+	now := book.LatestMutationTime
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		CalculateBidMedianAskSpreadInMemory(book, now)
+	}
+}
+
+func BenchmarkBidMedianAskSpreadScan(b *testing.B) {
+	book := randomBook(10000)
+	now := book.LatestMutationTime
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		scanBidMedianAskSpread(book, now)
+	}
+}
+
+func BenchmarkNumberOfOpenOrdersIndexed(b *testing.B) {
+	book := randomBook(10000)
+	now := book.LatestMutationTime
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		CalculateNumberOfOpenOrdersInMemory(book, now)
+	}
+}
+
+func BenchmarkNumberOfOpenOrdersScan(b *testing.B) {
+	book := randomBook(10000)
+	now := book.LatestMutationTime
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		scanNumberOfOpenOrders(book, now)
+	}
+}
+
+// BenchmarkPlacingAndCancelling measures the cost of keeping the index up to
+// date, which every order pays.
+func BenchmarkPlacingAndCancelling(b *testing.B) {
+	book := NewInMemoryOrderBook()
+
+	for i := 0; i < b.N; i++ {
+		id := OrderID(fmt.Sprintf("order-%d", i))
+		placed := time.Unix(int64(i), 0)
+		book.PlaceOrder(Order{ID: id, Price: int64(10000 + i%1000), Side: SIDE_SELL}, 100, placed)
+		book.MutateOrder(id, []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: placed}})
+		book.MutateOrder(id, []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: placed}})
+	}
+}
@@ -14,11 +14,16 @@ var (
 type OrderBook interface {
 	PlaceOrder(o Order, size int64, t time.Time) error
 	MutateOrder(OrderID, []OrderMutation) error
-	Vacuum()
+	Vacuum() VacuumReport
 
 	GetOrder(OrderID) (*StatefulOrder, error)
 	GetOrderVersion(OrderID, time.Time) (*StatefulOrder, error)
 	GetPriceLevel(int64) []*StatefulOrder
+
+	// Depth returns up to n of the best open price levels on one side, or all
+	// of them if n isn't positive. DepthVersion does the same as of time t.
+	Depth(side string, n int) []AggregatedPriceLevel
+	DepthVersion(side string, n int, t time.Time) []AggregatedPriceLevel
 }
 
 type OrderMutation interface {
@@ -70,16 +75,21 @@ func (m *OrderSizeMutation) GetTime() time.Time {
 	return m.Time
 }
 
+// OrderMatchMutation is one side of a trade. Price is the price the trade
+// happened at, which for the taker isn't necessarily the price of its order.
+// MakerID is what a taker records in Makers; TakerID is informational.
 type OrderMatchMutation struct {
 	TradeID  int64
+	Price    int64
 	Size     int64
 	WasMaker bool
 	MakerID  OrderID
+	TakerID  OrderID
 	Time     time.Time
 }
 
 func (m *OrderMatchMutation) String() string {
-	return fmt.Sprintf("<OrderMatchMutation of %d units at %s; trade id=%s>", m.Size, m.Time.String(), m.TradeID)
+	return fmt.Sprintf("<OrderMatchMutation of %d units at %s; trade id=%d>", m.Size, m.Time.String(), m.TradeID)
 }
 
 func (m *OrderMatchMutation) Apply(s *StatefulOrder) (*StatefulOrder, error) {
@@ -129,10 +139,14 @@ func (p *OrderBookPlacementCommand) Apply(book OrderBook) error {
 	return book.PlaceOrder(p.Order, p.Size, p.Time)
 }
 
+// OrderHistory keeps Mutations sorted by time. Checkpoints caches versions of
+// the order part way through them so that finding a past version doesn't have
+// to replay from FirstVersion.
 type OrderHistory struct {
 	Mutations     []OrderMutation
 	FirstVersion  *StatefulOrder
 	LatestVersion *StatefulOrder
+	Checkpoints   []*OrderCheckpoint
 }
 
 func (h *OrderHistory) String() string {
@@ -0,0 +1,221 @@
+package book
+
+import "fmt"
+import "sync"
+import "sync/atomic"
+import "time"
+
+// ConcurrentOrderBook makes an InMemoryOrderBook safe to share between the
+// goroutine feeding it and any number of readers.
+//
+// Writes take an exclusive lock and reads a shared one. Snapshot is cheaper
+// still: the latest snapshot is built once after a change and then handed out
+// without taking any lock until the book changes again.
+//
+// The book can also be marked stale while whoever feeds it knows it is
+// missing updates, for example while resynchronizing with an exchange.
+// Readers aren't blocked by it; they can check Stale or wait on Fresh.
+type ConcurrentOrderBook struct {
+	lock  sync.RWMutex
+	inner *InMemoryOrderBook
+
+	// generation is bumped on every write; snapshot holds a *generationSnapshot
+	generation int64
+	snapshot   atomic.Value
+
+	staleLock sync.Mutex
+	stale     bool
+	fresh     chan struct{}
+}
+
+type generationSnapshot struct {
+	generation int64
+	snapshot   *BookSnapshot
+}
+
+func NewConcurrentOrderBook(inner *InMemoryOrderBook) *ConcurrentOrderBook {
+	fresh := make(chan struct{})
+	close(fresh)
+
+	b := &ConcurrentOrderBook{inner: inner, fresh: fresh, generation: 1}
+	b.snapshot.Store(&generationSnapshot{})
+
+	return b
+}
+
+func (b *ConcurrentOrderBook) String() string {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return fmt.Sprintf("<ConcurrentOrderBook of %s; stale=%t>", b.inner.String(), b.Stale())
+}
+
+// write runs fn with the exclusive lock and invalidates the cached snapshot.
+func (b *ConcurrentOrderBook) write(fn func()) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	fn()
+	atomic.AddInt64(&b.generation, 1)
+}
+
+func (b *ConcurrentOrderBook) PlaceOrder(o Order, size int64, t time.Time) (err error) {
+	b.write(func() { err = b.inner.PlaceOrder(o, size, t) })
+	return err
+}
+
+func (b *ConcurrentOrderBook) MutateOrder(id OrderID, muts []OrderMutation) (err error) {
+	b.write(func() { err = b.inner.MutateOrder(id, muts) })
+	return err
+}
+
+func (b *ConcurrentOrderBook) Vacuum() (report VacuumReport) {
+	b.write(func() { report = b.inner.Vacuum() })
+	return report
+}
+
+// Apply runs a command, like a batch from an exchange feed, as a single write
+// so that readers never see it half applied.
+func (b *ConcurrentOrderBook) Apply(cmd OrderBookCommand) (err error) {
+	b.write(func() { err = cmd.Apply(b.inner) })
+	return err
+}
+
+// Reset replaces the book wholesale, for when it has to be rebuilt from
+// scratch. Subscriptions carry over to the new book and are sent a
+// BookResetEvent, since the changes between the two books aren't.
+func (b *ConcurrentOrderBook) Reset(inner *InMemoryOrderBook) {
+	b.write(func() {
+		inner.subscribers = append(inner.subscribers, b.inner.subscribers...)
+		b.inner.subscribers = nil
+		b.inner = inner
+		inner.publish([]BookEvent{&BookResetEvent{Time: inner.LatestMutationTime}})
+	})
+}
+
+// Subscribe works like InMemoryOrderBook.Subscribe. Events are sent while
+// the book is locked, so SLOW_CONSUMER_BLOCK holds up readers as well until
+// the subscriber makes room or unsubscribes.
+func (b *ConcurrentOrderBook) Subscribe(filter SubscriptionFilter, bufLen int, policy string) (sub *Subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.inner.Subscribe(filter, bufLen, policy)
+}
+
+// Unsubscribe stops sending events to sub and closes its channel. It gives
+// up on any event blocked on sub first, since that holds the lock.
+func (b *ConcurrentOrderBook) Unsubscribe(sub *Subscription) {
+	sub.cancel()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.inner.Unsubscribe(sub)
+}
+
+// Read runs fn with the shared lock held, for anything that needs a
+// consistent view of several parts of the book at once. fn mustn't change the
+// book or keep it around after returning.
+func (b *ConcurrentOrderBook) Read(fn func(book *InMemoryOrderBook)) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	fn(b.inner)
+}
+
+// ReadExclusive runs fn with the exclusive lock held, for reads like
+// SnapshotAt that cache their work in the book. Like Read's, fn mustn't
+// change the book's orders.
+func (b *ConcurrentOrderBook) ReadExclusive(fn func(book *InMemoryOrderBook)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	fn(b.inner)
+}
+
+func (b *ConcurrentOrderBook) GetOrder(id OrderID) (order *StatefulOrder, err error) {
+	b.Read(func(book *InMemoryOrderBook) { order, err = book.GetOrder(id) })
+	return order, err
+}
+
+func (b *ConcurrentOrderBook) GetOrderVersion(id OrderID, t time.Time) (order *StatefulOrder, err error) {
+	b.Read(func(book *InMemoryOrderBook) { order, err = book.GetOrderVersion(id, t) })
+	return order, err
+}
+
+func (b *ConcurrentOrderBook) GetPriceLevel(level int64) (orders []*StatefulOrder) {
+	b.Read(func(book *InMemoryOrderBook) { orders = book.GetPriceLevel(level) })
+	return orders
+}
+
+func (b *ConcurrentOrderBook) Depth(side string, n int) (levels []AggregatedPriceLevel) {
+	b.Read(func(book *InMemoryOrderBook) { levels = book.Depth(side, n) })
+	return levels
+}
+
+func (b *ConcurrentOrderBook) DepthVersion(side string, n int, t time.Time) (levels []AggregatedPriceLevel) {
+	b.Read(func(book *InMemoryOrderBook) { levels = book.DepthVersion(side, n, t) })
+	return levels
+}
+
+// SnapshotAt takes the exclusive lock, since it caches its work in the book.
+func (b *ConcurrentOrderBook) SnapshotAt(t time.Time) (s *BookSnapshot) {
+	b.ReadExclusive(func(book *InMemoryOrderBook) { s = book.SnapshotAt(t) })
+	return s
+}
+
+// Copy copies the book under the shared lock, for reads like SnapshotAt that
+// would otherwise hold the exclusive one for as long as they take.
+func (b *ConcurrentOrderBook) Copy() (c *InMemoryOrderBook) {
+	b.Read(func(book *InMemoryOrderBook) { c = book.Copy() })
+	return c
+}
+
+// Snapshot returns the latest state of the book. It doesn't lock unless the
+// book has changed since the last call.
+func (b *ConcurrentOrderBook) Snapshot() *BookSnapshot {
+	cached := b.snapshot.Load().(*generationSnapshot)
+
+	if cached.generation == atomic.LoadInt64(&b.generation) {
+		return cached.snapshot
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	// Writers can't change the generation while we hold the lock
+	fresh := &generationSnapshot{
+		generation: atomic.LoadInt64(&b.generation),
+		snapshot:   b.inner.Snapshot(),
+	}
+	b.snapshot.Store(fresh)
+
+	return fresh.snapshot
+}
+
+// SetStale marks the book as missing updates, or not.
+func (b *ConcurrentOrderBook) SetStale(stale bool) {
+	b.staleLock.Lock()
+	defer b.staleLock.Unlock()
+
+	if stale == b.stale {
+		return
+	}
+
+	b.stale = stale
+
+	if stale {
+		b.fresh = make(chan struct{})
+	} else {
+		close(b.fresh)
+	}
+}
+
+func (b *ConcurrentOrderBook) Stale() bool {
+	b.staleLock.Lock()
+	defer b.staleLock.Unlock()
+	return b.stale
+}
+
+// Fresh returns a channel that is closed once the book isn't stale, which
+// is straight away if it isn't stale now.
+func (b *ConcurrentOrderBook) Fresh() <-chan struct{} {
+	b.staleLock.Lock()
+	defer b.staleLock.Unlock()
+	return b.fresh
+}
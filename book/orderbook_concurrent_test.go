@@ -0,0 +1,202 @@
+package book
+
+import "fmt"
+import "sync"
+import "testing"
+import "time"
+
+// pairCommand places and opens a bid and an ask of the same size, so a
+// consistent view of the book always has as much bid as ask.
+type pairCommand struct {
+	n    int
+	size int64
+}
+
+func (c *pairCommand) Apply(book OrderBook) error {
+	t := time.Unix(int64(c.n), 0)
+
+	for _, side := range []string{SIDE_BUY, SIDE_SELL} {
+		id := OrderID(fmt.Sprintf("%s-%d", side, c.n))
+		price := int64(1000 + c.n%50)
+		if side == SIDE_BUY {
+			price = int64(900 - c.n%50)
+		}
+
+		if err := book.PlaceOrder(Order{ID: id, Price: price, Side: side}, c.size, t); err != nil {
+			return err
+		}
+		if err := book.MutateOrder(id, []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: t}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func totalSize(levels []AggregatedPriceLevel) (size int64) {
+	for _, level := range levels {
+		size += level.Size
+	}
+	return size
+}
+
+func TestConcurrentOrderBook(t *testing.T) {
+	book := NewConcurrentOrderBook(NewInMemoryOrderBook())
+
+	var _ OrderBook = book
+
+	const writers = 4
+	const perWriter = 200
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	errs := make(chan error, 100)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				n := w*perWriter + i
+				if err := book.Apply(&pairCommand{n: n, size: int64(n%7 + 1)}); err != nil {
+					errs <- err
+					return
+				}
+				if i%50 == 0 {
+					book.Vacuum()
+				}
+			}
+		}(w)
+	}
+
+	var readers sync.WaitGroup
+
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				snapshot := book.Snapshot()
+				if bids, asks := totalSize(snapshot.Depth(SIDE_BUY, 0)), totalSize(snapshot.Depth(SIDE_SELL, 0)); bids != asks {
+					errs <- fmt.Errorf("Expected snapshot to have as much bid as ask, instead %d and %d", bids, asks)
+					return
+				}
+
+				book.Read(func(b *InMemoryOrderBook) {
+					if b.NumberOfOpenOrders()%2 != 0 {
+						errs <- fmt.Errorf("Expected an even number of open orders, instead %d", b.NumberOfOpenOrders())
+					}
+				})
+
+				book.Depth(SIDE_BUY, 5)
+				book.GetOrder("buy-1")
+				book.GetOrderVersion("sell-1", time.Unix(0, 0))
+				book.GetPriceLevel(900)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err.Error())
+	}
+
+	snapshot := book.Snapshot()
+	if snapshot.NumberOfOpenOrders() != 2*writers*perWriter {
+		t.Fatalf("Expected %d open orders, instead %d", 2*writers*perWriter, snapshot.NumberOfOpenOrders())
+	}
+	if book.Snapshot() != snapshot {
+		t.Fatal("Expected an unchanged book to hand out the same snapshot")
+	}
+
+	book.MutateOrder("buy-0", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(5000, 0)}})
+
+	if book.Snapshot().NumberOfOpenOrders() != 2*writers*perWriter-1 {
+		t.Fatal("Expected a new snapshot after the book changed")
+	}
+}
+
+func TestWaitingForStaleBook(t *testing.T) {
+	book := NewConcurrentOrderBook(NewInMemoryOrderBook())
+
+	select {
+	case <-book.Fresh():
+	default:
+		t.Fatal("Expected a new book to be fresh")
+	}
+
+	book.SetStale(true)
+
+	if !book.Stale() {
+		t.Fatal("Expected book to be stale")
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		<-book.Fresh()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("Expected readers to wait while the book is stale")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	book.Reset(NewInMemoryOrderBook())
+	book.SetStale(false)
+
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("Expected readers to be woken up once the book is fresh")
+	}
+}
+
+func TestUnsubscribingABlockedSubscriber(t *testing.T) {
+	book := NewConcurrentOrderBook(NewInMemoryOrderBook())
+	sub := book.Subscribe(SubscriptionFilter{}, 1, SLOW_CONSUMER_BLOCK)
+
+	// The second order blocks the writer with the lock held until we give up
+	placed := make(chan error, 1)
+	go func() {
+		book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+		placed <- book.PlaceOrder(Order{ID: "b", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	}()
+
+	for len(sub.C) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	unsubscribed := make(chan struct{})
+	go func() {
+		book.Unsubscribe(sub)
+		close(unsubscribed)
+	}()
+
+	select {
+	case <-unsubscribed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Unsubscribe not to wait on its own blocked subscription")
+	}
+
+	if err := <-placed; err != nil {
+		t.Fatalf("Unexpected error placing order: %s", err.Error())
+	}
+	if events := drain(sub); len(events) != 1 {
+		t.Fatalf("Expected the one buffered event before the channel closed, instead %d", len(events))
+	}
+	if _, err := book.GetOrder("b"); err != nil {
+		t.Fatalf("Expected b to have been placed, instead %s", err.Error())
+	}
+}
@@ -9,6 +9,23 @@ type InMemoryOrderBook struct {
 	PriceLevels        map[int64][]*OrderHistory
 	History            []*OrderHistory
 	LatestMutationTime time.Time
+	Scale              Scale
+
+	// Retention decides which finished orders Vacuum keeps around
+	Retention RetentionPolicy
+
+	// Tape, if set, records the trades behind every match mutation. It can
+	// be shared between books that follow the same product one after another.
+	Tape *TradeTape
+
+	// The open orders as of LatestMutationTime, aggregated by price level and
+	// kept sorted per side as orders are placed and mutated
+	levels *levelBook
+
+	// The cursor behind SnapshotAt, kept in step with the book as it changes
+	cursor *snapshotCursor
+
+	subscribers []*Subscription
 }
 
 func (m *InMemoryOrderBook) String() string {
@@ -16,32 +33,58 @@ func (m *InMemoryOrderBook) String() string {
 }
 
 func NewInMemoryOrderBook() (b *InMemoryOrderBook) {
-	bk := make(map[OrderID]*OrderHistory)
-	prices := make(map[int64][]*OrderHistory)
-	history := make([]*OrderHistory, 0)
-	return &InMemoryOrderBook{bk, prices, history, *new(time.Time)}
+	return NewScaledInMemoryOrderBook(DEFAULT_SCALE)
 }
 
-func (book *InMemoryOrderBook) applyMutations(order StatefulOrder, muts []OrderMutation) *StatefulOrder {
-	latest_time := muts[0].GetTime()
-	// Re-apply all the mutations
-	for _, mutation := range muts {
-		porder, err := mutation.Apply(&order)
+// NewScaledInMemoryOrderBook makes an empty book for a product whose prices
+// and sizes are in the minor units described by scale.
+func NewScaledInMemoryOrderBook(scale Scale) (b *InMemoryOrderBook) {
+	return &InMemoryOrderBook{
+		Book:        make(map[OrderID]*OrderHistory),
+		PriceLevels: make(map[int64][]*OrderHistory),
+		History:     make([]*OrderHistory, 0),
+		Scale:       scale,
+		levels:      newLevelBook(),
+	}
+}
 
-		if err != nil {
-			continue
+// Copy makes a book with the same orders and history that shares nothing the
+// two of them change, so the copy can be walked back in time with SnapshotAt
+// without holding whatever guards the original. It has no Tape or subscribers.
+func (book *InMemoryOrderBook) Copy() *InMemoryOrderBook {
+	c := NewScaledInMemoryOrderBook(book.Scale)
+	c.LatestMutationTime = book.LatestMutationTime
+	c.Retention = book.Retention
+
+	copies := make(map[*OrderHistory]*OrderHistory, len(book.History))
+	copyOf := func(history *OrderHistory) *OrderHistory {
+		h, ok := copies[history]
+		if !ok {
+			h = history.copy()
+			copies[history] = h
 		}
+		return h
+	}
 
-		order = *porder
+	for _, history := range book.History {
+		h := copyOf(history)
+		c.History = append(c.History, h)
+		c.levels.track(nil, h.LatestVersion)
+	}
 
-		if latest_time.Before(mutation.GetTime()) {
-			latest_time = mutation.GetTime()
-		}
+	for id, history := range book.Book {
+		c.Book[id] = copyOf(history)
 	}
 
-	order.LatestMutationTime = latest_time
+	for price, histories := range book.PriceLevels {
+		level := make([]*OrderHistory, 0, len(histories))
+		for _, history := range histories {
+			level = append(level, copyOf(history))
+		}
+		c.PriceLevels[price] = level
+	}
 
-	return &order
+	return c
 }
 
 func (book *InMemoryOrderBook) GetOrder(id OrderID) (*StatefulOrder, error) {
@@ -61,26 +104,7 @@ func (book *InMemoryOrderBook) GetOrderVersion(id OrderID, t time.Time) (*Statef
 		return nil, errOrderDoesNotExist
 	}
 
-	// Short circuit to avoid expensively (?) replaying mutations
-	if t.After(history.LatestVersion.LatestMutationTime) {
-		return history.LatestVersion, nil
-	}
-
-	// Filter the mutations to only the ones before or at t
-	order := *history.FirstVersion
-	muts := make([]OrderMutation, 0)
-	for _, mut := range history.Mutations {
-		if mut.GetTime().Before(t) || mut.GetTime().Equal(t) {
-			muts = append(muts, mut)
-		}
-	}
-	sort.Sort(OrderMutationByTime(muts))
-
-	if len(muts) > 0 {
-		order = *book.applyMutations(order, muts)
-	}
-
-	return &order, nil
+	return history.VersionAt(t), nil
 }
 
 func (book *InMemoryOrderBook) PlaceOrder(order Order, size int64, t time.Time) (err error) {
@@ -113,11 +137,16 @@ func (book *InMemoryOrderBook) PlaceOrder(order Order, size int64, t time.Time)
 
 	book.PriceLevels[order.Price] = append(book.PriceLevels[order.Price], history)
 	book.History = append(book.History, history)
+	change := book.beginChange(nil, sorder)
+	book.levels.track(nil, sorder)
+	book.cursorMutated(history, t)
 
 	if book.LatestMutationTime.Before(t) {
 		book.LatestMutationTime = t
 	}
 
+	change.finish(nil, sorder, nil)
+
 	return nil
 }
 
@@ -132,22 +161,31 @@ func (book *InMemoryOrderBook) MutateOrder(id OrderID, muts []OrderMutation) err
 		return nil
 	}
 
-	// Copy the first version of the order
-	// To be precise, we might want to copy the Makers which is a []OrderID
-	// but it probably doesn't matter much
-	order := *history.FirstVersion
+	previous := history.LatestVersion
+	order := history.mutate(muts)
 
-	history.Mutations = append(history.Mutations, muts...)
-	sort.Sort(OrderMutationByTime(history.Mutations))
+	if book.Tape != nil {
+		for _, mut := range muts {
+			if match, ok := mut.(*OrderMatchMutation); ok {
+				book.Tape.RecordMatch(previous, match)
+			}
+		}
+	}
 
-	order = *book.applyMutations(order, history.Mutations)
-	history.LatestVersion = &order
+	change := book.beginChange(previous, order)
+	book.levels.track(previous, order)
+	for _, mut := range muts {
+		book.cursorMutated(history, mut.GetTime())
+	}
+	history.LatestVersion = order
 	book.Book[id] = history
 
 	if book.LatestMutationTime.Before(order.LatestMutationTime) {
 		book.LatestMutationTime = order.LatestMutationTime
 	}
 
+	change.finish(previous, order, muts)
+
 	return nil
 }
 
@@ -173,7 +211,7 @@ func (book *InMemoryOrderBook) GetPriceLevelVersion(level int64, t time.Time) []
 		// replaying the mutations if all the updates happened before t (latest)
 		if history.LatestVersion.LatestMutationTime.After(t) {
 			// Drats, we have to apply only the mutations that occurred before or at t
-			order, _ = book.GetOrderVersion(history.LatestVersion.ID, t)
+			order = history.VersionAt(t)
 		} else {
 			order = history.LatestVersion
 		}
@@ -186,6 +224,106 @@ func (book *InMemoryOrderBook) GetPriceLevelVersion(level int64, t time.Time) []
 	return prices
 }
 
+// BestBid returns the highest price with an open buy order as of LatestMutationTime.
+func (book *InMemoryOrderBook) BestBid() (int64, bool) {
+	level, ok := book.levels.bids.best()
+	return level.Price, ok
+}
+
+// BestAsk returns the lowest price with an open sell order as of LatestMutationTime.
+func (book *InMemoryOrderBook) BestAsk() (int64, bool) {
+	level, ok := book.levels.asks.best()
+	return level.Price, ok
+}
+
+// EachPriceLevel calls fn with the open price levels on one side as of
+// LatestMutationTime, best price first, until fn returns false.
+func (book *InMemoryOrderBook) EachPriceLevel(side string, fn func(AggregatedPriceLevel) bool) {
+	if idx := book.levels.side(side); idx != nil {
+		idx.each(fn)
+	}
+}
+
+func (book *InMemoryOrderBook) Depth(side string, n int) []AggregatedPriceLevel {
+	levels := make([]AggregatedPriceLevel, 0)
+
+	book.EachPriceLevel(side, func(level AggregatedPriceLevel) bool {
+		levels = append(levels, level)
+		return n <= 0 || len(levels) < n
+	})
+
+	return levels
+}
+
+// DepthVersion uses the price level index when t is at or after the latest
+// mutation, and otherwise replays every order in the book to time t.
+func (book *InMemoryOrderBook) DepthVersion(side string, n int, t time.Time) []AggregatedPriceLevel {
+	if !t.Before(book.LatestMutationTime) {
+		return book.Depth(side, n)
+	}
+
+	idx := book.levels.side(side)
+	if idx == nil {
+		return []AggregatedPriceLevel{}
+	}
+
+	aggregated := make(map[int64]*AggregatedPriceLevel)
+
+	for price, histories := range book.PriceLevels {
+		for _, history := range histories {
+			if history.FirstVersion.Side != side {
+				continue
+			}
+
+			order := history.VersionAt(t)
+			if order.State != STATE_OPEN {
+				continue
+			}
+
+			level, ok := aggregated[price]
+			if !ok {
+				level = &AggregatedPriceLevel{Price: price}
+				aggregated[price] = level
+			}
+
+			level.Size += order.Size
+			level.Orders += 1
+		}
+	}
+
+	levels := make([]AggregatedPriceLevel, 0, len(aggregated))
+	for _, level := range aggregated {
+		levels = append(levels, *level)
+	}
+
+	sort.Sort(levelsBestFirst{levels, idx.descending})
+
+	if n > 0 && len(levels) > n {
+		levels = levels[:n]
+	}
+
+	return levels
+}
+
+type levelsBestFirst struct {
+	levels     []AggregatedPriceLevel
+	descending bool
+}
+
+func (a levelsBestFirst) Len() int      { return len(a.levels) }
+func (a levelsBestFirst) Swap(i, j int) { a.levels[i], a.levels[j] = a.levels[j], a.levels[i] }
+func (a levelsBestFirst) Less(i, j int) bool {
+	if a.descending {
+		return a.levels[i].Price > a.levels[j].Price
+	}
+	return a.levels[i].Price < a.levels[j].Price
+}
+
+// NumberOfOpenOrders counts the open orders as of LatestMutationTime.
+func (book *InMemoryOrderBook) NumberOfOpenOrders() int64 {
+	return book.levels.openOrders
+}
+
 func (book *InMemoryOrderBook) GetPriceLevels() []int64 {
 	keys := make([]int64, 0, len(book.PriceLevels))
 
@@ -196,11 +334,54 @@ func (book *InMemoryOrderBook) GetPriceLevels() []int64 {
 	return keys
 }
 
-// Vacuuming removes all of the voided or filled orders
-func (book *InMemoryOrderBook) Vacuum() {
-	for orderId, history := range book.Book {
-		if history.LatestVersion.State == STATE_FILLED || history.LatestVersion.State == STATE_VOID {
-			delete(book.Book, orderId)
+// Vacuuming removes the voided or filled orders that the retention policy
+// doesn't keep from Book, PriceLevels and History.
+func (book *InMemoryOrderBook) Vacuum() VacuumReport {
+	report := VacuumReport{Orders: []OrderID{}, PriceLevels: []int64{}}
+	expired := book.Retention.expired(book)
+
+	if len(expired) == 0 {
+		return report
+	}
+
+	for history := range expired {
+		delete(book.Book, history.FirstVersion.ID)
+		report.Orders = append(report.Orders, history.FirstVersion.ID)
+
+		if report.Horizon.Before(history.LatestVersion.LatestMutationTime) {
+			report.Horizon = history.LatestVersion.LatestMutationTime
 		}
 	}
+
+	for price, histories := range book.PriceLevels {
+		kept := histories[:0]
+		for _, history := range histories {
+			if !expired[history] {
+				kept = append(kept, history)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(book.PriceLevels, price)
+			report.PriceLevels = append(report.PriceLevels, price)
+		} else {
+			book.PriceLevels[price] = kept
+		}
+	}
+
+	kept := book.History[:0]
+	for _, history := range book.History {
+		if !expired[history] {
+			kept = append(kept, history)
+		}
+	}
+	// Don't hold on to what was removed through the end of the array
+	for i := len(kept); i < len(book.History); i++ {
+		book.History[i] = nil
+	}
+	book.History = kept
+
+	book.cursorVacuumed(expired, report.Horizon)
+
+	return report
 }
@@ -19,7 +19,7 @@ func TestPlacingOrders(t *testing.T) {
 		t.Fatalf("Expected getting an order after placing it to return an order, instead got %s", err.Error())
 	}
 	if sorder.Order != order {
-		t.Fatalf("Expected placed order %s to equal retrieved order %s", sorder.Order, order)
+		t.Fatalf("Expected placed order %v to equal retrieved order %v", sorder.Order, order)
 	}
 	if sorder.State != STATE_PENDING {
 		t.Fatalf("Expected just placed order %s to have pending state", sorder)
@@ -274,7 +274,7 @@ func TestOrderVersions(t *testing.T) {
 		t.Fatalf("Failed to get order at time minus one, error: %s", err.Error())
 	}
 	if sorderAtMinusOne.Size != 10 {
-		t.Fatalf("Expected size at time minus one to be 10, instead %d", sorderAtMinusOne)
+		t.Fatalf("Expected size at time minus one to be 10, instead %d", sorderAtMinusOne.Size)
 	}
 }
 
@@ -328,6 +328,39 @@ func TestVacuuming(t *testing.T) {
 	}
 }
 
+func TestCopying(t *testing.T) {
+	book := NewInMemoryOrderBook()
+
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "b", Price: 105, Side: SIDE_SELL}, 3, time.Unix(0, 0))
+	book.MutateOrder("a", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(1, 0)}})
+	book.MutateOrder("b", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(1, 0)}})
+
+	c := book.Copy()
+
+	// Neither a late nor a new mutation of the original reaches the copy
+	book.MutateOrder("a", []OrderMutation{&OrderMatchMutation{Size: 4, WasMaker: true, Time: time.Unix(0, 500)}})
+	book.MutateOrder("b", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(2, 0)}})
+	book.Vacuum()
+
+	if c.NumberOfOpenOrders() != 2 || len(c.History) != 2 || len(c.PriceLevels[105]) != 1 {
+		t.Fatalf("Expected the copy to keep both open orders, instead %d open in %s", c.NumberOfOpenOrders(), c)
+	}
+	if bid, _ := c.BestBid(); bid != 100 {
+		t.Fatalf("Expected the copy to have a best bid of 100, instead %d", bid)
+	}
+	if order, _ := c.GetOrderVersion("a", time.Unix(1, 0)); order.Size != 10 {
+		t.Fatalf("Expected the copy of a to have 10 units at t=1, instead %d", order.Size)
+	}
+	if depth := c.SnapshotAt(time.Unix(1, 0)).Depth(SIDE_SELL, 0); len(depth) != 1 || depth[0].Size != 3 {
+		t.Fatalf("Expected 3 units asked in the copy at t=1, instead %v", depth)
+	}
+
+	if order, _ := book.GetOrder("a"); order.Size != 6 {
+		t.Fatalf("Expected the original to keep its late fill, instead %d units", order.Size)
+	}
+}
+
 func TestLatestMutationTime(t *testing.T) {
 	book := NewInMemoryOrderBook()
 
@@ -418,3 +451,123 @@ func TestOrderBookCommands(t *testing.T) {
 		t.Fatalf("Mutation command failed to change state. Expected %s to be %s", order.State, STATE_OPEN)
 	}
 }
+
+func TestDepth(t *testing.T) {
+	book := NewInMemoryOrderBook()
+
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "b", Price: 99, Side: SIDE_BUY}, 5, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "c", Price: 99, Side: SIDE_BUY}, 7, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "d", Price: 98, Side: SIDE_BUY}, 1, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "e", Price: 101, Side: SIDE_SELL}, 3, time.Unix(0, 0))
+
+	for _, id := range []OrderID{"a", "b", "c", "d", "e"} {
+		book.MutateOrder(id, []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(1, 0)}})
+	}
+
+	book.MutateOrder("a", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(2, 0)}})
+	book.MutateOrder("c", []OrderMutation{&OrderMatchMutation{Size: 2, WasMaker: true, Time: time.Unix(2, 0)}})
+
+	depth := book.Depth(SIDE_BUY, 2)
+	expected := []AggregatedPriceLevel{{99, 10, 2}, {98, 1, 1}}
+	if len(depth) != len(expected) || depth[0] != expected[0] || depth[1] != expected[1] {
+		t.Fatalf("Expected latest bid depth %v, instead %v", expected, depth)
+	}
+
+	if depth := book.Depth(SIDE_SELL, 0); len(depth) != 1 || depth[0] != (AggregatedPriceLevel{101, 3, 1}) {
+		t.Fatalf("Expected all ask levels to be 101x3, instead %v", depth)
+	}
+
+	depth = book.DepthVersion(SIDE_BUY, 2, time.Unix(1, 0))
+	expected = []AggregatedPriceLevel{{100, 10, 1}, {99, 12, 2}}
+	if len(depth) != len(expected) || depth[0] != expected[0] || depth[1] != expected[1] {
+		t.Fatalf("Expected bid depth at t=1 %v, instead %v", expected, depth)
+	}
+
+	if depth := book.DepthVersion(SIDE_BUY, 0, time.Unix(0, 0)); len(depth) != 0 {
+		t.Fatalf("Expected no open levels at t=0, instead %v", depth)
+	}
+
+	// Vacuumed orders are still part of the past while they're retained
+	book.Retention = RetentionPolicy{KeepFor: time.Minute}
+	book.MutateOrder("d", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(3, 0)}})
+	book.Vacuum()
+
+	depth = book.DepthVersion(SIDE_BUY, 0, time.Unix(2, 0))
+	expected = []AggregatedPriceLevel{{99, 10, 2}, {98, 1, 1}}
+	if len(depth) != len(expected) || depth[0] != expected[0] || depth[1] != expected[1] {
+		t.Fatalf("Expected bid depth at t=2 after vacuuming %v, instead %v", expected, depth)
+	}
+}
+
+func TestVacuumingWithRetention(t *testing.T) {
+	book := NewInMemoryOrderBook()
+	book.Retention = RetentionPolicy{KeepFor: 10 * time.Second}
+
+	book.PlaceOrder(Order{ID: "old", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "recent", Price: 101, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "open", Price: 101, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+
+	book.MutateOrder("old", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(1, 0)}})
+	book.MutateOrder("old", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(5, 0)}})
+	book.MutateOrder("recent", []OrderMutation{&OrderMatchMutation{Size: 10, WasMaker: true, Time: time.Unix(15, 0)}})
+	book.MutateOrder("open", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(20, 0)}})
+
+	report := book.Vacuum()
+
+	if len(report.Orders) != 1 || report.Orders[0] != "old" {
+		t.Fatalf("Expected only the order that finished more than 10s ago to be removed, instead %v", report.Orders)
+	}
+	if len(report.PriceLevels) != 1 || report.PriceLevels[0] != 100 {
+		t.Fatalf("Expected price level 100 to be removed, instead %v", report.PriceLevels)
+	}
+	if !report.Horizon.Equal(time.Unix(5, 0)) {
+		t.Fatalf("Expected horizon to be t=5, instead %s", report.Horizon)
+	}
+	if len(book.History) != 2 || len(book.PriceLevels) != 1 || len(book.Book) != 2 {
+		t.Fatalf("Expected 2 orders left everywhere, instead %d in history, %d levels and %d in the book", len(book.History), len(book.PriceLevels), len(book.Book))
+	}
+
+	// Still correct anywhere after the horizon
+	order, err := book.GetOrderVersion("recent", time.Unix(10, 0))
+	if err != nil || order.State != STATE_PENDING || order.Size != 10 {
+		t.Fatalf("Expected recent order to be pending with 10 units at t=10, instead %s", order)
+	}
+	if depth := book.DepthVersion(SIDE_BUY, 0, time.Unix(6, 0)); len(depth) != 0 {
+		t.Fatalf("Expected no open levels at t=6, instead %v", depth)
+	}
+
+	book.Retention = RetentionPolicy{}
+	report = book.Vacuum()
+
+	if len(report.Orders) != 1 || report.Orders[0] != "recent" || len(report.PriceLevels) != 0 {
+		t.Fatalf("Expected keeping nothing to remove the recent order but not its level, instead %s", report)
+	}
+}
+
+func TestVacuumingKeepingLast(t *testing.T) {
+	book := NewInMemoryOrderBook()
+	book.Retention = RetentionPolicy{KeepLast: 2}
+
+	for i := 0; i < 5; i++ {
+		id := OrderID(string(rune('a' + i)))
+		book.PlaceOrder(Order{ID: id, Price: 100, Side: SIDE_SELL}, 10, time.Unix(0, 0))
+		book.MutateOrder(id, []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(int64(10-i), 0)}})
+	}
+
+	report := book.Vacuum()
+
+	if len(report.Orders) != 3 || !report.Horizon.Equal(time.Unix(8, 0)) {
+		t.Fatalf("Expected 3 orders finished by t=8 to be removed, instead %s", report)
+	}
+	if _, err := book.GetOrder("a"); err != nil {
+		t.Fatal("Expected the most recently finished order to be kept")
+	}
+	if _, err := book.GetOrder("c"); err == nil {
+		t.Fatal("Expected the third most recently finished order to be removed")
+	}
+
+	if report := book.Vacuum(); len(report.Orders) != 0 {
+		t.Fatalf("Expected nothing more to vacuum, instead %s", report)
+	}
+}
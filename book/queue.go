@@ -0,0 +1,125 @@
+package book
+
+import "errors"
+import "fmt"
+import "sort"
+import "time"
+
+var (
+	errOrderNotResting = errors.New("Order is not resting on the book.")
+)
+
+// QueuePosition is where a resting order stood in the time priority queue at
+// its price level: how many open orders on the same side were ahead of it and
+// how much size they had between them.
+type QueuePosition struct {
+	ID          OrderID
+	Price       int64
+	OrdersAhead int64
+	SizeAhead   int64
+	Time        time.Time
+}
+
+func (p *QueuePosition) String() string {
+	return fmt.Sprintf("<QueuePosition of %s at price %d behind %d orders for %d units at %s>", p.ID, p.Price, p.OrdersAhead, p.SizeAhead, p.Time.String())
+}
+
+// queue returns an order's history and the histories placed before it at
+// the same price and on the same side, which is the order they'd be filled in.
+func (book *InMemoryOrderBook) queue(id OrderID) (*OrderHistory, []*OrderHistory, error) {
+	history, ok := book.Book[id]
+	if !ok {
+		return nil, nil, errOrderDoesNotExist
+	}
+
+	ahead := make([]*OrderHistory, 0)
+
+	for _, other := range book.PriceLevels[history.FirstVersion.Price] {
+		if other == history {
+			break
+		}
+		if other.FirstVersion.Side == history.FirstVersion.Side {
+			ahead = append(ahead, other)
+		}
+	}
+
+	return history, ahead, nil
+}
+
+func queuePositionAt(history *OrderHistory, ahead []*OrderHistory, t time.Time) (*QueuePosition, error) {
+	if history.VersionAt(t).State != STATE_OPEN {
+		return nil, errOrderNotResting
+	}
+
+	p := &QueuePosition{ID: history.FirstVersion.ID, Price: history.FirstVersion.Price, Time: t}
+
+	for _, other := range ahead {
+		order := other.VersionAt(t)
+		if order.State == STATE_OPEN {
+			p.OrdersAhead += 1
+			p.SizeAhead += order.Size
+		}
+	}
+
+	return p, nil
+}
+
+// QueuePosition returns where an open order stands at its price level now.
+func (book *InMemoryOrderBook) QueuePosition(id OrderID) (*QueuePosition, error) {
+	return book.QueuePositionAt(id, book.LatestMutationTime)
+}
+
+// QueuePositionAt returns where an order stood at its price level at time t.
+func (book *InMemoryOrderBook) QueuePositionAt(id OrderID, t time.Time) (*QueuePosition, error) {
+	history, ahead, err := book.queue(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return queuePositionAt(history, ahead, t)
+}
+
+// QueuePositionHistory returns the queue position of an order every time it
+// changed while the order was open, oldest first.
+func (book *InMemoryOrderBook) QueuePositionHistory(id OrderID) ([]*QueuePosition, error) {
+	history, ahead, err := book.queue(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the times something happened to us or to an order ahead of us matter
+	times := make([]time.Time, 0, len(history.Mutations))
+	for _, h := range append(ahead, history) {
+		for _, mut := range h.Mutations {
+			times = append(times, mut.GetTime())
+		}
+	}
+	sort.Sort(timesInOrder(times))
+
+	positions := make([]*QueuePosition, 0)
+
+	for i, t := range times {
+		if i > 0 && t.Equal(times[i-1]) {
+			continue
+		}
+
+		p, err := queuePositionAt(history, ahead, t)
+		if err != nil {
+			continue
+		}
+
+		if n := len(positions); n > 0 && positions[n-1].OrdersAhead == p.OrdersAhead && positions[n-1].SizeAhead == p.SizeAhead {
+			continue
+		}
+
+		positions = append(positions, p)
+	}
+
+	return positions, nil
+}
+
+type timesInOrder []time.Time
+
+func (a timesInOrder) Len() int           { return len(a) }
+func (a timesInOrder) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a timesInOrder) Less(i, j int) bool { return a[i].Before(a[j]) }
@@ -0,0 +1,72 @@
+package book
+
+import "testing"
+import "time"
+
+func TestQueuePosition(t *testing.T) {
+	book := NewInMemoryOrderBook()
+
+	book.PlaceOrder(Order{ID: "first", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "other-side", Price: 100, Side: SIDE_SELL}, 50, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "second", Price: 100, Side: SIDE_BUY}, 20, time.Unix(1, 0))
+	book.PlaceOrder(Order{ID: "ours", Price: 100, Side: SIDE_BUY}, 5, time.Unix(2, 0))
+	book.PlaceOrder(Order{ID: "behind", Price: 100, Side: SIDE_BUY}, 7, time.Unix(3, 0))
+
+	for i, id := range []OrderID{"first", "other-side", "second", "ours", "behind"} {
+		book.MutateOrder(id, []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(int64(i), 500)}})
+	}
+
+	if _, err := book.QueuePositionAt("ours", time.Unix(2, 0)); err != errOrderNotResting {
+		t.Fatal("Expected no queue position before the order opened")
+	}
+
+	p, err := book.QueuePosition("ours")
+	if err != nil {
+		t.Fatalf("Unexpected error getting queue position: %s", err.Error())
+	}
+	if p.OrdersAhead != 2 || p.SizeAhead != 30 {
+		t.Fatalf("Expected 2 orders for 30 units ahead, instead %s", p)
+	}
+
+	book.MutateOrder("first", []OrderMutation{&OrderMatchMutation{Size: 4, WasMaker: true, Time: time.Unix(10, 0)}})
+	book.MutateOrder("second", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(11, 0)}})
+	book.MutateOrder("behind", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(12, 0)}})
+	book.MutateOrder("first", []OrderMutation{&OrderMatchMutation{Size: 6, WasMaker: true, Time: time.Unix(13, 0)}})
+
+	if p, _ := book.QueuePosition("ours"); p.OrdersAhead != 0 || p.SizeAhead != 0 {
+		t.Fatalf("Expected to be at the front of the queue, instead %s", p)
+	}
+	if p, _ := book.QueuePositionAt("ours", time.Unix(10, 0)); p.OrdersAhead != 2 || p.SizeAhead != 26 {
+		t.Fatalf("Expected 2 orders for 26 units ahead at t=10, instead %s", p)
+	}
+
+	positions, err := book.QueuePositionHistory("ours")
+	if err != nil {
+		t.Fatalf("Unexpected error getting queue position history: %s", err.Error())
+	}
+
+	expected := []struct {
+		t      int64
+		orders int64
+		size   int64
+	}{
+		{3, 2, 30},
+		{10, 2, 26},
+		{11, 1, 6},
+		{13, 0, 0},
+	}
+
+	if len(positions) != len(expected) {
+		t.Fatalf("Expected %d changes in queue position, instead %v", len(expected), positions)
+	}
+	for i, e := range expected {
+		p := positions[i]
+		if p.Time.Unix() != e.t || p.OrdersAhead != e.orders || p.SizeAhead != e.size {
+			t.Fatalf("Expected %d orders for %d units ahead at t=%d, instead %s", e.orders, e.size, e.t, p)
+		}
+	}
+
+	if _, err := book.QueuePosition("nope"); err != errOrderDoesNotExist {
+		t.Fatal("Expected an error for an order that doesn't exist")
+	}
+}
@@ -0,0 +1,72 @@
+package book
+
+import "fmt"
+import "sort"
+import "time"
+
+// RetentionPolicy decides which filled and voided orders survive a Vacuum.
+// An order is kept if it finished within KeepFor of the book's latest
+// mutation, or if it is one of the KeepLast orders that finished most
+// recently. The zero value keeps nothing.
+type RetentionPolicy struct {
+	KeepFor  time.Duration
+	KeepLast int
+}
+
+func (p RetentionPolicy) String() string {
+	return fmt.Sprintf("<RetentionPolicy keeping finished orders for %s or the last %d of them>", p.KeepFor, p.KeepLast)
+}
+
+// VacuumReport lists what a Vacuum removed. Removed orders are gone from
+// Book, PriceLevels and History alike, and had all finished by Horizon, so
+// versions of the book after Horizon are unaffected.
+type VacuumReport struct {
+	Orders      []OrderID
+	PriceLevels []int64
+	Horizon     time.Time
+}
+
+func (r VacuumReport) String() string {
+	return fmt.Sprintf("<VacuumReport of %d orders and %d price levels finished by %s>", len(r.Orders), len(r.PriceLevels), r.Horizon.String())
+}
+
+func isTerminal(order *StatefulOrder) bool {
+	return order.State == STATE_FILLED || order.State == STATE_VOID
+}
+
+type historiesByFinish []*OrderHistory
+
+func (a historiesByFinish) Len() int      { return len(a) }
+func (a historiesByFinish) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a historiesByFinish) Less(i, j int) bool {
+	return a[i].LatestVersion.LatestMutationTime.After(a[j].LatestVersion.LatestMutationTime)
+}
+
+// expired returns the finished orders in book that policy doesn't keep.
+func (p RetentionPolicy) expired(book *InMemoryOrderBook) map[*OrderHistory]bool {
+	finished := make([]*OrderHistory, 0)
+
+	for _, history := range book.Book {
+		if isTerminal(history.LatestVersion) {
+			finished = append(finished, history)
+		}
+	}
+
+	// Most recently finished first
+	sort.Sort(historiesByFinish(finished))
+
+	horizon := book.LatestMutationTime.Add(-p.KeepFor)
+	expired := make(map[*OrderHistory]bool)
+
+	for i, history := range finished {
+		if i < p.KeepLast {
+			continue
+		}
+		if p.KeepFor > 0 && history.LatestVersion.LatestMutationTime.After(horizon) {
+			continue
+		}
+		expired[history] = true
+	}
+
+	return expired
+}
@@ -0,0 +1,43 @@
+package book
+
+import "fmt"
+import "math/big"
+
+// Scale describes how a product's prices and sizes map onto the integers in
+// Order.Price and StatefulOrder.Size. A price of 1 is 10^-PriceDecimals of the
+// quote currency and a size of 1 is 10^-SizeDecimals of the base currency.
+type Scale struct {
+	PriceDecimals int
+	SizeDecimals  int
+}
+
+// DEFAULT_SCALE is cents and satoshi, which is what BTC-USD uses.
+var DEFAULT_SCALE = Scale{PriceDecimals: 2, SizeDecimals: 8}
+
+func (s Scale) String() string {
+	return fmt.Sprintf("<Scale with %d price decimals and %d size decimals>", s.PriceDecimals, s.SizeDecimals)
+}
+
+func (s Scale) ParsePrice(price string) (int64, error) {
+	return ParseDecimal(price, s.PriceDecimals)
+}
+
+func (s Scale) ParseSize(size string) (int64, error) {
+	return ParseDecimal(size, s.SizeDecimals)
+}
+
+func (s Scale) FormatPrice(price int64) string {
+	return FormatDecimal(price, s.PriceDecimals)
+}
+
+func (s Scale) FormatSize(size int64) string {
+	return FormatDecimal(size, s.SizeDecimals)
+}
+
+// Notional converts price * size into minor units of the quote currency,
+// truncating anything smaller than the price's minor unit.
+func (s Scale) Notional(price, size int64) int64 {
+	notional := new(big.Int).Mul(big.NewInt(price), big.NewInt(size))
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(s.SizeDecimals)), nil)
+	return notional.Quo(notional, unit).Int64()
+}
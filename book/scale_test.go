@@ -0,0 +1,34 @@
+package book
+
+import "testing"
+
+func TestScales(t *testing.T) {
+	scale := Scale{PriceDecimals: 5, SizeDecimals: 8}
+
+	price, err := scale.ParsePrice("0.03012")
+	if err != nil || price != 3012 {
+		t.Fatalf("Expected 0.03012 to parse as 3012, instead %d, %v", price, err)
+	}
+
+	if _, err = DEFAULT_SCALE.ParsePrice("0.03012"); err != errDecimalTooPrecise {
+		t.Fatalf("Expected a sub-cent price to be rejected by the default scale, instead %v", err)
+	}
+
+	size, err := scale.ParseSize("1.5")
+	if err != nil || size != 150000000 {
+		t.Fatalf("Expected 1.5 to parse as 150000000, instead %d, %v", size, err)
+	}
+
+	if scale.FormatPrice(price) != "0.03012" || scale.FormatSize(size) != "1.50000000" {
+		t.Fatalf("Unexpected formatting %s and %s", scale.FormatPrice(price), scale.FormatSize(size))
+	}
+
+	if scale.Notional(price, size) != 4518 {
+		t.Fatalf("Expected notional of 1.5 at 0.03012 to be 4518, instead %d", scale.Notional(price, size))
+	}
+
+	// 21M BTC at $1M doesn't fit in an int64 before dividing out the satoshis
+	if DEFAULT_SCALE.Notional(100000000, 2100000000000000) != 2100000000000000 {
+		t.Fatalf("Unexpected notional %d", DEFAULT_SCALE.Notional(100000000, 2100000000000000))
+	}
+}
@@ -0,0 +1,116 @@
+package book
+
+import "fmt"
+import "math/big"
+
+// DepthSource is anything with aggregated levels to fill against, like an
+// InMemoryOrderBook for its latest state or a BookSnapshot for a past one.
+type DepthSource interface {
+	Depth(side string, n int) []AggregatedPriceLevel
+}
+
+// Fill is the part of a simulated order filled at one price level.
+type Fill struct {
+	Price int64
+	Size  int64
+}
+
+// FillSimulation is what an order would have done against the book.
+//
+// Notional and Fees are in minor units of the quote currency, AveragePrice
+// and Slippage in price units. Slippage is how much worse than the mid the
+// average price is, so it is positive for buys above the mid and for sells
+// below it. Mid is the same one CalculateBidMedianAskSpreadInMemory gives,
+// and only if MidOk, which it isn't when either side of the book is empty.
+type FillSimulation struct {
+	Side         string
+	Fills        []Fill
+	Filled       int64
+	Leftover     int64
+	Notional     int64
+	Fees         int64
+	AveragePrice int64
+	Mid          int64
+	MidOk        bool
+	Slippage     int64
+}
+
+func (s *FillSimulation) String() string {
+	return fmt.Sprintf("<FillSimulation of a %s filling %d units over %d levels at %d on average; %d left over>", s.Side, s.Filled, len(s.Fills), s.AveragePrice, s.Leftover)
+}
+
+// FillSimulator walks the opposite side of a book to see how an order would
+// fill right now, paying TakerFeeBasisPoints on whatever it takes. Fees are
+// truncated to the quote currency's minor unit.
+type FillSimulator struct {
+	Scale               Scale
+	TakerFeeBasisPoints int64
+}
+
+// Market simulates a market order of size units on side.
+func (f *FillSimulator) Market(source DepthSource, side string, size int64) *FillSimulation {
+	return f.simulate(source, side, size, func(level int64) bool { return true })
+}
+
+// Limit simulates the part of a limit order that would fill as soon as it
+// was placed. Whatever doesn't fill is Leftover, and would rest on the book.
+func (f *FillSimulator) Limit(source DepthSource, side string, price, size int64) *FillSimulation {
+	return f.simulate(source, side, size, func(level int64) bool {
+		if side == SIDE_BUY {
+			return level <= price
+		}
+		return level >= price
+	})
+}
+
+func (f *FillSimulator) simulate(source DepthSource, side string, size int64, crosses func(level int64) bool) *FillSimulation {
+	s := &FillSimulation{Side: side, Fills: []Fill{}, Leftover: size}
+
+	bids := source.Depth(SIDE_BUY, 1)
+	asks := source.Depth(SIDE_SELL, 1)
+	if len(bids) > 0 && len(asks) > 0 {
+		s.Mid = bids[0].Price + (asks[0].Price-bids[0].Price)/2
+		s.MidOk = true
+	}
+
+	notional := new(big.Int)
+
+	for _, level := range source.Depth(oppositeSide(side), 0) {
+		if s.Leftover == 0 || !crosses(level.Price) {
+			break
+		}
+
+		take := level.Size
+		if take > s.Leftover {
+			take = s.Leftover
+		}
+
+		s.Fills = append(s.Fills, Fill{Price: level.Price, Size: take})
+		s.Filled += take
+		s.Leftover -= take
+
+		notional.Add(notional, new(big.Int).Mul(big.NewInt(level.Price), big.NewInt(take)))
+	}
+
+	if s.Filled == 0 {
+		return s
+	}
+
+	s.AveragePrice = new(big.Int).Quo(notional, big.NewInt(s.Filled)).Int64()
+
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(f.Scale.SizeDecimals)), nil)
+	quote := new(big.Int).Quo(notional, unit)
+	s.Notional = quote.Int64()
+
+	fees := new(big.Int).Mul(quote, big.NewInt(f.TakerFeeBasisPoints))
+	s.Fees = fees.Quo(fees, big.NewInt(10000)).Int64()
+
+	if s.MidOk {
+		s.Slippage = s.AveragePrice - s.Mid
+		if side == SIDE_SELL {
+			s.Slippage = -s.Slippage
+		}
+	}
+
+	return s
+}
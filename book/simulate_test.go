@@ -0,0 +1,101 @@
+package book
+
+import "testing"
+import "time"
+
+// simulationBook has asks of 1 BTC at 101.00, 2 BTC at 102.00 and 4 BTC at
+// 105.00 and a bid of 1 BTC at 99.00.
+func simulationBook() *InMemoryOrderBook {
+	book := NewInMemoryOrderBook()
+
+	orders := []struct {
+		id    OrderID
+		price int64
+		size  int64
+		side  string
+	}{
+		{"a", 10100, 100000000, SIDE_SELL},
+		{"b", 10200, 150000000, SIDE_SELL},
+		{"c", 10200, 50000000, SIDE_SELL},
+		{"d", 10500, 400000000, SIDE_SELL},
+		{"e", 9900, 100000000, SIDE_BUY},
+	}
+
+	for _, o := range orders {
+		book.PlaceOrder(Order{ID: o.id, Price: o.price, Side: o.side}, o.size, time.Unix(0, 0))
+		book.MutateOrder(o.id, []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(1, 0)}})
+	}
+
+	return book
+}
+
+func TestSimulatingMarketOrders(t *testing.T) {
+	book := simulationBook()
+	f := &FillSimulator{Scale: book.Scale, TakerFeeBasisPoints: 25}
+
+	s := f.Market(book, SIDE_BUY, 250000000)
+
+	if len(s.Fills) != 2 || s.Fills[0] != (Fill{10100, 100000000}) || s.Fills[1] != (Fill{10200, 150000000}) {
+		t.Fatalf("Expected fills of 1 BTC at 101.00 and 1.5 BTC at 102.00, instead %v", s.Fills)
+	}
+	if s.Filled != 250000000 || s.Leftover != 0 {
+		t.Fatalf("Expected the whole order to fill, instead %s", s)
+	}
+	if s.Notional != 25400 || s.AveragePrice != 10160 {
+		t.Fatalf("Expected 254.00 at 101.60 on average, instead %d at %d", s.Notional, s.AveragePrice)
+	}
+	if s.Fees != 63 {
+		t.Fatalf("Expected 0.25%% fees of 0.63, instead %d", s.Fees)
+	}
+
+	_, median, _, _ := CalculateBidMedianAskSpreadInMemory(book, time.Now())
+	if !s.MidOk || s.Mid != median || s.Slippage != 10160-median {
+		t.Fatalf("Expected slippage of %d against a mid of %d, instead %d against %d", 10160-median, median, s.Slippage, s.Mid)
+	}
+
+	s = f.Market(book, SIDE_BUY, 1000000000)
+	if s.Filled != 700000000 || s.Leftover != 300000000 {
+		t.Fatalf("Expected 7 BTC to fill and 3 to be left over, instead %s", s)
+	}
+
+	s = f.Market(book, SIDE_SELL, 50000000)
+	if s.AveragePrice != 9900 || s.Slippage != 100 {
+		t.Fatalf("Expected selling at 99.00 to slip 1.00 below the mid, instead %s with slippage %d", s, s.Slippage)
+	}
+}
+
+func TestSimulatingLimitOrders(t *testing.T) {
+	book := simulationBook()
+	f := &FillSimulator{Scale: book.Scale}
+
+	s := f.Limit(book, SIDE_BUY, 10200, 500000000)
+
+	if s.Filled != 300000000 || s.Leftover != 200000000 || len(s.Fills) != 2 {
+		t.Fatalf("Expected 3 BTC to fill up to 102.00 and 2 to rest, instead %s", s)
+	}
+	if s.Fees != 0 {
+		t.Fatalf("Expected no fees by default, instead %d", s.Fees)
+	}
+
+	s = f.Limit(book, SIDE_SELL, 10000, 100000000)
+	if s.Filled != 0 || s.Leftover != 100000000 || s.AveragePrice != 0 {
+		t.Fatalf("Expected a sell above the best bid not to fill, instead %s", s)
+	}
+}
+
+func TestSimulatingAgainstSnapshots(t *testing.T) {
+	book := simulationBook()
+	book.MutateOrder("a", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(2, 0)}})
+
+	f := &FillSimulator{Scale: book.Scale}
+
+	if s := f.Market(book, SIDE_BUY, 100000000); s.AveragePrice != 10200 {
+		t.Fatalf("Expected to buy at 102.00 now, instead %s", s)
+	}
+	if s := f.Market(book.SnapshotAt(time.Unix(1, 0)), SIDE_BUY, 100000000); s.AveragePrice != 10100 {
+		t.Fatalf("Expected to buy at 101.00 at t=1, instead %s", s)
+	}
+	if s := f.Market(NewInMemoryOrderBook(), SIDE_BUY, 1); s.MidOk || s.Leftover != 1 {
+		t.Fatalf("Expected nothing to fill against an empty book, instead %s", s)
+	}
+}
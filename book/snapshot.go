@@ -0,0 +1,283 @@
+package book
+
+import "fmt"
+import "sort"
+import "time"
+
+// BookSnapshot is a read-only view of every open order in a book as it stood
+// at Time, aggregated into price levels for both sides. It shares
+// StatefulOrders with the book, so don't modify them.
+type BookSnapshot struct {
+	Time time.Time
+
+	orders map[OrderID]*StatefulOrder
+	bids   []AggregatedPriceLevel
+	asks   []AggregatedPriceLevel
+}
+
+func (s *BookSnapshot) String() string {
+	return fmt.Sprintf("<BookSnapshot with %d open orders at %s>", len(s.orders), s.Time.String())
+}
+
+// GetOrder returns an order if it was open at the time of the snapshot.
+func (s *BookSnapshot) GetOrder(id OrderID) (*StatefulOrder, error) {
+	order, ok := s.orders[id]
+	if !ok {
+		return nil, errOrderDoesNotExist
+	}
+	return order, nil
+}
+
+func (s *BookSnapshot) NumberOfOpenOrders() int64 {
+	return int64(len(s.orders))
+}
+
+func (s *BookSnapshot) BestBid() (int64, bool) {
+	if len(s.bids) == 0 {
+		return 0, false
+	}
+	return s.bids[0].Price, true
+}
+
+func (s *BookSnapshot) BestAsk() (int64, bool) {
+	if len(s.asks) == 0 {
+		return 0, false
+	}
+	return s.asks[0].Price, true
+}
+
+// Depth returns up to n of the best price levels on one side, or all of them
+// if n isn't positive.
+func (s *BookSnapshot) Depth(side string, n int) []AggregatedPriceLevel {
+	var levels []AggregatedPriceLevel
+
+	switch side {
+	case SIDE_BUY:
+		levels = s.bids
+	case SIDE_SELL:
+		levels = s.asks
+	}
+
+	if n > 0 && len(levels) > n {
+		levels = levels[:n]
+	}
+
+	return append([]AggregatedPriceLevel(nil), levels...)
+}
+
+// SnapshotAt rebuilds the open orders of the whole book as of time t. The
+// work is cached, so walking forward in time only replays the orders that
+// changed since the previous call, including ones the book got in between.
+// Walking backwards, or changing the book at or before the previous call's
+// time, starts over from the beginning.
+//
+// Orders removed by Vacuum are gone from History too, so a snapshot from
+// before the Horizon of any VacuumReport is missing whichever of them were
+// open at the time.
+func (book *InMemoryOrderBook) SnapshotAt(t time.Time) *BookSnapshot {
+	return book.seek(t).snapshot()
+}
+
+// BestBidAskAt returns the best bid and ask as of time t, and whether both
+// sides had any open orders. It moves the same cursor as SnapshotAt, without
+// copying the book, so it is cheap to call for many times in order.
+func (book *InMemoryOrderBook) BestBidAskAt(t time.Time) (bid, ask int64, ok bool) {
+	c := book.seek(t)
+
+	bestBid, bidOk := c.levels.bids.best()
+	bestAsk, askOk := c.levels.asks.best()
+
+	return bestBid.Price, bestAsk.Price, bidOk && askOk
+}
+
+// seek returns the book's snapshot cursor moved to t.
+func (book *InMemoryOrderBook) seek(t time.Time) *snapshotCursor {
+	if book.cursor == nil {
+		book.cursor = newSnapshotCursor(book)
+	} else if t.Before(book.cursor.t) {
+		book.cursor.reset()
+	}
+
+	book.cursor.advance(t)
+
+	return book.cursor
+}
+
+// cursorMutated tells the cursor about a mutation of history at t. One after
+// the cursor is simply walked over later, but one at or before it changes
+// what the cursor has already seen, so it has to start over.
+func (book *InMemoryOrderBook) cursorMutated(history *OrderHistory, t time.Time) {
+	if book.cursor == nil {
+		return
+	}
+
+	if t.After(book.cursor.t) {
+		book.cursor.insert(snapshotEvent{t, history})
+	} else {
+		book.cursor = nil
+	}
+}
+
+// cursorVacuumed tells the cursor Vacuum removed the expired orders, all of
+// which were finished by horizon. If the cursor is past that they weren't open
+// as of it and it only has to forget them.
+func (book *InMemoryOrderBook) cursorVacuumed(expired map[*OrderHistory]bool, horizon time.Time) {
+	if book.cursor == nil {
+		return
+	}
+
+	if horizon.After(book.cursor.t) {
+		book.cursor = nil
+	} else {
+		book.cursor.forget(expired)
+	}
+}
+
+// Snapshot copies the open orders in the book as of LatestMutationTime. Unlike
+// SnapshotAt it doesn't change the book, so it is safe alongside other readers.
+func (book *InMemoryOrderBook) Snapshot() *BookSnapshot {
+	s := &BookSnapshot{
+		Time:   book.LatestMutationTime,
+		orders: make(map[OrderID]*StatefulOrder, book.levels.openOrders),
+		bids:   book.Depth(SIDE_BUY, 0),
+		asks:   book.Depth(SIDE_SELL, 0),
+	}
+
+	for id, history := range book.Book {
+		if history.LatestVersion.State == STATE_OPEN {
+			s.orders[id] = history.LatestVersion
+		}
+	}
+
+	return s
+}
+
+type snapshotEvent struct {
+	time    time.Time
+	history *OrderHistory
+}
+
+type snapshotEventsByTime []snapshotEvent
+
+func (a snapshotEventsByTime) Len() int           { return len(a) }
+func (a snapshotEventsByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a snapshotEventsByTime) Less(i, j int) bool { return a[i].time.Before(a[j].time) }
+
+// snapshotCursor walks every mutation in a book in time order, keeping the
+// open version of each order as of t.
+type snapshotCursor struct {
+	events []snapshotEvent
+	next   int
+	t      time.Time
+
+	open   map[*OrderHistory]*StatefulOrder
+	levels *levelBook
+}
+
+func newSnapshotCursor(book *InMemoryOrderBook) *snapshotCursor {
+	events := make([]snapshotEvent, 0, len(book.History))
+
+	// History only has what Vacuum kept, like Book does
+	for _, history := range book.History {
+		for _, mut := range history.Mutations {
+			events = append(events, snapshotEvent{mut.GetTime(), history})
+		}
+	}
+
+	sort.Sort(snapshotEventsByTime(events))
+
+	c := &snapshotCursor{events: events}
+	c.reset()
+
+	return c
+}
+
+func (c *snapshotCursor) reset() {
+	c.next = 0
+	c.t = time.Time{}
+	c.open = make(map[*OrderHistory]*StatefulOrder)
+	c.levels = newLevelBook()
+}
+
+// insert adds an event after c.t, keeping the ones still to walk in time order.
+func (c *snapshotCursor) insert(event snapshotEvent) {
+	// After anything at the same time, like a stable sort would put it
+	pos := c.next + sort.Search(len(c.events)-c.next, func(i int) bool { return c.events[c.next+i].time.After(event.time) })
+
+	c.events = append(c.events, snapshotEvent{})
+	copy(c.events[pos+1:], c.events[pos:])
+	c.events[pos] = event
+}
+
+// forget drops the events of histories that are no longer in the book, which
+// must all be ones the cursor has already walked over.
+func (c *snapshotCursor) forget(removed map[*OrderHistory]bool) {
+	kept := c.events[:0]
+	next := 0
+
+	for i, event := range c.events {
+		if removed[event.history] {
+			continue
+		}
+		if i < c.next {
+			next += 1
+		}
+		kept = append(kept, event)
+	}
+
+	for i := len(kept); i < len(c.events); i++ {
+		c.events[i] = snapshotEvent{}
+	}
+
+	c.events = kept
+	c.next = next
+}
+
+// advance moves the cursor forward to t, which must not be before c.t.
+func (c *snapshotCursor) advance(t time.Time) {
+	touched := make(map[*OrderHistory]bool)
+
+	for c.next < len(c.events) && !c.events[c.next].time.After(t) {
+		touched[c.events[c.next].history] = true
+		c.next += 1
+	}
+
+	for history := range touched {
+		previous := c.open[history]
+		order := history.VersionAt(t)
+
+		c.levels.track(previous, order)
+
+		if order.State == STATE_OPEN {
+			c.open[history] = order
+		} else {
+			delete(c.open, history)
+		}
+	}
+
+	c.t = t
+}
+
+func (c *snapshotCursor) snapshot() *BookSnapshot {
+	s := &BookSnapshot{
+		Time:   c.t,
+		orders: make(map[OrderID]*StatefulOrder, len(c.open)),
+		bids:   make([]AggregatedPriceLevel, 0, c.levels.bids.len()),
+		asks:   make([]AggregatedPriceLevel, 0, c.levels.asks.len()),
+	}
+
+	for _, order := range c.open {
+		s.orders[order.ID] = order
+	}
+
+	c.levels.bids.each(func(level AggregatedPriceLevel) bool {
+		s.bids = append(s.bids, level)
+		return true
+	})
+	c.levels.asks.each(func(level AggregatedPriceLevel) bool {
+		s.asks = append(s.asks, level)
+		return true
+	})
+
+	return s
+}
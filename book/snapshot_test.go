@@ -0,0 +1,183 @@
+package book
+
+import "testing"
+import "time"
+
+func TestSnapshotAt(t *testing.T) {
+	book := NewInMemoryOrderBook()
+
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "b", Price: 105, Side: SIDE_SELL}, 3, time.Unix(0, 0))
+	book.MutateOrder("a", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(1, 0)}})
+	book.MutateOrder("b", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(2, 0)}})
+	book.MutateOrder("a", []OrderMutation{&OrderMatchMutation{Size: 4, WasMaker: true, Time: time.Unix(3, 0)}})
+	book.MutateOrder("b", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(4, 0)}})
+
+	snapshot := book.SnapshotAt(time.Unix(0, 0))
+	if snapshot.NumberOfOpenOrders() != 0 {
+		t.Fatalf("Expected no open orders at t=0, instead %d", snapshot.NumberOfOpenOrders())
+	}
+
+	snapshot = book.SnapshotAt(time.Unix(2, 0))
+	bid, _ := snapshot.BestBid()
+	ask, _ := snapshot.BestAsk()
+	if bid != 100 || ask != 105 {
+		t.Fatalf("Expected 100/105 at t=2, instead %d/%d", bid, ask)
+	}
+
+	older := snapshot
+
+	snapshot = book.SnapshotAt(time.Unix(3, 0))
+	if depth := snapshot.Depth(SIDE_BUY, 0); len(depth) != 1 || depth[0].Size != 6 {
+		t.Fatalf("Expected 6 units bid at t=3, instead %v", depth)
+	}
+	if order, _ := older.GetOrder("a"); order.Size != 10 {
+		t.Fatalf("Expected an earlier snapshot to keep its own version of a, instead %d units", order.Size)
+	}
+
+	snapshot = book.SnapshotAt(time.Unix(4, 0))
+	if _, ok := snapshot.BestAsk(); ok {
+		t.Fatal("Expected no asks once b was voided at t=4")
+	}
+	if _, err := snapshot.GetOrder("b"); err == nil {
+		t.Fatal("Expected voided order not to be in the snapshot")
+	}
+
+	// Walking back starts over
+	snapshot = book.SnapshotAt(time.Unix(2, 0))
+	if ask, _ := snapshot.BestAsk(); ask != 105 {
+		t.Fatalf("Expected ask of 105 walking back to t=2, instead %d", ask)
+	}
+
+	// And so does changing the book
+	book.PlaceOrder(Order{ID: "c", Price: 101, Side: SIDE_BUY}, 1, time.Unix(2, 0))
+	book.MutateOrder("c", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(2, 0)}})
+
+	snapshot = book.SnapshotAt(time.Unix(3, 0))
+	if bid, _ := snapshot.BestBid(); bid != 101 {
+		t.Fatalf("Expected best bid of 101 after placing c, instead %d", bid)
+	}
+}
+
+func TestSnapshotCursorFollowsTheBook(t *testing.T) {
+	book := NewInMemoryOrderBook()
+
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	book.MutateOrder("a", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(1, 0)}})
+	book.SnapshotAt(time.Unix(1, 0))
+
+	cursor := book.cursor
+
+	book.PlaceOrder(Order{ID: "b", Price: 105, Side: SIDE_SELL}, 3, time.Unix(2, 0))
+	book.MutateOrder("b", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(2, 0)}})
+	book.MutateOrder("a", []OrderMutation{&OrderStateMutation{State: STATE_VOID, Time: time.Unix(3, 0)}})
+
+	snapshot := book.SnapshotAt(time.Unix(2, 0))
+	if book.cursor != cursor {
+		t.Fatal("Expected the cursor to be reused after appending to the book")
+	}
+	if bid, _ := snapshot.BestBid(); bid != 100 {
+		t.Fatalf("Expected a bid of 100 at t=2, instead %d", bid)
+	}
+	if ask, _ := snapshot.BestAsk(); ask != 105 {
+		t.Fatalf("Expected an ask of 105 at t=2, instead %d", ask)
+	}
+
+	snapshot = book.SnapshotAt(time.Unix(3, 0))
+	if book.cursor != cursor {
+		t.Fatal("Expected the cursor to be reused walking forward")
+	}
+	if _, ok := snapshot.BestBid(); ok {
+		t.Fatal("Expected no bids once a was voided at t=3")
+	}
+
+	// Vacuuming what finished by the cursor keeps it too
+	book.Vacuum()
+
+	if book.cursor != cursor {
+		t.Fatal("Expected the cursor to be kept after vacuuming orders it walked past")
+	}
+	for _, event := range cursor.events {
+		if event.history.FirstVersion.ID == "a" {
+			t.Fatal("Expected the cursor to forget vacuumed orders")
+		}
+	}
+
+	// A mutation at or before the cursor changes what it has seen
+	book.MutateOrder("b", []OrderMutation{&OrderMatchMutation{Size: 1, WasMaker: true, Time: time.Unix(3, 0)}})
+
+	snapshot = book.SnapshotAt(time.Unix(3, 0))
+	if book.cursor == cursor {
+		t.Fatal("Expected the cursor to start over after a mutation at its time")
+	}
+	if depth := snapshot.Depth(SIDE_SELL, 0); len(depth) != 1 || depth[0].Size != 2 {
+		t.Fatalf("Expected 2 units asked at t=3, instead %v", depth)
+	}
+}
+
+func TestBestBidAskAt(t *testing.T) {
+	book := NewInMemoryOrderBook()
+
+	book.PlaceOrder(Order{ID: "a", Price: 100, Side: SIDE_BUY}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "b", Price: 105, Side: SIDE_SELL}, 3, time.Unix(0, 0))
+	book.MutateOrder("a", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(1, 0)}})
+	book.MutateOrder("b", []OrderMutation{&OrderStateMutation{State: STATE_OPEN, Time: time.Unix(2, 0)}})
+
+	if _, _, ok := book.BestBidAskAt(time.Unix(1, 0)); ok {
+		t.Fatal("Expected no best bid and ask before both sides had orders")
+	}
+
+	bid, ask, ok := book.BestBidAskAt(time.Unix(2, 0))
+	if !ok || bid != 100 || ask != 105 {
+		t.Fatalf("Expected 100/105 at t=2, instead %d/%d", bid, ask)
+	}
+
+	// It agrees with SnapshotAt walking back too
+	if _, _, ok := book.BestBidAskAt(time.Unix(1, 0)); ok {
+		t.Fatal("Expected no best bid and ask walking back to t=1")
+	}
+	if snapshot := book.SnapshotAt(time.Unix(2, 0)); snapshot.NumberOfOpenOrders() != 2 {
+		t.Fatalf("Expected 2 open orders at t=2, instead %d", snapshot.NumberOfOpenOrders())
+	}
+}
+
+func TestSnapshotAtMatchesDepthVersion(t *testing.T) {
+	book := randomBook(500)
+
+	for i := int64(0); i < 500; i += 7 {
+		at := time.Unix(i, 0)
+		snapshot := book.SnapshotAt(at)
+
+		for _, side := range []string{SIDE_BUY, SIDE_SELL} {
+			expected := book.DepthVersion(side, 0, at)
+			depth := snapshot.Depth(side, 0)
+
+			if len(depth) != len(expected) {
+				t.Fatalf("Expected %d %s levels at t=%d, instead %d", len(expected), side, i, len(depth))
+			}
+			for j := range expected {
+				if depth[j] != expected[j] {
+					t.Fatalf("Expected %s level %d at t=%d to be %s, instead %s", side, j, i, expected[j], depth[j])
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkScrubbingSnapshots(b *testing.B) {
+	book := randomBook(5000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		book.SnapshotAt(time.Unix(int64(i%5000), 0))
+	}
+}
+
+func BenchmarkScrubbingDepthVersion(b *testing.B) {
+	book := randomBook(5000)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		book.DepthVersion(SIDE_BUY, 0, time.Unix(int64(i%5000), 0))
+	}
+}
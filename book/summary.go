@@ -20,7 +20,36 @@ func CalculateTotalCentsInPlayInMemory(book *InMemoryOrderBook, t time.Time) int
 	return centsInPlay
 }
 
+// Calculate the value of all open orders in minor units of the quote currency
+// (cents for BTC-USD), using the book's scale
+func CalculateNotionalInPlayInMemory(book *InMemoryOrderBook, t time.Time) int64 {
+	var notional int64 = 0
+
+	levels := book.GetPriceLevels()
+
+	for _, level := range levels {
+		orders := book.GetPriceLevelVersion(level, t)
+		for _, order := range orders {
+			if order.State == STATE_OPEN {
+				notional += book.Scale.Notional(order.Price, order.Size)
+			}
+		}
+	}
+
+	return notional
+}
+
+// CalculateNumberOfOpenOrdersInMemory uses the book's price level index when t
+// is at or after the latest mutation, and replays every order otherwise.
 func CalculateNumberOfOpenOrdersInMemory(book *InMemoryOrderBook, t time.Time) int64 {
+	if !t.Before(book.LatestMutationTime) {
+		return book.NumberOfOpenOrders()
+	}
+
+	return scanNumberOfOpenOrders(book, t)
+}
+
+func scanNumberOfOpenOrders(book *InMemoryOrderBook, t time.Time) int64 {
 	var openOrders int64 = 0
 
 	for orderId, _ := range book.Book {
@@ -33,7 +62,31 @@ func CalculateNumberOfOpenOrdersInMemory(book *InMemoryOrderBook, t time.Time) i
 	return openOrders
 }
 
+// CalculateBidMedianAskSpreadInMemory returns -1 for a side with no open orders.
+// Like CalculateNumberOfOpenOrdersInMemory, it only scans the whole book for
+// times before the latest mutation.
 func CalculateBidMedianAskSpreadInMemory(book *InMemoryOrderBook, t time.Time) (bid, median, ask, spread int64) {
+	if t.Before(book.LatestMutationTime) {
+		return scanBidMedianAskSpread(book, t)
+	}
+
+	bid = -1
+	ask = -1
+
+	if price, ok := book.BestBid(); ok {
+		bid = price
+	}
+	if price, ok := book.BestAsk(); ok {
+		ask = price
+	}
+
+	median = bid + ((ask - bid) / 2)
+	spread = (ask - bid)
+
+	return bid, median, ask, spread
+}
+
+func scanBidMedianAskSpread(book *InMemoryOrderBook, t time.Time) (bid, median, ask, spread int64) {
 	bid = -1
 	ask = -1
 
@@ -144,3 +144,35 @@ func TestCalculateBidMedianAsk(t *testing.T) {
 	}
 
 }
+
+func TestCalculateNotionalInPlayInMemory(t *testing.T) {
+	// ETH-BTC style product: prices in 10^-5 BTC, sizes in 10^-8 ETH
+	book := NewScaledInMemoryOrderBook(Scale{PriceDecimals: 5, SizeDecimals: 8})
+	orderOne := Order{ID: "foobar", Price: 3012, Side: SIDE_BUY}
+	orderTwo := Order{ID: "bazbar", Price: 3020, Side: SIDE_SELL}
+	book.PlaceOrder(orderOne, 150000000, time.Unix(0, 0))
+	book.PlaceOrder(orderTwo, 50000000, time.Unix(0, 0))
+
+	book.MutateOrder("foobar", []OrderMutation{&OrderStateMutation{
+		State: STATE_OPEN,
+		Time:  time.Unix(1, 0),
+	}})
+	book.MutateOrder("bazbar", []OrderMutation{&OrderStateMutation{
+		State: STATE_OPEN,
+		Time:  time.Unix(1, 0),
+	}})
+
+	notional := CalculateNotionalInPlayInMemory(book, time.Unix(0, 0))
+	if notional != 0 {
+		t.Fatalf("Expected notional in play at t=0 to be 0, instead %d", notional)
+	}
+
+	// 1.5 * 0.03012 + 0.5 * 0.03020 = 0.06028 BTC
+	notional = CalculateNotionalInPlayInMemory(book, time.Unix(1, 0))
+	if notional != 6028 {
+		t.Fatalf("Expected notional in play at t=1 to be 6028, instead %d", notional)
+	}
+	if book.Scale.FormatPrice(notional) != "0.06028" {
+		t.Fatalf("Expected notional to format as 0.06028, instead %s", book.Scale.FormatPrice(notional))
+	}
+}
@@ -0,0 +1,248 @@
+package book
+
+import "fmt"
+import "math/big"
+import "sort"
+import "time"
+
+// Trade is a match between a resting maker order and an aggressing taker.
+// AggressorSide is the taker's side.
+type Trade struct {
+	ID            int64
+	Price         int64
+	Size          int64
+	AggressorSide string
+	MakerID       OrderID
+	TakerID       OrderID
+	Time          time.Time
+}
+
+func (t *Trade) String() string {
+	return fmt.Sprintf("<Trade of %d units at price %d; %s aggressor; trade id=%d>", t.Size, t.Price, t.AggressorSide, t.ID)
+}
+
+func oppositeSide(side string) string {
+	if side == SIDE_BUY {
+		return SIDE_SELL
+	}
+	return SIDE_BUY
+}
+
+// TradeFromMatch describes the trade behind a match mutation on order. Matches
+// without a price are assumed to be at the maker's price.
+func TradeFromMatch(order *StatefulOrder, m *OrderMatchMutation) Trade {
+	trade := Trade{
+		ID:            m.TradeID,
+		Price:         m.Price,
+		Size:          m.Size,
+		AggressorSide: order.Side,
+		MakerID:       m.MakerID,
+		TakerID:       m.TakerID,
+		Time:          m.Time,
+	}
+
+	if m.WasMaker {
+		trade.AggressorSide = oppositeSide(order.Side)
+		trade.MakerID = order.ID
+		if trade.Price == 0 {
+			trade.Price = order.Price
+		}
+	} else {
+		trade.TakerID = order.ID
+	}
+
+	return trade
+}
+
+// TradeAggregate sums up the trades in a period. VWAP is the volume weighted
+// average price, truncated, and zero when there were no trades.
+type TradeAggregate struct {
+	Trades     int64
+	Volume     int64
+	BuyVolume  int64
+	SellVolume int64
+	VWAP       int64
+}
+
+func (a TradeAggregate) String() string {
+	return fmt.Sprintf("<TradeAggregate of %d trades for %d units; vwap=%d>", a.Trades, a.Volume, a.VWAP)
+}
+
+// TradeTape keeps the most recent trades in time order in a ring buffer.
+// Each trade is only recorded once, however many times it's seen, for as
+// long as it is on the tape.
+type TradeTape struct {
+	trades []Trade
+
+	// first and next are the positions of the oldest trade and the one after
+	// the newest since the tape was made; trade n is at trades[n%len(trades)]
+	first int64
+	next  int64
+	ids   map[int64]int64
+
+	// Duplicates counts the trades that were seen more than once, and
+	// LateTrades the ones dropped for being older than everything on a full
+	// tape
+	Duplicates int64
+	LateTrades int64
+
+	// OnTrade, if set, is called with every trade the first time it is recorded
+	OnTrade func(Trade)
+}
+
+func NewTradeTape(capacity int) *TradeTape {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &TradeTape{
+		trades: make([]Trade, capacity),
+		ids:    make(map[int64]int64),
+	}
+}
+
+func (tape *TradeTape) String() string {
+	return fmt.Sprintf("<TradeTape of %d trades>", tape.Len())
+}
+
+func (tape *TradeTape) Len() int {
+	return int(tape.next - tape.first)
+}
+
+func (tape *TradeTape) at(n int64) *Trade {
+	return &tape.trades[n%int64(len(tape.trades))]
+}
+
+// Record adds a trade to the tape, returning false if it was already there or
+// the tape is full of newer trades.
+// Seeing a trade again fills in anything that was missing the first time,
+// like the taker of a trade first seen from the maker's side.
+func (tape *TradeTape) Record(trade Trade) bool {
+	if n, ok := tape.ids[trade.ID]; ok {
+		existing := tape.at(n)
+		if existing.MakerID == "" {
+			existing.MakerID = trade.MakerID
+		}
+		if existing.TakerID == "" {
+			existing.TakerID = trade.TakerID
+		}
+		tape.Duplicates += 1
+		return false
+	}
+
+	if tape.Len() == len(tape.trades) {
+		// Making room would push out a newer trade than this one
+		if trade.Time.Before(tape.at(tape.first).Time) {
+			tape.LateTrades += 1
+			return false
+		}
+
+		delete(tape.ids, tape.at(tape.first).ID)
+		tape.first += 1
+	}
+
+	n := tape.next
+	tape.next += 1
+	*tape.at(n) = trade
+	tape.ids[trade.ID] = n
+
+	// Late trades are moved back to where they belong
+	for n > tape.first && tape.at(n-1).Time.After(trade.Time) {
+		previous := *tape.at(n - 1)
+		*tape.at(n) = previous
+		tape.ids[previous.ID] = n
+		n -= 1
+		*tape.at(n) = trade
+		tape.ids[trade.ID] = n
+	}
+
+	if tape.OnTrade != nil {
+		tape.OnTrade(trade)
+	}
+
+	return true
+}
+
+// RecordMatch records the trade behind a match mutation on order.
+func (tape *TradeTape) RecordMatch(order *StatefulOrder, m *OrderMatchMutation) bool {
+	return tape.Record(TradeFromMatch(order, m))
+}
+
+// search returns the position of the first trade for which after is true.
+func (tape *TradeTape) search(after func(t time.Time) bool) int64 {
+	i := sort.Search(tape.Len(), func(i int) bool {
+		return after(tape.at(tape.first + int64(i)).Time)
+	})
+	return tape.first + int64(i)
+}
+
+// between returns the positions of the trades at or after start and before end.
+func (tape *TradeTape) between(start, end time.Time) (int64, int64) {
+	from := tape.search(func(t time.Time) bool { return !t.Before(start) })
+	to := tape.search(func(t time.Time) bool { return !t.Before(end) })
+	return from, to
+}
+
+// Trades returns the trades on the tape at or after start and before end.
+func (tape *TradeTape) Trades(start, end time.Time) []Trade {
+	from, to := tape.between(start, end)
+
+	trades := make([]Trade, 0, to-from)
+	for n := from; n < to; n++ {
+		trades = append(trades, *tape.at(n))
+	}
+
+	return trades
+}
+
+// Latest returns the most recent trade, if there is one.
+func (tape *TradeTape) Latest() (Trade, bool) {
+	if tape.Len() == 0 {
+		return Trade{}, false
+	}
+	return *tape.at(tape.next - 1), true
+}
+
+// Aggregate sums up the trades at or after start and before end.
+func (tape *TradeTape) Aggregate(start, end time.Time) TradeAggregate {
+	from, to := tape.between(start, end)
+	return tape.aggregate(from, to)
+}
+
+// Window sums up the trades in the d leading up to and including the most
+// recent trade.
+func (tape *TradeTape) Window(d time.Duration) TradeAggregate {
+	latest, ok := tape.Latest()
+	if !ok {
+		return TradeAggregate{}
+	}
+
+	from := tape.search(func(t time.Time) bool { return t.After(latest.Time.Add(-d)) })
+	return tape.aggregate(from, tape.next)
+}
+
+func (tape *TradeTape) aggregate(from, to int64) TradeAggregate {
+	var a TradeAggregate
+	notional := new(big.Int)
+
+	for n := from; n < to; n++ {
+		trade := tape.at(n)
+
+		a.Trades += 1
+		a.Volume += trade.Size
+
+		if trade.AggressorSide == SIDE_BUY {
+			a.BuyVolume += trade.Size
+		} else {
+			a.SellVolume += trade.Size
+		}
+
+		notional.Add(notional, new(big.Int).Mul(big.NewInt(trade.Price), big.NewInt(trade.Size)))
+	}
+
+	if a.Volume > 0 {
+		a.VWAP = notional.Quo(notional, big.NewInt(a.Volume)).Int64()
+	}
+
+	return a
+}
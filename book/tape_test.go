@@ -0,0 +1,116 @@
+package book
+
+import "testing"
+import "time"
+
+func TestRecordingTrades(t *testing.T) {
+	tape := NewTradeTape(3)
+
+	for i := int64(1); i <= 4; i++ {
+		if !tape.Record(Trade{ID: i, Price: 100 + i, Size: 10, AggressorSide: SIDE_BUY, Time: time.Unix(i, 0)}) {
+			t.Fatalf("Expected trade %d to be recorded", i)
+		}
+	}
+
+	if tape.Len() != 3 {
+		t.Fatalf("Expected tape to hold 3 trades, instead %d", tape.Len())
+	}
+	if trades := tape.Trades(time.Unix(0, 0), time.Unix(10, 0)); trades[0].ID != 2 || trades[2].ID != 4 {
+		t.Fatalf("Expected the oldest trade to be pushed out, instead %v", trades)
+	}
+
+	if tape.Record(Trade{ID: 3, Price: 103, Size: 10, TakerID: "taker", Time: time.Unix(3, 0)}) {
+		t.Fatal("Expected a trade seen twice to be recorded once")
+	}
+	if trades := tape.Trades(time.Unix(3, 0), time.Unix(4, 0)); len(trades) != 1 || trades[0].TakerID != "taker" {
+		t.Fatalf("Expected a duplicate to fill in the taker, instead %v", trades)
+	}
+	if tape.Duplicates != 1 {
+		t.Fatalf("Expected 1 duplicate, instead %d", tape.Duplicates)
+	}
+
+	// A late trade goes where it belongs
+	tape.Record(Trade{ID: 5, Price: 100, Size: 10, Time: time.Unix(3, 500)})
+
+	trades := tape.Trades(time.Unix(0, 0), time.Unix(10, 0))
+	if len(trades) != 3 || trades[0].ID != 3 || trades[1].ID != 5 || trades[2].ID != 4 {
+		t.Fatalf("Expected trades 3, 5 and 4 in time order, instead %v", trades)
+	}
+	if tape.Record(Trade{ID: 5, Time: time.Unix(3, 500)}) {
+		t.Fatal("Expected a moved trade to still be recognized")
+	}
+}
+
+func TestDroppingTradesOlderThanAFullTape(t *testing.T) {
+	tape := NewTradeTape(2)
+
+	tape.Record(Trade{ID: 2, Time: time.Unix(2, 0)})
+	tape.Record(Trade{ID: 3, Time: time.Unix(3, 0)})
+
+	if tape.Record(Trade{ID: 1, Time: time.Unix(1, 0)}) {
+		t.Fatal("Expected a trade older than a full tape not to be recorded")
+	}
+
+	trades := tape.Trades(time.Unix(0, 0), time.Unix(10, 0))
+	if len(trades) != 2 || trades[0].ID != 2 || trades[1].ID != 3 {
+		t.Fatalf("Expected trades 2 and 3 to be kept, instead %v", trades)
+	}
+	if tape.LateTrades != 1 {
+		t.Fatalf("Expected 1 late trade, instead %d", tape.LateTrades)
+	}
+
+	// Late but not older than everything still pushes out the oldest
+	tape.Record(Trade{ID: 4, Time: time.Unix(2, 500)})
+
+	trades = tape.Trades(time.Unix(0, 0), time.Unix(10, 0))
+	if len(trades) != 2 || trades[0].ID != 4 || trades[1].ID != 3 {
+		t.Fatalf("Expected trades 4 and 3, instead %v", trades)
+	}
+}
+
+func TestAggregatingTrades(t *testing.T) {
+	tape := NewTradeTape(100)
+
+	tape.Record(Trade{ID: 1, Price: 100, Size: 10, AggressorSide: SIDE_BUY, Time: time.Unix(0, 0)})
+	tape.Record(Trade{ID: 2, Price: 110, Size: 30, AggressorSide: SIDE_SELL, Time: time.Unix(30, 0)})
+	tape.Record(Trade{ID: 3, Price: 120, Size: 10, AggressorSide: SIDE_BUY, Time: time.Unix(60, 0)})
+
+	a := tape.Aggregate(time.Unix(0, 0), time.Unix(60, 0))
+	if a.Trades != 2 || a.Volume != 40 || a.BuyVolume != 10 || a.SellVolume != 30 || a.VWAP != 107 {
+		t.Fatalf("Expected 2 trades of 40 units with a vwap of 107, instead %s", a)
+	}
+
+	a = tape.Window(30 * time.Second)
+	if a.Trades != 1 || a.VWAP != 120 {
+		t.Fatalf("Expected only the latest trade in a 30s window, instead %s", a)
+	}
+
+	a = tape.Window(time.Minute)
+	if a.Trades != 2 || a.Volume != 40 || a.VWAP != 112 {
+		t.Fatalf("Expected the last 2 trades in a 1m window, instead %s", a)
+	}
+
+	if a := NewTradeTape(10).Window(time.Minute); a.Trades != 0 || a.VWAP != 0 {
+		t.Fatalf("Expected nothing on an empty tape, instead %s", a)
+	}
+}
+
+func TestTapingMatches(t *testing.T) {
+	book := NewInMemoryOrderBook()
+	book.Tape = NewTradeTape(10)
+
+	book.PlaceOrder(Order{ID: "maker", Price: 100, Side: SIDE_SELL}, 10, time.Unix(0, 0))
+	book.PlaceOrder(Order{ID: "taker", Price: 105, Side: SIDE_BUY}, 4, time.Unix(1, 0))
+
+	book.MutateOrder("taker", []OrderMutation{&OrderMatchMutation{TradeID: 9, Price: 100, Size: 4, MakerID: "maker", Time: time.Unix(1, 0)}})
+	book.MutateOrder("maker", []OrderMutation{&OrderMatchMutation{TradeID: 9, Size: 4, WasMaker: true, Time: time.Unix(1, 0)}})
+
+	if tape := book.Tape; tape.Len() != 1 || tape.Duplicates != 1 {
+		t.Fatalf("Expected both sides of a match to make one trade, instead %d trades", tape.Len())
+	}
+
+	trade, _ := book.Tape.Latest()
+	if trade.Price != 100 || trade.Size != 4 || trade.AggressorSide != SIDE_BUY || trade.MakerID != "maker" || trade.TakerID != "taker" {
+		t.Fatalf("Expected a buy of 4 units at 100 from maker to taker, instead %s", &trade)
+	}
+}
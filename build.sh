@@ -1,5 +1,12 @@
 #!/bin/bash
-go get -v ./...
-go get code.google.com/p/go.tools/cmd/cover
+set -e
+
+# gorilla/websocket's master branch has moved on from Go 1.7, so pin the
+# release the tree is tested against
+WEBSOCKET_VERSION=v1.2.0
+
+go get -d -v ./...
+(cd "${GOPATH%%:*}/src/github.com/gorilla/websocket" && git checkout -q "$WEBSOCKET_VERSION")
+
 go install -v ./...
 go test -cover -v ./...
@@ -0,0 +1,315 @@
+package coinbase
+
+import "errors"
+import "fmt"
+import "net/url"
+import "strconv"
+import "time"
+import "github.com/jacobgreenleaf/yeti/book"
+
+const (
+	ORDER_TYPE_LIMIT  = "limit"
+	ORDER_TYPE_MARKET = "market"
+
+	TIME_IN_FORCE_GOOD_TILL_CANCELLED = "GTC"
+	TIME_IN_FORCE_GOOD_TILL_TIME      = "GTT"
+	TIME_IN_FORCE_IMMEDIATE_OR_CANCEL = "IOC"
+	TIME_IN_FORCE_FILL_OR_KILL        = "FOK"
+
+	CANCEL_AFTER_MINUTE = "min"
+	CANCEL_AFTER_HOUR   = "hour"
+	CANCEL_AFTER_DAY    = "day"
+
+	ORDER_STATUS_PENDING  = "pending"
+	ORDER_STATUS_OPEN     = "open"
+	ORDER_STATUS_ACTIVE   = "active"
+	ORDER_STATUS_DONE     = "done"
+	ORDER_STATUS_REJECTED = "rejected"
+
+	// The most orders the exchange sends in one page
+	ORDERS_PAGE_LIMIT = 100
+)
+
+var (
+	errNotAuthenticated         = errors.New("Order entry requires an authenticated REST client.")
+	errMissingPriceOrSize       = errors.New("Limit orders require a price and a size.")
+	errMissingSizeOrFunds       = errors.New("Market orders require either a size or funds.")
+	errPostOnlyMarketOrder      = errors.New("Market orders cannot be post only.")
+	errCancelAfterWithoutGTT    = errors.New("Cancel after is only valid for good till time orders.")
+	errUnknownOrderType         = errors.New("Unknown order type.")
+	errPostOnlyTakerTimeInForce = errors.New("Post only orders cannot be immediate or cancel or fill or kill.")
+)
+
+// OrderRequest is the body sent to POST /orders. Price, Size and Funds are
+// decimal strings; ProductMetadata.NewLimitOrderRequest and
+// ProductMetadata.NewMarketOrderRequest build them from minor units.
+type OrderRequest struct {
+	ClientOID   string `json:"client_oid,omitempty"`
+	Type        string `json:"type"`
+	Side        string `json:"side"`
+	ProductID   string `json:"product_id"`
+	Price       string `json:"price,omitempty"`
+	Size        string `json:"size,omitempty"`
+	Funds       string `json:"funds,omitempty"`
+	TimeInForce string `json:"time_in_force,omitempty"`
+	CancelAfter string `json:"cancel_after,omitempty"`
+	PostOnly    bool   `json:"post_only,omitempty"`
+}
+
+// NewLimitOrderRequest builds a limit order with the price and size given in
+// the product's minor units.
+func (p *ProductMetadata) NewLimitOrderRequest(side string, price, size int64) (*OrderRequest, error) {
+	if err := p.validateOrder(price, size); err != nil {
+		return nil, err
+	}
+
+	return &OrderRequest{
+		Type:      ORDER_TYPE_LIMIT,
+		Side:      side,
+		ProductID: p.ID,
+		Price:     p.Scale.FormatPrice(price),
+		Size:      p.Scale.FormatSize(size),
+	}, nil
+}
+
+// NewMarketOrderRequest builds a market order with the size given in the
+// product's minor units.
+func (p *ProductMetadata) NewMarketOrderRequest(side string, size int64) (*OrderRequest, error) {
+	if err := p.validateOrder(0, size); err != nil {
+		return nil, err
+	}
+
+	return &OrderRequest{
+		Type:      ORDER_TYPE_MARKET,
+		Side:      side,
+		ProductID: p.ID,
+		Size:      p.Scale.FormatSize(size),
+	}, nil
+}
+
+func (r *OrderRequest) validate() error {
+	switch r.Type {
+	case ORDER_TYPE_LIMIT:
+		if r.Price == "" || r.Size == "" {
+			return errMissingPriceOrSize
+		}
+		if r.CancelAfter != "" && r.TimeInForce != TIME_IN_FORCE_GOOD_TILL_TIME {
+			return errCancelAfterWithoutGTT
+		}
+		if r.PostOnly && (r.TimeInForce == TIME_IN_FORCE_IMMEDIATE_OR_CANCEL || r.TimeInForce == TIME_IN_FORCE_FILL_OR_KILL) {
+			return errPostOnlyTakerTimeInForce
+		}
+	case ORDER_TYPE_MARKET:
+		if r.Size == "" && r.Funds == "" {
+			return errMissingSizeOrFunds
+		}
+		if r.PostOnly {
+			return errPostOnlyMarketOrder
+		}
+	default:
+		return errUnknownOrderType
+	}
+
+	return nil
+}
+
+// restOrder is an order as the REST API describes it.
+type restOrder struct {
+	ID         string    `json:"id"`
+	ClientOID  string    `json:"client_oid"`
+	Price      string    `json:"price"`
+	Size       string    `json:"size"`
+	FilledSize string    `json:"filled_size"`
+	ProductID  string    `json:"product_id"`
+	Side       string    `json:"side"`
+	Type       string    `json:"type"`
+	Status     string    `json:"status"`
+	DoneReason string    `json:"done_reason"`
+	CreatedAt  time.Time `json:"created_at"`
+	DoneAt     time.Time `json:"done_at"`
+}
+
+// toStatefulOrder converts the REST representation of one of our orders into
+// the same shape the realtime feed produces, so they can be correlated by ID
+// in an order book. Size is the quantity still resting.
+func (o *restOrder) toStatefulOrder(product *ProductMetadata) (*book.StatefulOrder, error) {
+	var err error
+	var price, size, filled int64
+
+	if o.Price != "" {
+		if price, err = product.Scale.ParsePrice(o.Price); err != nil {
+			return nil, err
+		}
+	}
+	if o.Size != "" {
+		if size, err = product.Scale.ParseSize(o.Size); err != nil {
+			return nil, err
+		}
+	}
+	if o.FilledSize != "" {
+		if filled, err = product.Scale.ParseSize(o.FilledSize); err != nil {
+			return nil, err
+		}
+	}
+
+	var state string
+	mutationTime := o.CreatedAt
+
+	switch o.Status {
+	case ORDER_STATUS_PENDING:
+		state = book.STATE_PENDING
+	case ORDER_STATUS_OPEN, ORDER_STATUS_ACTIVE:
+		state = book.STATE_OPEN
+	case ORDER_STATUS_DONE, ORDER_STATUS_REJECTED:
+		if o.DoneReason == REASON_FILLED {
+			state = book.STATE_FILLED
+		} else {
+			state = book.STATE_VOID
+		}
+		if !o.DoneAt.IsZero() {
+			mutationTime = o.DoneAt
+		}
+	default:
+		return nil, fmt.Errorf("Unknown order status %s for order %s", o.Status, o.ID)
+	}
+
+	remaining := size - filled
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return &book.StatefulOrder{
+		Order: book.Order{
+			ID:    book.OrderID(o.ID),
+			Price: price,
+			Side:  o.Side,
+		},
+		Size:               remaining,
+		State:              state,
+		LatestMutationTime: mutationTime,
+	}, nil
+}
+
+// statefulOrder converts one of our orders at the scale of its product.
+func (c *RESTClient) statefulOrder(o *restOrder) (*book.StatefulOrder, error) {
+	product, err := c.product(o.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.toStatefulOrder(product)
+}
+
+// PlaceOrder sends a new order to the exchange.
+func (c *RESTClient) PlaceOrder(req *OrderRequest) (*book.StatefulOrder, error) {
+	if c.Key == "" {
+		return nil, errNotAuthenticated
+	}
+
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
+	order := &restOrder{}
+
+	if err := c.request("POST", "/orders", nil, req, order); err != nil {
+		return nil, err
+	}
+
+	return c.statefulOrder(order)
+}
+
+// CancelOrder cancels a single open order.
+func (c *RESTClient) CancelOrder(id book.OrderID) error {
+	if c.Key == "" {
+		return errNotAuthenticated
+	}
+
+	return c.request("DELETE", "/orders/"+string(id), nil, nil, nil)
+}
+
+// CancelAllOrders cancels every open order, or only those for product when it
+// isn't empty, and returns the IDs of the cancelled orders.
+func (c *RESTClient) CancelAllOrders(product string) ([]book.OrderID, error) {
+	if c.Key == "" {
+		return nil, errNotAuthenticated
+	}
+
+	query := url.Values{}
+	if product != "" {
+		query.Set("product_id", product)
+	}
+
+	var ids []string
+
+	if err := c.request("DELETE", "/orders", query, nil, &ids); err != nil {
+		return nil, err
+	}
+
+	cancelled := make([]book.OrderID, 0, len(ids))
+	for _, id := range ids {
+		cancelled = append(cancelled, book.OrderID(id))
+	}
+
+	return cancelled, nil
+}
+
+// GetOpenOrders lists our pending and open orders, optionally only for one
+// product, following the exchange's cursor until the last page.
+func (c *RESTClient) GetOpenOrders(product string) ([]*book.StatefulOrder, error) {
+	if c.Key == "" {
+		return nil, errNotAuthenticated
+	}
+
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(ORDERS_PAGE_LIMIT))
+	query["status"] = []string{ORDER_STATUS_OPEN, ORDER_STATUS_PENDING, ORDER_STATUS_ACTIVE}
+	if product != "" {
+		query.Set("product_id", product)
+	}
+
+	var restOrders []restOrder
+
+	for {
+		var page []restOrder
+
+		after, err := c.requestPage("GET", "/orders", query, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		restOrders = append(restOrders, page...)
+
+		if after == "" || len(page) < ORDERS_PAGE_LIMIT {
+			break
+		}
+
+		query.Set("after", after)
+	}
+
+	orders := make([]*book.StatefulOrder, 0, len(restOrders))
+	for i := range restOrders {
+		order, err := c.statefulOrder(&restOrders[i])
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+// GetOrder fetches one of our orders by ID, whatever its status.
+func (c *RESTClient) GetOrder(id book.OrderID) (*book.StatefulOrder, error) {
+	if c.Key == "" {
+		return nil, errNotAuthenticated
+	}
+
+	order := &restOrder{}
+
+	if err := c.request("GET", "/orders/"+string(id), nil, nil, order); err != nil {
+		return nil, err
+	}
+
+	return c.statefulOrder(order)
+}
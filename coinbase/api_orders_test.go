@@ -0,0 +1,201 @@
+package coinbase
+
+import "encoding/base64"
+import "encoding/json"
+import "fmt"
+import "net/http"
+import "strings"
+import "testing"
+import "github.com/jacobgreenleaf/yeti/book"
+
+func newTestOrderClient(t *testing.T, handler http.HandlerFunc) (*RESTClient, func()) {
+	public, closeServer := newTestRESTServer(t, handler)
+
+	client, err := NewAuthenticatedRESTClient("key", base64.StdEncoding.EncodeToString([]byte("sekrit")), "pass")
+	if err != nil {
+		t.Fatalf("Unexpected error creating client: %s", err.Error())
+	}
+	client.BaseURL = public.BaseURL
+	client.Products.Add(DEFAULT_PRODUCT)
+
+	return client, closeServer
+}
+
+func TestPlacingLimitOrders(t *testing.T) {
+	client, closeServer := newTestOrderClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/orders" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		if req["price"] != "400.05" || req["size"] != "0.01000000" || req["side"] != "buy" || req["type"] != "limit" {
+			t.Errorf("Unexpected order request %v", req)
+		}
+		if req["client_oid"] != "our-order" || req["post_only"] != true || req["time_in_force"] != "GTT" || req["cancel_after"] != "hour" {
+			t.Errorf("Unexpected order options %v", req)
+		}
+
+		w.Write([]byte(`
+			{
+				"id": "d0c5340b-6d6c-49d9-b567-48c4bfca13d2",
+				"price": "400.05000000",
+				"size": "0.01000000",
+				"product_id": "BTC-USD",
+				"side": "buy",
+				"type": "limit",
+				"post_only": true,
+				"created_at": "2016-12-08T20:02:28.53864Z",
+				"filled_size": "0.00000000",
+				"status": "pending"
+			}
+		`))
+	})
+	defer closeServer()
+
+	req, err := DEFAULT_PRODUCT.NewLimitOrderRequest(book.SIDE_BUY, 40005, 1000000)
+	if err != nil {
+		t.Fatalf("Unexpected error building order: %s", err.Error())
+	}
+	req.ClientOID = "our-order"
+	req.PostOnly = true
+	req.TimeInForce = TIME_IN_FORCE_GOOD_TILL_TIME
+	req.CancelAfter = CANCEL_AFTER_HOUR
+
+	order, err := client.PlaceOrder(req)
+	if err != nil {
+		t.Fatalf("Unexpected error placing order: %s", err.Error())
+	}
+
+	if order.ID != "d0c5340b-6d6c-49d9-b567-48c4bfca13d2" {
+		t.Fatalf("Unexpected order id %s", order.ID)
+	}
+	if order.Price != 40005 || order.Size != 1000000 || order.Side != book.SIDE_BUY {
+		t.Fatalf("Unexpected order %s", order)
+	}
+	if order.State != book.STATE_PENDING {
+		t.Fatalf("Expected order to be pending, instead %s", order.State)
+	}
+}
+
+func TestValidatingOrders(t *testing.T) {
+	client, closeServer := newTestOrderClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unexpected request for an invalid order %s %s", r.Method, r.URL.Path)
+	})
+	defer closeServer()
+
+	req, _ := DEFAULT_PRODUCT.NewMarketOrderRequest(book.SIDE_SELL, 100)
+	req.PostOnly = true
+	if _, err := client.PlaceOrder(req); err != errPostOnlyMarketOrder {
+		t.Fatalf("Expected post only market order to be rejected, instead %v", err)
+	}
+
+	req, _ = DEFAULT_PRODUCT.NewLimitOrderRequest(book.SIDE_SELL, 100, 100)
+	req.CancelAfter = CANCEL_AFTER_DAY
+	if _, err := client.PlaceOrder(req); err != errCancelAfterWithoutGTT {
+		t.Fatalf("Expected cancel after without GTT to be rejected, instead %v", err)
+	}
+
+	req = &OrderRequest{Type: ORDER_TYPE_MARKET, Side: book.SIDE_SELL, ProductID: "BTC-USD"}
+	if _, err := client.PlaceOrder(req); err != errMissingSizeOrFunds {
+		t.Fatalf("Expected market order without size or funds to be rejected, instead %v", err)
+	}
+
+	req, _ = DEFAULT_PRODUCT.NewMarketOrderRequest(book.SIDE_SELL, 100)
+	if _, err := NewRESTClient().PlaceOrder(req); err != errNotAuthenticated {
+		t.Fatalf("Expected unauthenticated order to be rejected, instead %v", err)
+	}
+}
+
+func TestListingAndCancellingOrders(t *testing.T) {
+	client, closeServer := newTestOrderClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "GET /orders":
+			if len(r.URL.Query()["status"]) != 3 || r.URL.Query().Get("product_id") != "BTC-USD" {
+				t.Errorf("Unexpected query %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`[
+				{ "id": "aaaa", "product_id": "BTC-USD", "price": "0.10", "size": "1.00", "filled_size": "0.25", "side": "sell", "status": "open", "created_at": "2014-11-07T08:19:27.028459Z" }
+			]`))
+		case "GET /orders/bbbb":
+			w.Write([]byte(`{ "id": "bbbb", "product_id": "ETH-BTC", "price": "0.02901", "size": "1.00", "filled_size": "1.00", "side": "buy", "status": "done", "done_reason": "filled", "created_at": "2014-11-07T08:19:27.028459Z", "done_at": "2014-11-07T08:20:27.028459Z" }`))
+		case "GET /products/ETH-BTC":
+			w.Write([]byte(`{ "id": "ETH-BTC", "quote_increment": "0.00001", "base_increment": "0.00000001" }`))
+		case "DELETE /orders/aaaa":
+			w.Write([]byte(`OK`))
+		case "DELETE /orders":
+			w.Write([]byte(`[ "aaaa", "cccc" ]`))
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	defer closeServer()
+
+	orders, err := client.GetOpenOrders("BTC-USD")
+	if err != nil {
+		t.Fatalf("Unexpected error listing orders: %s", err.Error())
+	}
+	if len(orders) != 1 || orders[0].ID != "aaaa" || orders[0].State != book.STATE_OPEN {
+		t.Fatalf("Unexpected orders %v", orders)
+	}
+	if orders[0].Size != 75000000 {
+		t.Fatalf("Expected remaining size to be 75000000 satoshis, instead %d", orders[0].Size)
+	}
+
+	order, err := client.GetOrder("bbbb")
+	if err != nil {
+		t.Fatalf("Unexpected error getting order: %s", err.Error())
+	}
+	if order.State != book.STATE_FILLED || order.Size != 0 {
+		t.Fatalf("Expected order to be filled, instead %s", order)
+	}
+	if order.Price != 2901 {
+		t.Fatalf("Expected the ETH-BTC order to be priced at its own scale, instead %d", order.Price)
+	}
+
+	if err = client.CancelOrder("aaaa"); err != nil {
+		t.Fatalf("Unexpected error cancelling order: %s", err.Error())
+	}
+
+	ids, err := client.CancelAllOrders("")
+	if err != nil {
+		t.Fatalf("Unexpected error cancelling orders: %s", err.Error())
+	}
+	if len(ids) != 2 || ids[0] != "aaaa" || ids[1] != "cccc" {
+		t.Fatalf("Unexpected cancelled orders %v", ids)
+	}
+}
+
+func TestPagingThroughOpenOrders(t *testing.T) {
+	client, closeServer := newTestOrderClient(t, func(w http.ResponseWriter, r *http.Request) {
+		orders := make([]string, 0, ORDERS_PAGE_LIMIT)
+
+		switch r.URL.Query().Get("after") {
+		case "":
+			for i := 0; i < ORDERS_PAGE_LIMIT; i++ {
+				orders = append(orders, fmt.Sprintf(`{ "id": "first-%d", "product_id": "BTC-USD", "price": "1.00", "size": "1.00", "side": "buy", "status": "open" }`, i))
+			}
+			w.Header().Set("CB-AFTER", "cursor")
+		case "cursor":
+			orders = append(orders, `{ "id": "second-0", "product_id": "BTC-USD", "price": "1.00", "size": "1.00", "side": "buy", "status": "open" }`)
+		default:
+			t.Errorf("Unexpected cursor %s", r.URL.Query().Get("after"))
+		}
+
+		fmt.Fprintf(w, "[%s]", strings.Join(orders, ","))
+	})
+	defer closeServer()
+
+	orders, err := client.GetOpenOrders("")
+	if err != nil {
+		t.Fatalf("Unexpected error listing orders: %s", err.Error())
+	}
+
+	if len(orders) != ORDERS_PAGE_LIMIT+1 {
+		t.Fatalf("Expected %d orders across both pages, instead %d", ORDERS_PAGE_LIMIT+1, len(orders))
+	}
+	if orders[ORDERS_PAGE_LIMIT].ID != "second-0" {
+		t.Fatalf("Expected the second page last, instead %s", orders[ORDERS_PAGE_LIMIT])
+	}
+}
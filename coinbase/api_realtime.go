@@ -1,78 +1,466 @@
 package coinbase
 
+import "context"
+import "errors"
 import "github.com/gorilla/websocket"
 import "net/http"
-import "io"
 import "encoding/json"
+import "log"
+import "math/rand"
+import "sync"
+import "sync/atomic"
+import "time"
 
 const (
 	COINBASE_WEBSOCKET_URL = "wss://ws-feed.exchange.coinbase.com"
 )
 
+var errFeedLost = errors.New("Gave up reconnecting to the realtime feed.")
+
+const (
+	// FEED_STATE_CONNECTING is while (re)connecting, FEED_STATE_LIVE while
+	// messages are flowing, and FEED_STATE_STALE from losing the connection
+	// until we start connecting again. Anything built from the feed misses
+	// whatever is sent while it isn't live.
+	FEED_STATE_CONNECTING = "connecting"
+	FEED_STATE_LIVE       = "live"
+	FEED_STATE_STALE      = "stale"
+)
+
+const (
+	DEFAULT_FEED_READ_TIMEOUT  = 30 * time.Second
+	DEFAULT_FEED_WRITE_TIMEOUT = 10 * time.Second
+	DEFAULT_FEED_PING_INTERVAL = 10 * time.Second
+	DEFAULT_FEED_MIN_BACKOFF   = 500 * time.Millisecond
+	DEFAULT_FEED_MAX_BACKOFF   = time.Minute
+
+	// Ten minutes or so of failing to reconnect at the default backoffs
+	// before the feed is given up on
+	DEFAULT_FEED_MAX_RECONNECT_ATTEMPTS = 20
+)
+
+// OrderBookCommandFeed reads the realtime websocket feed and sends every
+// message it decodes on Feed as a batch of order book commands. Messages are
+// scaled using the metadata in Products. DecodeErrors counts the messages
+// that were skipped because they could not be decoded, and Dropped the
+// batches that didn't fit in Feed's buffer because nobody was reading it;
+// read them with atomic.LoadInt64.
+//
+// If Recorder is set, every frame is written to it byte for byte as it is
+// received, before it is decoded and whether or not it can be. RecordErrors counts the ones it failed to write.
+// SkipDecode stops messages being decoded at all, for when they're only being
+// recorded, in which case nothing is ever sent on Feed.
+//
+// The connection is pinged every PingInterval, and considered lost if
+// nothing, not even a pong, is read for ReadTimeout or a write takes longer
+// than WriteTimeout. A lost connection is redialed after a backoff that
+// starts at MinBackoff and doubles, with jitter, up to MaxBackoff for as long
+// as dialing fails, and then every product is subscribed to again.
+// Reconnects counts the times that happened; read it with atomic.LoadInt64.
+// After MaxReconnectAttempts failed attempts in a row, if it is positive, the
+// feed gives up: it sends an error saying so and is closed as if by Close.
+//
+// It is the FeedSource for the exchange itself; Batches is Feed.
 type OrderBookCommandFeed struct {
-	Feed   chan *CoinbaseOrderBookCommandBatch
-	socket *websocket.Conn
+	DecodeErrors int64
+	Dropped      int64
+	RecordErrors int64
+	Reconnects   int64
+	Feed         chan *CoinbaseOrderBookCommandBatch
+	Products     *ProductRegistry
+	Recorder     *FeedRecorder
+	SkipDecode   bool
+
+	URL          string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PingInterval time.Duration
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+
+	MaxReconnectAttempts int
+
+	errors chan error
+	states chan string
+	done   chan struct{}
+
+	// lock guards everything below, and writes to the socket
+	lock       sync.Mutex
+	socket     *websocket.Conn
+	subscribed []string
+	state      string
+	started    bool
+	closed     bool
+}
+
+// ConnectRealtimeFeed connects to the exchange. The feed is closed when ctx
+// is done, after which whatever was already read can still be drained from
+// Feed until it is closed too.
+func ConnectRealtimeFeed(ctx context.Context, bufLen int) (*OrderBookCommandFeed, error) {
+	return connectRealtimeFeed(ctx, COINBASE_WEBSOCKET_URL, bufLen)
+}
+
+func connectRealtimeFeed(ctx context.Context, url string, bufLen int) (*OrderBookCommandFeed, error) {
+	cmdFeed := &OrderBookCommandFeed{
+		Feed:                 make(chan *CoinbaseOrderBookCommandBatch, bufLen),
+		Products:             NewProductRegistry(),
+		URL:                  url,
+		ReadTimeout:          DEFAULT_FEED_READ_TIMEOUT,
+		WriteTimeout:         DEFAULT_FEED_WRITE_TIMEOUT,
+		PingInterval:         DEFAULT_FEED_PING_INTERVAL,
+		MinBackoff:           DEFAULT_FEED_MIN_BACKOFF,
+		MaxBackoff:           DEFAULT_FEED_MAX_BACKOFF,
+		MaxReconnectAttempts: DEFAULT_FEED_MAX_RECONNECT_ATTEMPTS,
+		errors:               make(chan error, bufLen),
+		states:               make(chan string, 16),
+		done:                 make(chan struct{}),
+		subscribed:           make([]string, 0),
+		state:                FEED_STATE_CONNECTING,
+	}
+
+	socket, err := cmdFeed.dial()
+
+	if err != nil {
+		return nil, err
+	}
+
+	cmdFeed.socket = socket
+
+	go cmdFeed.closeWhenDone(ctx)
+
+	return cmdFeed, nil
 }
 
-func ConnectRealtimeFeed(bufLen int) (*OrderBookCommandFeed, error) {
+// closeWhenDone closes the feed once ctx is done, unless it is closed first.
+func (feed *OrderBookCommandFeed) closeWhenDone(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		feed.Close()
+	case <-feed.done:
+	}
+}
+
+func (feed *OrderBookCommandFeed) dial() (*websocket.Conn, error) {
 	headers := http.Header{}
 	headers.Set("Origin", "http://www.jacobgreenleaf.com")
 	headers.Set("User-Agent", "Yeti <jacob@jacobgreenleaf.com>")
-	dialer := websocket.Dialer{}
-	socket, _, err := dialer.Dial(COINBASE_WEBSOCKET_URL, headers)
+	dialer := websocket.Dialer{HandshakeTimeout: feed.WriteTimeout}
+	socket, _, err := dialer.Dial(feed.URL, headers)
+	return socket, err
+}
 
-	if err != nil {
-		return nil, err
-	} else {
-		feed := make(chan *CoinbaseOrderBookCommandBatch, bufLen)
-		cmdFeed := &OrderBookCommandFeed{
-			Feed:   feed,
-			socket: socket,
+// ReadForever reads from the websocket, reconnecting whenever the connection
+// is lost, until the feed is closed, and then closes Feed. The first
+// Subscribe starts it.
+func (feed *OrderBookCommandFeed) ReadForever() {
+	defer close(feed.states)
+	defer close(feed.errors)
+	defer close(feed.Feed)
+
+	feed.setState(FEED_STATE_LIVE)
+
+	for {
+		feed.lock.Lock()
+		socket := feed.socket
+		feed.lock.Unlock()
+
+		err := feed.readConnection(socket)
+
+		if feed.isClosed() {
+			return
+		}
+
+		log.Printf("Lost connection to realtime feed: %s", err.Error())
+		feed.fail(err)
+		feed.setState(FEED_STATE_STALE)
+		socket.Close()
+
+		if !feed.reconnect() {
+			return
 		}
-		return cmdFeed, nil
 	}
 }
 
-func (feed *OrderBookCommandFeed) ReadForever() {
+// readConnection reads from one connection until it fails, keeping it alive
+// with pings in the meantime.
+func (feed *OrderBookCommandFeed) readConnection(socket *websocket.Conn) error {
+	stopPinging := make(chan struct{})
+	defer close(stopPinging)
+
+	socket.SetPongHandler(func(string) error {
+		return socket.SetReadDeadline(time.Now().Add(feed.ReadTimeout))
+	})
+
+	go feed.ping(socket, stopPinging)
+
 	for {
-		var reader io.Reader
-		_, reader, err := feed.socket.NextReader()
+		socket.SetReadDeadline(time.Now().Add(feed.ReadTimeout))
+
+		// Recorded as received, even if it doesn't decode
+		_, frame, err := socket.ReadMessage()
 
 		if err != nil {
-			// log.Printf("Error getting next reader from websocket: %s", err.Error())
-			continue
+			return err
 		}
 
-		decoder := json.NewDecoder(reader)
+		feed.record(time.Now(), frame)
+
+		if !feed.SkipDecode {
+			feed.decode(frame)
+		}
+	}
+}
 
-		for {
-			var rawOrder interface{}
-			decoder.Decode(&rawOrder)
+// ping pings socket every PingInterval until stop is closed. A ping that
+// can't be sent closes the socket, so that the reader notices.
+func (feed *OrderBookCommandFeed) ping(socket *websocket.Conn, stop chan struct{}) {
+	ticker := time.NewTicker(feed.PingInterval)
+	defer ticker.Stop()
 
-			if rawOrder == nil {
-				break
+	for {
+		select {
+		case <-ticker.C:
+			if err := socket.WriteControl(websocket.PingMessage, nil, time.Now().Add(feed.WriteTimeout)); err != nil {
+				socket.Close()
+				return
 			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// reconnect dials until it succeeds and subscribes to everything again,
+// returning false if the feed was closed first or it ran out of attempts.
+func (feed *OrderBookCommandFeed) reconnect() bool {
+	attempt := 0
 
-			rawBytes, _ := json.Marshal(rawOrder)
+	for ; feed.MaxReconnectAttempts <= 0 || attempt < feed.MaxReconnectAttempts; attempt++ {
+		select {
+		case <-time.After(feed.backoff(attempt)):
+		case <-feed.done:
+			return false
+		}
 
-			batch := DecodeRealtimeEvent(rawBytes)
+		feed.setState(FEED_STATE_CONNECTING)
 
-			if batch != nil && len(batch.Commands) > 0 {
-				feed.Feed <- batch
-			}
+		socket, err := feed.dial()
+
+		if err != nil {
+			log.Printf("Error reconnecting to realtime feed: %s", err.Error())
+			feed.fail(err)
+			continue
+		}
+
+		feed.lock.Lock()
+
+		if feed.closed {
+			feed.lock.Unlock()
+			socket.Close()
+			return false
+		}
+
+		feed.socket = socket
+		err = feed.writeSubscription(feed.subscribed)
+
+		feed.lock.Unlock()
+
+		if err != nil {
+			log.Printf("Error resubscribing to realtime feed: %s", err.Error())
+			feed.fail(err)
+			socket.Close()
+			continue
+		}
+
+		atomic.AddInt64(&feed.Reconnects, 1)
+		feed.setState(FEED_STATE_LIVE)
+
+		return true
+	}
+
+	log.Printf("Giving up on the realtime feed after %d attempts to reconnect", attempt)
+	feed.fail(errFeedLost)
+
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+
+	if !feed.closed {
+		feed.closed = true
+		close(feed.done)
+	}
+
+	return false
+}
+
+// backoff is how long to wait before the attempt'th redial: MinBackoff
+// doubled for every attempt so far, capped at MaxBackoff, and then cut by
+// up to half at random so that clients don't all retry at once.
+func (feed *OrderBookCommandFeed) backoff(attempt int) time.Duration {
+	d := feed.MinBackoff
+	for i := 0; i < attempt && d < feed.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > feed.MaxBackoff {
+		d = feed.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return d - time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+func (feed *OrderBookCommandFeed) record(received time.Time, rawMsg []byte) {
+	if feed.Recorder == nil {
+		return
+	}
+
+	if err := feed.Recorder.Record(received, rawMsg); err != nil {
+		atomic.AddInt64(&feed.RecordErrors, 1)
+		log.Printf("Error recording message: %s", err.Error())
+	}
+}
+
+// decode pushes the batch for a single raw message onto the feed, counting
+// and skipping anything that can't be decoded.
+func (feed *OrderBookCommandFeed) decode(rawMsg []byte) {
+	batch, err := feed.Products.DecodeRealtimeEvent(rawMsg)
+
+	if err != nil {
+		switch err.(type) {
+		case *RealtimeDecodeError:
+			atomic.AddInt64(&feed.DecodeErrors, 1)
+		case *RealtimeError:
+			feed.fail(err)
 		}
+		log.Printf("Skipping message: %s", err.Error())
+		return
+	}
+
+	if batch == nil {
+		return
+	}
+
+	// Batches without commands are still forwarded so the sequence has no
+	// holes. One that doesn't fit leaves a hole that the book resyncs over,
+	// rather than holding up reading and answering pings
+	select {
+	case feed.Feed <- batch:
+	default:
+		atomic.AddInt64(&feed.Dropped, 1)
 	}
 }
 
-func (feed *OrderBookCommandFeed) Subscribe(product string) {
+// fail reports an error on Errors, dropping it if nobody is keeping up.
+func (feed *OrderBookCommandFeed) fail(err error) {
+	select {
+	case feed.errors <- err:
+	default:
+	}
+}
+
+// setState reports a change of state on States, dropping it if nobody is
+// keeping up; State always has the latest.
+func (feed *OrderBookCommandFeed) setState(state string) {
+	feed.lock.Lock()
+	feed.state = state
+	feed.lock.Unlock()
+
+	select {
+	case feed.states <- state:
+	default:
+	}
+}
+
+func (feed *OrderBookCommandFeed) State() string {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	return feed.state
+}
+
+func (feed *OrderBookCommandFeed) isClosed() bool {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	return feed.closed
+}
+
+// writeSubscription asks for the full channel of products in one message.
+// The caller must hold the lock.
+func (feed *OrderBookCommandFeed) writeSubscription(products []string) error {
 	type msg struct {
-		Type      string `json:"type"`
-		ProductID string `json:"product_id"`
+		Type       string   `json:"type"`
+		ProductIDs []string `json:"product_ids"`
 	}
 
-	subscribeMsg := msg{Type: "subscribe", ProductID: product}
+	subscribeMsg := msg{Type: "subscribe", ProductIDs: products}
 
 	subscribeMsgBytes, _ := json.Marshal(subscribeMsg)
 
-	feed.socket.WriteMessage(websocket.TextMessage, subscribeMsgBytes)
+	feed.socket.SetWriteDeadline(time.Now().Add(feed.WriteTimeout))
+
+	return feed.socket.WriteMessage(websocket.TextMessage, subscribeMsgBytes)
+}
+
+// Subscribe asks for the full channel of every product in one message, and
+// starts reading if we weren't already. Products stay subscribed to across
+// reconnects.
+func (feed *OrderBookCommandFeed) Subscribe(products ...*ProductMetadata) error {
+	ids := make([]string, 0, len(products))
+
+	for _, product := range products {
+		feed.Products.Add(product)
+		ids = append(ids, product.ID)
+	}
+
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+
+	feed.subscribed = append(feed.subscribed, ids...)
+
+	if err := feed.writeSubscription(ids); err != nil {
+		return err
+	}
+
+	if !feed.started && !feed.closed {
+		feed.started = true
+		go feed.ReadForever()
+	}
+
+	return nil
+}
+
+func (feed *OrderBookCommandFeed) Batches() <-chan *CoinbaseOrderBookCommandBatch {
+	return feed.Feed
+}
+
+func (feed *OrderBookCommandFeed) Errors() <-chan error {
+	return feed.errors
+}
+
+// States gets every change of State, so that whatever is built from the feed
+// knows when it has missed something. It is closed along with Feed.
+func (feed *OrderBookCommandFeed) States() <-chan string {
+	return feed.states
+}
+
+// Close disconnects from the exchange. Feed is closed once ReadForever
+// notices, or straight away if it never started.
+func (feed *OrderBookCommandFeed) Close() error {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+
+	if feed.closed {
+		return nil
+	}
+
+	feed.closed = true
+	close(feed.done)
+
+	if !feed.started {
+		close(feed.Feed)
+		close(feed.errors)
+		close(feed.states)
+	}
+
+	return feed.socket.Close()
 }
@@ -0,0 +1,283 @@
+package coinbase
+
+import "context"
+import "fmt"
+import "github.com/gorilla/websocket"
+import "net/http"
+import "net/http/httptest"
+import "strings"
+import "sync"
+import "sync/atomic"
+import "testing"
+import "time"
+
+// newTestWebsocketServer runs handle for every connection, numbered from 1,
+// after reading the subscription it starts with.
+func newTestWebsocketServer(t *testing.T, handle func(n int, socket *websocket.Conn)) (string, *[][]string, func()) {
+	var lock sync.Mutex
+	subscriptions := make([][]string, 0)
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Unexpected error upgrading connection: %s", err.Error())
+			return
+		}
+		defer socket.Close()
+
+		var msg struct {
+			ProductIDs []string `json:"product_ids"`
+		}
+		if err := socket.ReadJSON(&msg); err != nil {
+			return
+		}
+
+		lock.Lock()
+		subscriptions = append(subscriptions, msg.ProductIDs)
+		n := len(subscriptions)
+		lock.Unlock()
+
+		handle(n, socket)
+	}))
+
+	return "ws" + strings.TrimPrefix(server.URL, "http"), &subscriptions, server.Close
+}
+
+func heartbeat(seq int) []byte {
+	return []byte(fmt.Sprintf(`{"type":"heartbeat","product_id":"BTC-USD","sequence":%d}`, seq))
+}
+
+// drain reads from socket until it fails, answering pings as it goes.
+func drain(socket *websocket.Conn) {
+	for {
+		if _, _, err := socket.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func connectTestFeed(t *testing.T, url string) *OrderBookCommandFeed {
+	feed, err := connectRealtimeFeed(context.Background(), url, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error connecting: %s", err.Error())
+	}
+
+	feed.MinBackoff = time.Millisecond
+	feed.MaxBackoff = 10 * time.Millisecond
+
+	return feed
+}
+
+func nextBatch(t *testing.T, feed *OrderBookCommandFeed) *CoinbaseOrderBookCommandBatch {
+	select {
+	case batch := <-feed.Batches():
+		return batch
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a batch")
+	}
+	return nil
+}
+
+func collectStates(feed *OrderBookCommandFeed) []string {
+	states := make([]string, 0)
+	for {
+		select {
+		case state := <-feed.States():
+			states = append(states, state)
+		default:
+			return states
+		}
+	}
+}
+
+func TestReconnectingToTheRealtimeFeed(t *testing.T) {
+	url, subscriptions, closeServer := newTestWebsocketServer(t, func(n int, socket *websocket.Conn) {
+		socket.WriteMessage(websocket.TextMessage, heartbeat(n))
+
+		// Hang up on the first connection
+		if n > 1 {
+			drain(socket)
+		}
+	})
+	defer closeServer()
+
+	feed := connectTestFeed(t, url)
+
+	if err := feed.Subscribe(DEFAULT_PRODUCT); err != nil {
+		t.Fatalf("Unexpected error subscribing: %s", err.Error())
+	}
+
+	if batch := nextBatch(t, feed); batch.Sequence != 1 {
+		t.Fatalf("Expected a batch from the first connection, instead %d", batch.Sequence)
+	}
+	if batch := nextBatch(t, feed); batch.Sequence != 2 {
+		t.Fatalf("Expected a batch from the second connection, instead %d", batch.Sequence)
+	}
+
+	if feed.Reconnects != 1 || feed.State() != FEED_STATE_LIVE {
+		t.Fatalf("Expected to be live after reconnecting once, instead %s after %d", feed.State(), feed.Reconnects)
+	}
+
+	expected := fmt.Sprint([]string{FEED_STATE_LIVE, FEED_STATE_STALE, FEED_STATE_CONNECTING, FEED_STATE_LIVE})
+	if states := fmt.Sprint(collectStates(feed)); states != expected {
+		t.Fatalf("Expected states %s, instead %s", expected, states)
+	}
+
+	if fmt.Sprint(*subscriptions) != "[[BTC-USD] [BTC-USD]]" {
+		t.Fatalf("Expected to subscribe again after reconnecting, instead %v", *subscriptions)
+	}
+
+	feed.Close()
+
+	for range feed.Batches() {
+	}
+}
+
+func TestGivingUpOnTheRealtimeFeed(t *testing.T) {
+	url, _, closeServer := newTestWebsocketServer(t, func(n int, socket *websocket.Conn) {
+		socket.WriteMessage(websocket.TextMessage, heartbeat(n))
+	})
+
+	feed := connectTestFeed(t, url)
+	feed.MaxReconnectAttempts = 3
+
+	if err := feed.Subscribe(DEFAULT_PRODUCT); err != nil {
+		t.Fatalf("Unexpected error subscribing: %s", err.Error())
+	}
+
+	nextBatch(t, feed)
+
+	// Nothing to reconnect to
+	closeServer()
+
+	select {
+	case _, ok := <-feed.Batches():
+		if ok {
+			t.Fatal("Expected no more batches")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the feed to be closed once it gave up")
+	}
+
+	lost := false
+	for err := range feed.Errors() {
+		lost = lost || err == errFeedLost
+	}
+	if !lost || feed.Reconnects != 0 {
+		t.Fatalf("Expected to be told the feed was lost without reconnecting, instead lost=%t after %d reconnects", lost, feed.Reconnects)
+	}
+
+	if err := feed.Close(); err != nil {
+		t.Fatalf("Expected closing a lost feed to do nothing, instead %s", err.Error())
+	}
+}
+
+func TestDroppingBatchesNobodyReads(t *testing.T) {
+	url, _, closeServer := newTestWebsocketServer(t, func(n int, socket *websocket.Conn) {
+		for seq := 1; seq <= 3; seq++ {
+			socket.WriteMessage(websocket.TextMessage, heartbeat(seq))
+		}
+		drain(socket)
+	})
+	defer closeServer()
+
+	feed, err := connectRealtimeFeed(context.Background(), url, 1)
+	if err != nil {
+		t.Fatalf("Unexpected error connecting: %s", err.Error())
+	}
+	feed.Subscribe(DEFAULT_PRODUCT)
+
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt64(&feed.Dropped) < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the reader to drop what didn't fit rather than wait, instead %d dropped", atomic.LoadInt64(&feed.Dropped))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if batch := nextBatch(t, feed); batch.Sequence != 1 {
+		t.Fatalf("Expected the first batch to have been kept, instead %d", batch.Sequence)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		feed.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Close not to wait on a full Feed")
+	}
+}
+
+func TestTimingOutSilentConnections(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	url, _, closeServer := newTestWebsocketServer(t, func(n int, socket *websocket.Conn) {
+		// The first connection neither sends anything nor answers pings
+		if n == 1 {
+			<-release
+			return
+		}
+		socket.WriteMessage(websocket.TextMessage, heartbeat(n))
+		drain(socket)
+	})
+	defer closeServer()
+
+	feed := connectTestFeed(t, url)
+	feed.ReadTimeout = 50 * time.Millisecond
+	feed.PingInterval = 10 * time.Millisecond
+
+	feed.Subscribe(DEFAULT_PRODUCT)
+
+	if batch := nextBatch(t, feed); batch.Sequence != 2 {
+		t.Fatalf("Expected a batch from the second connection, instead %d", batch.Sequence)
+	}
+
+	feed.Close()
+}
+
+func TestBackingOff(t *testing.T) {
+	feed := &OrderBookCommandFeed{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	expected := map[int]time.Duration{
+		0:  100 * time.Millisecond,
+		3:  800 * time.Millisecond,
+		10: time.Second,
+	}
+
+	for attempt, max := range expected {
+		for i := 0; i < 100; i++ {
+			if d := feed.backoff(attempt); d < max/2 || d > max {
+				t.Fatalf("Expected attempt %d to back off between %s and %s, instead %s", attempt, max/2, max, d)
+			}
+		}
+	}
+}
+
+func TestClosingTheRealtimeFeed(t *testing.T) {
+	url, _, closeServer := newTestWebsocketServer(t, func(n int, socket *websocket.Conn) {
+		drain(socket)
+	})
+	defer closeServer()
+
+	feed := connectTestFeed(t, url)
+	feed.Subscribe(DEFAULT_PRODUCT)
+	feed.Close()
+
+	select {
+	case _, ok := <-feed.Batches():
+		if ok {
+			t.Fatal("Expected no batches once closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected batches to be closed once the feed was")
+	}
+
+	if feed.Reconnects != 0 {
+		t.Fatalf("Expected closing not to reconnect, instead %d reconnects", feed.Reconnects)
+	}
+}
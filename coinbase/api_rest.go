@@ -1,5 +1,439 @@
 package coinbase
 
+import "bytes"
+import "crypto/hmac"
+import "crypto/sha256"
+import "encoding/base64"
+import "encoding/json"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "net/http"
+import "net/url"
+import "strconv"
+import "time"
+
 const (
 	COINBASE_REST_URL = "https://api.exchange.coinbase.com"
 )
+
+// RESTError is returned for any non-2xx response from the REST API. Message
+// is whatever the exchange put in the "message" field of the response body.
+type RESTError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *RESTError) Error() string {
+	return fmt.Sprintf("Coinbase REST API error %d: %s", e.StatusCode, e.Message)
+}
+
+// RESTClient talks to the Coinbase Exchange REST API. Requests are signed when
+// Key is set; the public endpoints work without credentials. Prices and sizes
+// are scaled using the metadata in Products.
+//
+// A zero BaseURL or nil HTTPClient mean COINBASE_REST_URL and
+// http.DefaultClient, so a RESTClient literal works as well as one from
+// NewRESTClient. Without Products, GetProduct doesn't remember anything.
+type RESTClient struct {
+	BaseURL    string
+	Key        string
+	Secret     []byte
+	Passphrase string
+	HTTPClient *http.Client
+	Products   *ProductRegistry
+
+	now func() time.Time
+}
+
+// NewRESTClient returns an unauthenticated client for the public endpoints.
+func NewRESTClient() *RESTClient {
+	return &RESTClient{
+		BaseURL:    COINBASE_REST_URL,
+		HTTPClient: http.DefaultClient,
+		Products:   NewProductRegistry(),
+		now:        time.Now,
+	}
+}
+
+// NewAuthenticatedRESTClient returns a client that signs every request. The
+// secret is the base64 encoded string handed out by the exchange.
+func NewAuthenticatedRESTClient(key, secret, passphrase string) (*RESTClient, error) {
+	decodedSecret, err := base64.StdEncoding.DecodeString(secret)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding API secret: %s", err.Error())
+	}
+
+	client := NewRESTClient()
+	client.Key = key
+	client.Secret = decodedSecret
+	client.Passphrase = passphrase
+
+	return client, nil
+}
+
+// sign computes the CB-ACCESS-SIGN header for a request.
+func (c *RESTClient) sign(timestamp, method, requestPath string, body []byte) string {
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write([]byte(timestamp + method + requestPath))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *RESTClient) baseURL() string {
+	if c.BaseURL == "" {
+		return COINBASE_REST_URL
+	}
+	return c.BaseURL
+}
+
+func (c *RESTClient) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+func (c *RESTClient) clock() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
+// requestRaw performs a request and returns the raw response body along with
+// the response headers. Any non-2xx response is decoded into a *RESTError.
+func (c *RESTClient) requestRaw(method, path string, query url.Values, body interface{}) ([]byte, http.Header, error) {
+	requestPath := path
+	if len(query) > 0 {
+		requestPath += "?" + query.Encode()
+	}
+
+	var bodyBytes []byte
+	var bodyReader io.Reader
+
+	if body != nil {
+		var err error
+		bodyBytes, err = json.Marshal(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		bodyReader = bytes.NewReader(bodyBytes)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL()+requestPath, bodyReader)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "Yeti <jacob@jacobgreenleaf.com>")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.Key != "" {
+		timestamp := strconv.FormatInt(c.clock().Unix(), 10)
+		req.Header.Set("CB-ACCESS-KEY", c.Key)
+		req.Header.Set("CB-ACCESS-SIGN", c.sign(timestamp, method, requestPath, bodyBytes))
+		req.Header.Set("CB-ACCESS-TIMESTAMP", timestamp)
+		req.Header.Set("CB-ACCESS-PASSPHRASE", c.Passphrase)
+	}
+
+	resp, err := c.httpClient().Do(req)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var msg struct {
+			Message string `json:"message"`
+		}
+
+		if json.Unmarshal(respBody, &msg) != nil || msg.Message == "" {
+			msg.Message = resp.Status
+		}
+
+		return nil, nil, &RESTError{StatusCode: resp.StatusCode, Message: msg.Message}
+	}
+
+	return respBody, resp.Header, nil
+}
+
+// request performs a request and decodes the JSON response into out.
+func (c *RESTClient) request(method, path string, query url.Values, body interface{}, out interface{}) error {
+	_, err := c.requestPage(method, path, query, body, out)
+	return err
+}
+
+// requestPage performs a request like request does, and also returns the
+// cursor for the page after this one, which is empty on the last page.
+func (c *RESTClient) requestPage(method, path string, query url.Values, body interface{}, out interface{}) (string, error) {
+	respBody, header, err := c.requestRaw(method, path, query, body)
+
+	if err != nil {
+		return "", err
+	}
+
+	if out == nil {
+		return "", nil
+	}
+
+	if err = json.Unmarshal(respBody, out); err != nil {
+		return "", fmt.Errorf("Error decoding response from %s %s: %s", method, path, err.Error())
+	}
+
+	return header.Get("CB-AFTER"), nil
+}
+
+type Product struct {
+	ID             string `json:"id"`
+	BaseCurrency   string `json:"base_currency"`
+	QuoteCurrency  string `json:"quote_currency"`
+	BaseMinSize    string `json:"base_min_size"`
+	BaseMaxSize    string `json:"base_max_size"`
+	BaseIncrement  string `json:"base_increment"`
+	QuoteIncrement string `json:"quote_increment"`
+}
+
+func (c *RESTClient) GetProducts() ([]Product, error) {
+	var products []Product
+	err := c.request("GET", "/products", nil, nil, &products)
+	return products, err
+}
+
+// GetProduct fetches a single product and registers its metadata with the
+// client so that later responses for it are scaled correctly.
+func (c *RESTClient) GetProduct(id string) (*ProductMetadata, error) {
+	raw, err := c.GetRawProduct(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := DecodeRESTProduct(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Products != nil {
+		c.Products.Add(meta)
+	}
+
+	return meta, nil
+}
+
+// GetRawProduct fetches a single product exactly as the exchange describes
+// it, for recording. DecodeRESTProduct decodes it.
+func (c *RESTClient) GetRawProduct(id string) ([]byte, error) {
+	body, _, err := c.requestRaw("GET", "/products/"+id, nil, nil)
+	return body, err
+}
+
+// DecodeRESTProduct decodes a product as GET /products/<id> describes it.
+func DecodeRESTProduct(raw []byte) (*ProductMetadata, error) {
+	product := &Product{}
+
+	if err := json.Unmarshal(raw, product); err != nil {
+		return nil, fmt.Errorf("Error decoding product: %s", err.Error())
+	}
+
+	return product.Metadata()
+}
+
+// product returns the metadata for a product, fetching it if we don't know
+// about it yet.
+func (c *RESTClient) product(id string) (*ProductMetadata, error) {
+	if meta, ok := c.Products.Get(id); ok {
+		return meta, nil
+	}
+
+	return c.GetProduct(id)
+}
+
+// AggregatedOrderBookLevel is one row of a level 1 or level 2 order book.
+type AggregatedOrderBookLevel struct {
+	Price     string
+	Size      string
+	NumOrders int64
+}
+
+func (l *AggregatedOrderBookLevel) UnmarshalJSON(data []byte) error {
+	var row []json.Number
+
+	if err := json.Unmarshal(data, &row); err != nil {
+		return err
+	}
+
+	if len(row) != 3 {
+		return fmt.Errorf("Expected order book level to have 3 fields, instead %d", len(row))
+	}
+
+	numOrders, err := row[2].Int64()
+	if err != nil {
+		return fmt.Errorf("Error parsing number of orders %s: %s", row[2], err.Error())
+	}
+
+	l.Price = row[0].String()
+	l.Size = row[1].String()
+	l.NumOrders = numOrders
+
+	return nil
+}
+
+type AggregatedOrderBook struct {
+	Sequence int64                      `json:"sequence"`
+	Bids     []AggregatedOrderBookLevel `json:"bids"`
+	Asks     []AggregatedOrderBookLevel `json:"asks"`
+}
+
+// GetAggregatedOrderBook fetches the best bid and ask (level 1) or the top 50
+// price levels (level 2) for a product. Use GetOrderBook for level 3.
+func (c *RESTClient) GetAggregatedOrderBook(product string, level int) (*AggregatedOrderBook, error) {
+	if level != 1 && level != 2 {
+		return nil, fmt.Errorf("Aggregated order book level must be 1 or 2, not %d", level)
+	}
+
+	query := url.Values{}
+	query.Set("level", strconv.Itoa(level))
+
+	orderBook := &AggregatedOrderBook{}
+	err := c.request("GET", "/products/"+product+"/book", query, nil, orderBook)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return orderBook, nil
+}
+
+// GetOrderBook fetches the full (level 3) order book for a product, scaled
+// using its metadata, which is fetched first if we don't have it yet.
+func (c *RESTClient) GetOrderBook(product string) (int64, *CoinbaseOrderBookCommandBatch, error) {
+	meta, err := c.product(product)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	body, err := c.GetRawOrderBook(product)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return meta.DecodeRESTOrderBook(body)
+}
+
+// GetRawOrderBook fetches the full (level 3) order book for a product exactly
+// as the exchange sends it, for recording.
+func (c *RESTClient) GetRawOrderBook(product string) ([]byte, error) {
+	query := url.Values{}
+	query.Set("level", "3")
+
+	body, _, err := c.requestRaw("GET", "/products/"+product+"/book", query, nil)
+	return body, err
+}
+
+type Ticker struct {
+	TradeID int64     `json:"trade_id"`
+	Price   string    `json:"price"`
+	Size    string    `json:"size"`
+	Bid     string    `json:"bid"`
+	Ask     string    `json:"ask"`
+	Volume  string    `json:"volume"`
+	Time    time.Time `json:"time"`
+}
+
+func (c *RESTClient) GetTicker(product string) (*Ticker, error) {
+	ticker := &Ticker{}
+	err := c.request("GET", "/products/"+product+"/ticker", nil, nil, ticker)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ticker, nil
+}
+
+type Trade struct {
+	TradeID int64     `json:"trade_id"`
+	Price   string    `json:"price"`
+	Size    string    `json:"size"`
+	Side    string    `json:"side"`
+	Time    time.Time `json:"time"`
+}
+
+// GetTrades fetches the latest trades for a product.
+func (c *RESTClient) GetTrades(product string) ([]Trade, error) {
+	var trades []Trade
+	err := c.request("GET", "/products/"+product+"/trades", nil, nil, &trades)
+	return trades, err
+}
+
+type Candle struct {
+	Time   time.Time
+	Low    float64
+	High   float64
+	Open   float64
+	Close  float64
+	Volume float64
+}
+
+// The exchange sends candles as [ time, low, high, open, close, volume ]
+func (c *Candle) UnmarshalJSON(data []byte) error {
+	var row []float64
+
+	if err := json.Unmarshal(data, &row); err != nil {
+		return err
+	}
+
+	if len(row) != 6 {
+		return fmt.Errorf("Expected candle to have 6 fields, instead %d", len(row))
+	}
+
+	c.Time = time.Unix(int64(row[0]), 0).UTC()
+	c.Low = row[1]
+	c.High = row[2]
+	c.Open = row[3]
+	c.Close = row[4]
+	c.Volume = row[5]
+
+	return nil
+}
+
+// GetCandles fetches historic rates for a product between start and end,
+// bucketed by granularity.
+func (c *RESTClient) GetCandles(product string, start, end time.Time, granularity time.Duration) ([]Candle, error) {
+	query := url.Values{}
+	query.Set("start", start.UTC().Format(time.RFC3339))
+	query.Set("end", end.UTC().Format(time.RFC3339))
+	query.Set("granularity", strconv.FormatInt(int64(granularity/time.Second), 10))
+
+	var candles []Candle
+	err := c.request("GET", "/products/"+product+"/candles", query, nil, &candles)
+	return candles, err
+}
+
+// GetTime fetches the exchange's clock.
+func (c *RESTClient) GetTime() (time.Time, error) {
+	var msg struct {
+		ISO time.Time `json:"iso"`
+	}
+
+	err := c.request("GET", "/time", nil, nil, &msg)
+
+	return msg.ISO, err
+}
@@ -1,6 +1,13 @@
 package coinbase
 
+import "crypto/hmac"
+import "crypto/sha256"
+import "encoding/base64"
+import "net/http"
+import "net/http/httptest"
 import "testing"
+import "time"
+import "github.com/jacobgreenleaf/yeti/book"
 
 func TestDecodingRESTOrderBook(t *testing.T) {
 	response := []byte(`
@@ -36,7 +43,210 @@ func TestDecodingRESTOrderBook(t *testing.T) {
 		t.Fatalf("Expected number of commands to be 5, instead %d", len(batch.Commands))
 	}
 
-	if batch.Sequence != int(3) {
+	if batch.Sequence != int64(3) {
 		t.Fatalf("Expected sequence number to be 3, instead %d", batch.Sequence)
 	}
 }
+
+func newTestRESTServer(t *testing.T, handler http.HandlerFunc) (*RESTClient, func()) {
+	server := httptest.NewServer(handler)
+
+	client := NewRESTClient()
+	client.BaseURL = server.URL
+
+	return client, server.Close
+}
+
+func TestSigningRESTRequests(t *testing.T) {
+	secret := base64.StdEncoding.EncodeToString([]byte("sekrit"))
+
+	client, closeServer := newTestRESTServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("CB-ACCESS-KEY") != "key" {
+			t.Errorf("Expected access key to be key, instead %s", r.Header.Get("CB-ACCESS-KEY"))
+		}
+		if r.Header.Get("CB-ACCESS-PASSPHRASE") != "pass" {
+			t.Errorf("Expected passphrase to be pass, instead %s", r.Header.Get("CB-ACCESS-PASSPHRASE"))
+		}
+		if r.Header.Get("CB-ACCESS-TIMESTAMP") != "1420070400" {
+			t.Errorf("Expected timestamp to be 1420070400, instead %s", r.Header.Get("CB-ACCESS-TIMESTAMP"))
+		}
+
+		mac := hmac.New(sha256.New, []byte("sekrit"))
+		mac.Write([]byte("1420070400GET/products/BTC-USD/book?level=2"))
+		expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+		if r.Header.Get("CB-ACCESS-SIGN") != expected {
+			t.Errorf("Expected signature to be %s, instead %s", expected, r.Header.Get("CB-ACCESS-SIGN"))
+		}
+
+		w.Write([]byte(`{ "sequence": 1, "bids": [], "asks": [] }`))
+	})
+	defer closeServer()
+
+	authed, err := NewAuthenticatedRESTClient("key", secret, "pass")
+	if err != nil {
+		t.Fatalf("Unexpected error creating client: %s", err.Error())
+	}
+	authed.BaseURL = client.BaseURL
+	authed.now = func() time.Time { return time.Unix(1420070400, 0) }
+
+	if _, err = authed.GetAggregatedOrderBook("BTC-USD", 2); err != nil {
+		t.Fatalf("Unexpected error fetching order book: %s", err.Error())
+	}
+
+	if _, err = NewAuthenticatedRESTClient("key", "not base64!", "pass"); err == nil {
+		t.Fatal("Expected an invalid secret to be rejected")
+	}
+}
+
+func TestUsingARESTClientLiteral(t *testing.T) {
+	client, closeServer := newTestRESTServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("CB-ACCESS-SIGN") == "" {
+			t.Errorf("Expected the request to be signed")
+		}
+
+		w.Write([]byte(`{ "id": "BTC-USD", "quote_increment": "0.01" }`))
+	})
+	defer closeServer()
+
+	literal := &RESTClient{BaseURL: client.BaseURL, Key: "key", Secret: []byte("sekrit"), Passphrase: "pass"}
+
+	meta, err := literal.GetProduct("BTC-USD")
+	if err != nil {
+		t.Fatalf("Unexpected error fetching product: %s", err.Error())
+	}
+	if meta.ID != "BTC-USD" {
+		t.Fatalf("Expected BTC-USD, instead %s", meta)
+	}
+}
+
+func TestDecodingRESTErrors(t *testing.T) {
+	client, closeServer := newTestRESTServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{ "message": "NotFound" }`))
+	})
+	defer closeServer()
+
+	_, err := client.GetTicker("DOGE-USD")
+
+	restErr, ok := err.(*RESTError)
+	if !ok {
+		t.Fatalf("Expected a *RESTError, instead %v", err)
+	}
+	if restErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status code 404, instead %d", restErr.StatusCode)
+	}
+	if restErr.Message != "NotFound" {
+		t.Fatalf("Expected message to be NotFound, instead %s", restErr.Message)
+	}
+}
+
+func TestFetchingRESTOrderBooks(t *testing.T) {
+	client, closeServer := newTestRESTServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/products/BTC-USD/book" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+
+		switch r.URL.Query().Get("level") {
+		case "2":
+			w.Write([]byte(`{ "sequence": 7, "bids": [ [ "295.96", "4.39088265", 2 ] ], "asks": [ [ "295.97", "25.23542881", 12 ] ] }`))
+		case "3":
+			w.Write([]byte(`{ "sequence": 8, "bids": [ [ "295.96", "0.05", "aaaa" ] ], "asks": [ [ "295.97", "0.05", "bbbb" ] ] }`))
+		default:
+			t.Errorf("Unexpected level %s", r.URL.Query().Get("level"))
+		}
+	})
+	defer closeServer()
+
+	aggregated, err := client.GetAggregatedOrderBook("BTC-USD", 2)
+	if err != nil {
+		t.Fatalf("Unexpected error fetching order book: %s", err.Error())
+	}
+	if aggregated.Sequence != 7 || len(aggregated.Bids) != 1 || len(aggregated.Asks) != 1 {
+		t.Fatalf("Unexpected order book %v", aggregated)
+	}
+	if aggregated.Asks[0].Price != "295.97" || aggregated.Asks[0].Size != "25.23542881" || aggregated.Asks[0].NumOrders != 12 {
+		t.Fatalf("Unexpected ask level %v", aggregated.Asks[0])
+	}
+
+	if _, err = client.GetAggregatedOrderBook("BTC-USD", 3); err == nil {
+		t.Fatal("Expected level 3 to be rejected for an aggregated book")
+	}
+
+	client.Products.Add(DEFAULT_PRODUCT)
+
+	seq, batch, err := client.GetOrderBook("BTC-USD")
+	if err != nil {
+		t.Fatalf("Unexpected error fetching order book: %s", err.Error())
+	}
+	if seq != 8 || len(batch.Commands) != 2 {
+		t.Fatalf("Expected sequence 8 with 2 commands, instead %d with %d", seq, len(batch.Commands))
+	}
+}
+
+func TestFetchingRESTMarketData(t *testing.T) {
+	client, closeServer := newTestRESTServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/products":
+			w.Write([]byte(`[ { "id": "BTC-USD", "base_currency": "BTC", "quote_currency": "USD", "base_min_size": "0.01", "base_max_size": "10000.00", "quote_increment": "0.01" } ]`))
+		case "/products/BTC-USD/trades":
+			w.Write([]byte(`[ { "time": "2014-11-07T22:19:28.578544Z", "trade_id": 74, "price": "10.00000000", "size": "0.01000000", "side": "buy" } ]`))
+		case "/products/BTC-USD/candles":
+			if r.URL.Query().Get("granularity") != "60" {
+				t.Errorf("Expected granularity of 60 seconds, instead %s", r.URL.Query().Get("granularity"))
+			}
+			w.Write([]byte(`[ [ 1415398768, 0.32, 4.2, 0.35, 4.2, 12.3 ] ]`))
+		case "/time":
+			w.Write([]byte(`{ "iso": "2015-01-07T23:47:25.201Z", "epoch": 1420674445.201 }`))
+		default:
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+	})
+	defer closeServer()
+
+	products, err := client.GetProducts()
+	if err != nil {
+		t.Fatalf("Unexpected error fetching products: %s", err.Error())
+	}
+	if len(products) != 1 || products[0].ID != "BTC-USD" || products[0].QuoteIncrement != "0.01" {
+		t.Fatalf("Unexpected products %v", products)
+	}
+
+	trades, err := client.GetTrades("BTC-USD")
+	if err != nil {
+		t.Fatalf("Unexpected error fetching trades: %s", err.Error())
+	}
+	if len(trades) != 1 || trades[0].TradeID != 74 || trades[0].Side != book.SIDE_BUY {
+		t.Fatalf("Unexpected trades %v", trades)
+	}
+
+	candles, err := client.GetCandles("BTC-USD", time.Unix(0, 0), time.Unix(60, 0), time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error fetching candles: %s", err.Error())
+	}
+	if len(candles) != 1 || !candles[0].Time.Equal(time.Unix(1415398768, 0)) || candles[0].Volume != 12.3 {
+		t.Fatalf("Unexpected candles %v", candles)
+	}
+
+	now, err := client.GetTime()
+	if err != nil {
+		t.Fatalf("Unexpected error fetching time: %s", err.Error())
+	}
+	if !now.Equal(time.Date(2015, 1, 7, 23, 47, 25, 201000000, time.UTC)) {
+		t.Fatalf("Unexpected time %s", now)
+	}
+}
+
+func TestDecodingMalformedRESTOrderBook(t *testing.T) {
+	malformed := []string{
+		`not json`,
+		`{ "sequence": 3, "bids": [ [ "1.00", "0.01" ] ], "asks": [] }`,
+		`{ "sequence": 3, "bids": [], "asks": [ [ "abc", "0.01", "aaaa" ] ] }`,
+	}
+
+	for _, raw := range malformed {
+		if _, _, err := DecodeRESTOrderBook([]byte(raw)); err == nil {
+			t.Fatalf("Expected an error decoding %s", raw)
+		}
+	}
+}
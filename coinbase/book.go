@@ -1,26 +1,368 @@
 package coinbase
 
+import "context"
 import "github.com/jacobgreenleaf/yeti/book"
+import "log"
+import "sync/atomic"
+import "time"
 
-// The Available mutex represents the code's knowledge of whether the order book is stale.
+const (
+	DEFAULT_REORDER_WINDOW = 100
+
+	// A failed resync is retried no sooner than DEFAULT_RESYNC_MIN_BACKOFF
+	// later, doubling for every failure after that up to DEFAULT_RESYNC_MAX_BACKOFF
+	DEFAULT_RESYNC_MIN_BACKOFF = time.Second
+	DEFAULT_RESYNC_MAX_BACKOFF = time.Minute
+)
+
+// CoinbaseOrderBook follows the order book of one product on the exchange.
+//
+// Book can be read from any goroutine while the feed is being applied to it.
+// Each batch is applied in one go, so readers never see half of one, and the
+// book is Reset whenever it is rebuilt from a fresh snapshot. It is marked
+// stale while it is known to be missing batches: while it is being rebuilt,
+// after rebuilding it failed, or while the feed is missing them.
 //
-// When out of order events come through the web socket, the update routine
-// will grab a write lock, preventing anyone after who cares from reading a known stale version. It
-// will then buffer for a fixed amount of events before either panicking or finally
-// getting a complete ordered set of events, which it will then write and release the write
-// lock. People who try to grab read locks during that time will be blocked because they
-// don't want to read a known stale version.
+// Sequence, Gaps, Resyncs and FailedResyncs can be read from any goroutine
+// with atomic.LoadInt64. Everything else belongs to whoever is maintaining
+// the book.
 type CoinbaseOrderBook struct {
-	Book      book.OrderBook
-	Available *sync.RWMutex
-	feed      coinbase.OrderBookCommandFeed
+	Book     *book.ConcurrentOrderBook
+	Product  *ProductMetadata
+	Sequence int64
+
+	// Gaps counts the sequence gaps seen, Resyncs the number of times the book
+	// was rebuilt and FailedResyncs the number of times that didn't work.
+	Gaps          int64
+	Resyncs       int64
+	FailedResyncs int64
+
+	// ReorderWindow is the number of out of order batches buffered while waiting
+	// for a gap to close before the book is rebuilt from a fresh snapshot.
+	ReorderWindow int
+
+	// While the book is stale after a failed resync, batches are only buffered
+	// and the next snapshot is fetched with the first batch at least
+	// ResyncMinBackoff later, doubling up to ResyncMaxBackoff until one works.
+	ResyncMinBackoff time.Duration
+	ResyncMaxBackoff time.Duration
+
+	pending  map[int64]*CoinbaseOrderBookCommandBatch
+	newBook  func(scale book.Scale) *book.InMemoryOrderBook
+	snapshot func(product string) (int64, *CoinbaseOrderBookCommandBatch, error)
+	now      func() time.Time
+	backoff  time.Duration
+	retryAt  time.Time
+}
+
+func newCoinbaseOrderBook(product *ProductMetadata, newBook func(scale book.Scale) *book.InMemoryOrderBook, snapshot func(product string) (int64, *CoinbaseOrderBookCommandBatch, error)) *CoinbaseOrderBook {
+	return &CoinbaseOrderBook{
+		Book:             book.NewConcurrentOrderBook(newBook(product.Scale)),
+		Product:          product,
+		ReorderWindow:    DEFAULT_REORDER_WINDOW,
+		ResyncMinBackoff: DEFAULT_RESYNC_MIN_BACKOFF,
+		ResyncMaxBackoff: DEFAULT_RESYNC_MAX_BACKOFF,
+		pending:          make(map[int64]*CoinbaseOrderBookCommandBatch),
+		newBook:          newBook,
+		snapshot:         snapshot,
+		now:              time.Now,
+	}
+}
+
+// OrderBookRegistry maintains one CoinbaseOrderBook per product from a single
+// feed, routing each batch to the book for its product. Books is not
+// modified after Bootstrap returns.
+type OrderBookRegistry struct {
+	Books map[string]*CoinbaseOrderBook
+	feed  FeedSource
+
+	// feedDown is set from when the feed stops being live until it is live
+	// again, during which books are only ever rebuilt once it is
+	feedDown bool
+}
+
+// Get returns the book for a product, or nil if we aren't following it.
+func (r *OrderBookRegistry) Get(product string) *CoinbaseOrderBook {
+	return r.Books[product]
+}
+
+// SnapshotSource is where books get the metadata of their products and the
+// level 3 snapshots they are built from: the REST API, or the snapshots saved
+// alongside a recording.
+type SnapshotSource interface {
+	GetProduct(id string) (*ProductMetadata, error)
+	GetOrderBook(product string) (int64, *CoinbaseOrderBookCommandBatch, error)
+}
+
+// Bootstrap looks up the metadata of every product, subscribes feed to all of
+// the products at once and starts buffering events before loading each
+// product's level 3 snapshot from the REST API into a book made by newBook
+// with the product's scale. Buffered events at or below a book's snapshot
+// sequence are dropped by MaintainForever; the remainder are applied in order.
+func Bootstrap(feed FeedSource, products []string, newBook func(scale book.Scale) *book.InMemoryOrderBook) (*OrderBookRegistry, error) {
+	return BootstrapFrom(NewRESTClient(), feed, products, newBook)
+}
+
+// BootstrapFrom works like Bootstrap, but gets the metadata and snapshots,
+// including those for any later resyncs, from source.
+func BootstrapFrom(source SnapshotSource, feed FeedSource, products []string, newBook func(scale book.Scale) *book.InMemoryOrderBook) (*OrderBookRegistry, error) {
+	metas := make([]*ProductMetadata, 0, len(products))
+
+	for _, product := range products {
+		meta, err := source.GetProduct(product)
+
+		if err != nil {
+			return nil, err
+		}
+
+		metas = append(metas, meta)
+	}
+
+	if err := feed.Subscribe(metas...); err != nil {
+		return nil, err
+	}
+
+	r := &OrderBookRegistry{
+		Books: make(map[string]*CoinbaseOrderBook),
+		feed:  feed,
+	}
+
+	for _, meta := range metas {
+		b := newCoinbaseOrderBook(meta, newBook, source.GetOrderBook)
+
+		if err := b.synchronize(); err != nil {
+			return nil, err
+		}
+
+		r.Books[meta.ID] = b
+	}
+
+	return r, nil
+}
+
+// route hands a batch to the book for its product, rebuilding that book if
+// its sequence gap didn't close or an earlier rebuild is due to be retried,
+// as long as the feed is live. Batches for products we don't follow are
+// dropped.
+func (r *OrderBookRegistry) route(batch *CoinbaseOrderBookCommandBatch) {
+	if batch == nil {
+		return
+	}
+
+	b, ok := r.Books[batch.ProductID]
+
+	if !ok {
+		return
+	}
+
+	// A snapshot taken while the feed is down would be missing whatever the
+	// feed is, so that waits for feedStateChanged
+	if !b.process(batch) && !r.feedDown {
+		b.resyncWhenDue()
+	}
+}
+
+// feedStateChanged marks every book stale when the feed stops being live,
+// since they are missing whatever it didn't send, and rebuilds them once it is
+// live again.
+func (r *OrderBookRegistry) feedStateChanged(state string) {
+	switch state {
+	case FEED_STATE_STALE, FEED_STATE_CONNECTING:
+		r.feedDown = true
+		for _, b := range r.Books {
+			b.Book.SetStale(true)
+		}
+	case FEED_STATE_LIVE:
+		r.feedDown = false
+		for _, b := range r.Books {
+			if b.Book.Stale() {
+				b.resync()
+			}
+		}
+	}
+}
+
+// MaintainForever applies batches from the feed until it is closed, logging
+// any errors it reports along the way and rebuilding the books whenever the
+// feed comes back after missing batches. Once ctx is done it closes the feed
+// and returns after applying whatever batches the feed had already sent. It is
+// recomended to spawn this in a goroutine.
+func (r *OrderBookRegistry) MaintainForever(ctx context.Context) {
+	batches := r.feed.Batches()
+	errors := r.feed.Errors()
+	states := r.feed.States()
+	done := ctx.Done()
+
+	for {
+		select {
+		case <-done:
+			done = nil
+			if err := r.feed.Close(); err != nil {
+				log.Printf("Error closing feed: %s", err.Error())
+			}
+		case batch, ok := <-batches:
+			if !ok {
+				return
+			}
+			r.route(batch)
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			log.Printf("Error from feed: %s", err.Error())
+		case state, ok := <-states:
+			if !ok {
+				states = nil
+				continue
+			}
+			log.Printf("Feed is %s", state)
+			r.feedStateChanged(state)
+		}
+	}
+}
+
+// synchronize loads a fresh snapshot into a new book, swaps it in and
+// applies whatever was buffered past it. Anything still sitting in the feed
+// is dealt with as it arrives, since process skips batches at or below the
+// snapshot sequence.
+func (b *CoinbaseOrderBook) synchronize() error {
+	_, snapshot, err := b.snapshot(b.Product.ID)
+
+	if err != nil {
+		return err
+	}
+
+	// Built off to the side, so readers keep the old book until it's ready
+	fresh := b.newBook(b.Product.Scale)
+
+	if err = snapshot.Apply(fresh); err != nil {
+		return err
+	}
+
+	// Everything in a level 3 snapshot is resting on the book
+	for _, cmd := range snapshot.Commands {
+		placement, ok := cmd.(*book.OrderBookPlacementCommand)
+		if !ok {
+			continue
+		}
+
+		err := fresh.MutateOrder(placement.Order.ID, []book.OrderMutation{&book.OrderStateMutation{
+			State: book.STATE_OPEN,
+			Time:  placement.Time,
+		}})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	b.Book.Reset(fresh)
+	atomic.StoreInt64(&b.Sequence, snapshot.Sequence)
+
+	// Whatever we were holding on to may now be stale or even contiguous
+	pending := b.pending
+	b.pending = make(map[int64]*CoinbaseOrderBookCommandBatch)
+	for _, batch := range pending {
+		if batch.Sequence > b.Sequence {
+			b.pending[batch.Sequence] = batch
+		}
+	}
+	b.drainPending()
+
+	return nil
+}
+
+// resyncWhenDue resyncs the book unless the last attempt failed too
+// recently.
+func (b *CoinbaseOrderBook) resyncWhenDue() {
+	if b.Book.Stale() && b.now().Before(b.retryAt) {
+		return
+	}
+
+	b.resync()
+}
+
+// resync rebuilds the book from a fresh snapshot, marking it stale until
+// that works and backing off the next attempt if it doesn't.
+func (b *CoinbaseOrderBook) resync() {
+	b.Book.SetStale(true)
+
+	log.Printf("Resynchronizing %s order book at sequence %d", b.Product.ID, b.Sequence)
+
+	if err := b.synchronize(); err != nil {
+		atomic.AddInt64(&b.FailedResyncs, 1)
+
+		b.backoff *= 2
+		if b.backoff < b.ResyncMinBackoff {
+			b.backoff = b.ResyncMinBackoff
+		}
+		if b.backoff > b.ResyncMaxBackoff {
+			b.backoff = b.ResyncMaxBackoff
+		}
+		b.retryAt = b.now().Add(b.backoff)
+
+		log.Printf("Failed to resynchronize %s order book, retrying in %s: %s", b.Product.ID, b.backoff, err.Error())
+		return
+	}
+
+	atomic.AddInt64(&b.Resyncs, 1)
+	b.backoff = 0
+	b.retryAt = time.Time{}
+	b.Book.SetStale(false)
+}
+
+// process applies a single batch from the feed if it is the next in sequence,
+// skipping anything the book has already seen and buffering anything that
+// arrives early. It returns false when the reorder window has been exceeded
+// or the book is stale, and the book needs to be rebuilt.
+func (b *CoinbaseOrderBook) process(batch *CoinbaseOrderBookCommandBatch) bool {
+	if b.Book.Stale() {
+		// Hold on to what we can so it isn't lost if the next snapshot is behind it
+		if batch != nil && len(b.pending) < b.ReorderWindow {
+			b.pending[batch.Sequence] = batch
+		}
+
+		return false
+	}
+
+	if batch == nil || batch.Sequence <= b.Sequence {
+		return true
+	}
+
+	if batch.Sequence != b.Sequence+1 {
+		if len(b.pending) == 0 {
+			atomic.AddInt64(&b.Gaps, 1)
+		}
+
+		b.pending[batch.Sequence] = batch
+
+		return len(b.pending) <= b.ReorderWindow
+	}
+
+	b.apply(batch)
+	b.drainPending()
+
+	return true
 }
 
-func Bootstrap() (*CoinbaseOrderBook, error) {
+// drainPending applies buffered batches for as long as they are contiguous.
+func (b *CoinbaseOrderBook) drainPending() {
+	for {
+		batch, ok := b.pending[b.Sequence+1]
+		if !ok {
+			return
+		}
 
+		delete(b.pending, batch.Sequence)
+		b.apply(batch)
+	}
 }
 
-// It is recomended to spawn this in a goroutine.
-func (b *CoinbaseOrderBook) MaintainForever() {
+func (b *CoinbaseOrderBook) apply(batch *CoinbaseOrderBookCommandBatch) {
+	if err := b.Book.Apply(batch); err != nil {
+		log.Printf("Failed to apply order book command: %s", err.Error())
+	}
 
+	atomic.StoreInt64(&b.Sequence, batch.Sequence)
 }
@@ -1,7 +1,326 @@
 package coinbase
 
+import "errors"
 import "testing"
+import "time"
+import "github.com/jacobgreenleaf/yeti/book"
+
+func newTestBook(t *testing.T, product *ProductMetadata, snapshots ...string) *CoinbaseOrderBook {
+	newBook := func(scale book.Scale) *book.InMemoryOrderBook {
+		return book.NewScaledInMemoryOrderBook(scale)
+	}
+
+	snapshot := func(id string) (int64, *CoinbaseOrderBookCommandBatch, error) {
+		if id != product.ID {
+			t.Fatalf("Unexpected request for a %s order book snapshot", id)
+		}
+		if len(snapshots) == 0 {
+			t.Fatal("Unexpected request for an order book snapshot")
+		}
+
+		raw := snapshots[0]
+		snapshots = snapshots[1:]
+
+		return product.DecodeRESTOrderBook([]byte(raw))
+	}
+
+	return newCoinbaseOrderBook(product, newBook, snapshot)
+}
+
+func voidBatch(seq int64, id book.OrderID) *CoinbaseOrderBookCommandBatch {
+	return &CoinbaseOrderBookCommandBatch{
+		Sequence: seq,
+		Commands: []book.OrderBookCommand{&book.OrderBookMutationCommand{
+			ID: id,
+			Mutations: []book.OrderMutation{&book.OrderStateMutation{
+				State: book.STATE_VOID,
+				Time:  time.Unix(seq, 0),
+			}},
+		}},
+	}
+}
 
 func TestBootstrappingBook(t *testing.T) {
+	b := newTestBook(t, DEFAULT_PRODUCT, `
+		{
+			"sequence": 10,
+			"bids": [
+				[ "1.00", "0.01", "aaaa" ],
+				[ "1.01", "0.01", "bbbb" ]
+			],
+			"asks": [
+				[ "1.10", "0.01", "cccc" ]
+			]
+		}
+	`)
+
+	if err := b.synchronize(); err != nil {
+		t.Fatalf("Unexpected error synchronizing book: %s", err.Error())
+	}
+
+	// Buffered while the snapshot was loading and already reflected in it;
+	// applying it again would fail since bbbb exists
+	b.process(&CoinbaseOrderBookCommandBatch{
+		Sequence: 9,
+		Commands: []book.OrderBookCommand{&book.OrderBookPlacementCommand{
+			Order: book.Order{ID: "bbbb", Price: 101, Side: book.SIDE_BUY},
+			Size:  1000000,
+			Time:  time.Unix(1, 0),
+		}},
+	})
+	b.process(voidBatch(11, "aaaa"))
+
+	if b.Sequence != 11 {
+		t.Fatalf("Expected book sequence to be 11, instead %d", b.Sequence)
+	}
+
+	order, err := b.Book.GetOrder("aaaa")
+	if err != nil {
+		t.Fatalf("Unexpected error getting order: %s", err.Error())
+	}
+	if order.State != book.STATE_VOID {
+		t.Fatalf("Expected buffered cancellation to be applied, instead order is %s", order.State)
+	}
+
+	order, err = b.Book.GetOrder("cccc")
+	if err != nil {
+		t.Fatalf("Unexpected error getting order: %s", err.Error())
+	}
+	if order.State != book.STATE_OPEN {
+		t.Fatalf("Expected snapshot order to be open, instead %s", order.State)
+	}
+
+	var bid, ask int64
+	b.Book.Read(func(orderBook *book.InMemoryOrderBook) {
+		bid, _, ask, _ = book.CalculateBidMedianAskSpreadInMemory(orderBook, time.Unix(12, 0))
+	})
+	if bid != 101 || ask != 110 {
+		t.Fatalf("Expected bid 101 and ask 110, instead %d and %d", bid, ask)
+	}
+}
+
+func TestReorderingOutOfSequenceBatches(t *testing.T) {
+	b := newTestBook(t, DEFAULT_PRODUCT, `
+		{
+			"sequence": 10,
+			"bids": [
+				[ "1.00", "0.01", "aaaa" ],
+				[ "1.01", "0.01", "bbbb" ]
+			],
+			"asks": []
+		}
+	`)
+
+	if err := b.synchronize(); err != nil {
+		t.Fatalf("Unexpected error synchronizing book: %s", err.Error())
+	}
+
+	if !b.process(voidBatch(12, "bbbb")) {
+		t.Fatal("Expected a single out of order batch to fit in the reorder window")
+	}
+	if b.Sequence != 10 {
+		t.Fatalf("Expected out of order batch to be held back, instead sequence is %d", b.Sequence)
+	}
+	if b.Gaps != 1 {
+		t.Fatalf("Expected one gap, instead %d", b.Gaps)
+	}
+
+	if !b.process(voidBatch(11, "aaaa")) {
+		t.Fatal("Expected the gap to close")
+	}
+	if b.Sequence != 12 {
+		t.Fatalf("Expected both batches to be applied once the gap closed, instead sequence is %d", b.Sequence)
+	}
+
+	order, _ := b.Book.GetOrder("bbbb")
+	if order.State != book.STATE_VOID {
+		t.Fatalf("Expected buffered batch to be applied, instead order is %s", order.State)
+	}
+	if b.Resyncs != 0 {
+		t.Fatalf("Expected no resyncs, instead %d", b.Resyncs)
+	}
+}
+
+func TestResyncingAfterUnclosedGap(t *testing.T) {
+	b := newTestBook(t, DEFAULT_PRODUCT, `
+		{
+			"sequence": 10,
+			"bids": [ [ "1.00", "0.01", "aaaa" ] ],
+			"asks": []
+		}
+	`, `
+		{
+			"sequence": 20,
+			"bids": [ [ "1.02", "0.01", "bbbb" ] ],
+			"asks": []
+		}
+	`)
+	b.ReorderWindow = 2
+
+	if err := b.synchronize(); err != nil {
+		t.Fatalf("Unexpected error synchronizing book: %s", err.Error())
+	}
+
+	// Only asks, but a reset goes to everyone
+	sub := b.Book.Subscribe(book.SubscriptionFilter{Side: book.SIDE_SELL}, 10, book.SLOW_CONSUMER_DROP)
+
+	b.process(voidBatch(12, "aaaa"))
+	b.process(voidBatch(13, "aaaa"))
+
+	if b.process(voidBatch(21, "bbbb")) {
+		t.Fatal("Expected the reorder window to be exceeded")
+	}
+
+	b.resync()
+
+	select {
+	case e := <-sub.C:
+		if _, ok := e.(*book.BookResetEvent); !ok {
+			t.Fatalf("Expected subscribers to be told the book was reset, instead %v", e)
+		}
+	default:
+		t.Fatal("Expected the subscription to carry over to the rebuilt book")
+	}
+
+	b.process(&CoinbaseOrderBookCommandBatch{Sequence: 22})
+
+	if b.Book.Stale() {
+		t.Fatal("Expected book not to be stale after a successful resync")
+	}
+	if b.Gaps != 1 || b.Resyncs != 1 {
+		t.Fatalf("Expected one gap and one resync, instead %d and %d", b.Gaps, b.Resyncs)
+	}
+	if b.Sequence != 22 {
+		t.Fatalf("Expected buffered batches past the snapshot to be applied, instead sequence is %d", b.Sequence)
+	}
+
+	if _, err := b.Book.GetOrder("aaaa"); err == nil {
+		t.Fatal("Expected the old book to be thrown away")
+	}
+
+	order, err := b.Book.GetOrder("bbbb")
+	if err != nil {
+		t.Fatalf("Unexpected error getting order: %s", err.Error())
+	}
+	if order.State != book.STATE_VOID {
+		t.Fatalf("Expected buffered batch to be applied, instead order is %s", order.State)
+	}
+}
+
+func TestBackingOffFailedResyncs(t *testing.T) {
+	b := newTestBook(t, DEFAULT_PRODUCT, `{ "sequence": 10, "bids": [ [ "1.00", "0.01", "aaaa" ] ], "asks": [] }`)
+
+	if err := b.synchronize(); err != nil {
+		t.Fatalf("Unexpected error synchronizing book: %s", err.Error())
+	}
+
+	snapshots := 0
+	b.snapshot = func(id string) (int64, *CoinbaseOrderBookCommandBatch, error) {
+		snapshots += 1
+		if snapshots < 3 {
+			return 0, nil, errors.New("Rate limited.")
+		}
+		return DEFAULT_PRODUCT.DecodeRESTOrderBook([]byte(`{ "sequence": 20, "bids": [], "asks": [] }`))
+	}
+
+	now := time.Unix(1000, 0)
+	b.now = func() time.Time { return now }
+
+	r := &OrderBookRegistry{Books: map[string]*CoinbaseOrderBook{"BTC-USD": b}}
+	send := func(seq int64) {
+		batch := &CoinbaseOrderBookCommandBatch{ProductID: "BTC-USD", Sequence: seq}
+		r.route(batch)
+	}
+
+	b.ReorderWindow = 1
+	send(12)
+	send(13)
+
+	if !b.Book.Stale() || snapshots != 1 || b.FailedResyncs != 1 {
+		t.Fatalf("Expected one failed resync leaving the book stale, instead stale=%t after %d snapshots and %d failures", b.Book.Stale(), snapshots, b.FailedResyncs)
+	}
+
+	// Nothing is fetched until the backoff is up, however many batches arrive
+	b.ReorderWindow = DEFAULT_REORDER_WINDOW
+	for seq := int64(14); seq < 20; seq++ {
+		send(seq)
+	}
+	if snapshots != 1 {
+		t.Fatalf("Expected no snapshots during the backoff, instead %d", snapshots)
+	}
+
+	now = now.Add(DEFAULT_RESYNC_MIN_BACKOFF)
+	send(20)
+
+	if snapshots != 2 || b.FailedResyncs != 2 {
+		t.Fatalf("Expected a second failed resync once the backoff was up, instead %d snapshots and %d failures", snapshots, b.FailedResyncs)
+	}
+
+	// The second failure backs off twice as long
+	now = now.Add(DEFAULT_RESYNC_MIN_BACKOFF)
+	send(21)
+	if snapshots != 2 {
+		t.Fatalf("Expected the backoff to double, instead %d snapshots", snapshots)
+	}
+
+	now = now.Add(DEFAULT_RESYNC_MIN_BACKOFF)
+	send(22)
+
+	if b.Book.Stale() || snapshots != 3 || b.Resyncs != 1 {
+		t.Fatalf("Expected the third resync to work, instead stale=%t after %d snapshots and %d resyncs", b.Book.Stale(), snapshots, b.Resyncs)
+	}
+	if b.Sequence != 22 {
+		t.Fatalf("Expected batches buffered past the snapshot to be applied, instead sequence is %d", b.Sequence)
+	}
+}
+
+func TestRoutingBatchesByProduct(t *testing.T) {
+	ethBtc := &ProductMetadata{
+		ID:             "ETH-BTC",
+		Scale:          book.Scale{PriceDecimals: 5, SizeDecimals: 8},
+		QuoteIncrement: 1,
+		BaseIncrement:  1,
+	}
+
+	r := &OrderBookRegistry{Books: map[string]*CoinbaseOrderBook{
+		"BTC-USD": newTestBook(t, DEFAULT_PRODUCT, `{ "sequence": 10, "bids": [ [ "1.00", "0.01", "aaaa" ] ], "asks": [] }`),
+		"ETH-BTC": newTestBook(t, ethBtc, `{ "sequence": 500, "bids": [ [ "0.03012", "0.01", "bbbb" ] ], "asks": [] }`),
+	}}
+
+	for _, b := range r.Books {
+		if err := b.synchronize(); err != nil {
+			t.Fatalf("Unexpected error synchronizing book: %s", err.Error())
+		}
+	}
+
+	btcUsd := voidBatch(11, "aaaa")
+	btcUsd.ProductID = "BTC-USD"
+	r.route(btcUsd)
+
+	ethBtcBatch := voidBatch(501, "bbbb")
+	ethBtcBatch.ProductID = "ETH-BTC"
+	r.route(ethBtcBatch)
+
+	ignored := voidBatch(1, "cccc")
+	ignored.ProductID = "LTC-USD"
+	r.route(ignored)
+
+	if r.Get("BTC-USD").Sequence != 11 || r.Get("ETH-BTC").Sequence != 501 {
+		t.Fatalf("Expected sequences to be tracked per product, instead %d and %d", r.Get("BTC-USD").Sequence, r.Get("ETH-BTC").Sequence)
+	}
+	if r.Get("BTC-USD").Gaps != 0 || r.Get("ETH-BTC").Gaps != 0 {
+		t.Fatal("Expected no gaps when each product's sequence is contiguous")
+	}
+
+	order, err := r.Get("ETH-BTC").Book.GetOrder("bbbb")
+	if err != nil {
+		t.Fatalf("Unexpected error getting order: %s", err.Error())
+	}
+	if order.State != book.STATE_VOID || order.Price != 3012 {
+		t.Fatalf("Expected ETH-BTC order to be voided at its own scale, instead %s", order)
+	}
 
+	if r.Get("LTC-USD") != nil {
+		t.Fatal("Expected no book for a product we don't follow")
+	}
 }
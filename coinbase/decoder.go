@@ -2,11 +2,8 @@ package coinbase
 
 import "encoding/json"
 import "github.com/jacobgreenleaf/yeti/book"
-import "strconv"
-import "log"
 import "time"
 import "errors"
-import "bytes"
 import "fmt"
 
 const (
@@ -16,18 +13,97 @@ const (
 	MESSAGE_CHANGE   = "change"
 	MESSAGE_DONE     = "done"
 	MESSAGE_ERROR    = "error"
+
+	// Sent after every subscribe, outside of any product's sequence
+	MESSAGE_SUBSCRIPTIONS = "subscriptions"
+
 	REASON_FILLED    = "filled"
 	REASON_CANCELLED = "cancelled"
-	SATOSHI          = 100000000
+	REASON_CANCELED  = "canceled"
 )
 
 var (
 	errStaleCommand = errors.New("Order sequence is older than the book sequence.")
+	errMissingField = errors.New("Field is missing.")
 )
 
+// RealtimeDecodeError describes a message from the realtime feed that could
+// not be turned into order book commands.
+type RealtimeDecodeError struct {
+	MessageType string
+	Field       string
+	Raw         []byte
+	Err         error
+}
+
+func (e *RealtimeDecodeError) Error() string {
+	return fmt.Sprintf("Error decoding %s message field %s: %s; raw message: %s", e.MessageType, e.Field, e.Err.Error(), e.Raw)
+}
+
+// RealtimeError is an error message sent by the exchange over the realtime feed.
+type RealtimeError struct {
+	Message string
+}
+
+func (e *RealtimeError) Error() string {
+	return fmt.Sprintf("Coinbase realtime feed error: %s", e.Message)
+}
+
+// RealtimeMessageHeader holds the fields common to every order message.
+type RealtimeMessageHeader struct {
+	Type      string      `json:"type"`
+	Time      string      `json:"time"`
+	ProductID string      `json:"product_id"`
+	Sequence  json.Number `json:"sequence"`
+	Side      string      `json:"side"`
+	Price     string      `json:"price"`
+}
+
+type ReceivedMessage struct {
+	RealtimeMessageHeader
+	OrderID   string `json:"order_id"`
+	OrderType string `json:"order_type"`
+	Size      string `json:"size"`
+	Funds     string `json:"funds"`
+}
+
+type OpenMessage struct {
+	RealtimeMessageHeader
+	OrderID       string `json:"order_id"`
+	RemainingSize string `json:"remaining_size"`
+}
+
+type DoneMessage struct {
+	RealtimeMessageHeader
+	OrderID       string `json:"order_id"`
+	Reason        string `json:"reason"`
+	RemainingSize string `json:"remaining_size"`
+}
+
+type MatchMessage struct {
+	RealtimeMessageHeader
+	TradeID      json.Number `json:"trade_id"`
+	MakerOrderID string      `json:"maker_order_id"`
+	TakerOrderID string      `json:"taker_order_id"`
+	Size         string      `json:"size"`
+}
+
+type ChangeMessage struct {
+	RealtimeMessageHeader
+	OrderID string `json:"order_id"`
+	NewSize string `json:"new_size"`
+	OldSize string `json:"old_size"`
+}
+
+type ErrorMessage struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
 type CoinbaseOrderBookCommandBatch struct {
-	Commands []book.OrderBookCommand
-	Sequence int64
+	Commands  []book.OrderBookCommand
+	Sequence  int64
+	ProductID string
 }
 
 func (b *CoinbaseOrderBookCommandBatch) Apply(book book.OrderBook) error {
@@ -40,254 +116,434 @@ func (b *CoinbaseOrderBookCommandBatch) Apply(book book.OrderBook) error {
 	return nil
 }
 
-func DecodeRealtimeEvent(rawMsg []byte) *CoinbaseOrderBookCommandBatch {
-	var coinbaseEvent map[string]interface{}
-	decoder := json.NewDecoder(bytes.NewReader(rawMsg))
-	decoder.UseNumber()
-	err := decoder.Decode(&coinbaseEvent)
+// realtimeDecoder turns the fields of a single raw message into values,
+// wrapping every failure in a *RealtimeDecodeError.
+type realtimeDecoder struct {
+	raw     []byte
+	msgType string
+	product *ProductMetadata
+}
 
-	if err != nil {
-		log.Fatalf("Error decoding coinbase JSON: %s", err.Error())
-		return nil
+func (d *realtimeDecoder) fail(field string, err error) error {
+	return &RealtimeDecodeError{MessageType: d.msgType, Field: field, Raw: d.raw, Err: err}
+}
+
+func (d *realtimeDecoder) unmarshal(msg interface{}) error {
+	if err := json.Unmarshal(d.raw, msg); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+			return d.fail(typeErr.Field, err)
+		}
+		return d.fail("", err)
 	}
+	return nil
+}
 
-	cmds := make([]book.OrderBookCommand, 0)
+func (d *realtimeDecoder) id(field, value string) (book.OrderID, error) {
+	if value == "" {
+		return "", d.fail(field, errMissingField)
+	}
+	return book.OrderID(value), nil
+}
 
-	coinbaseType, _ := coinbaseEvent["type"].(string)
+func (d *realtimeDecoder) number(field string, value json.Number) (int64, error) {
+	if value == "" {
+		return 0, d.fail(field, errMissingField)
+	}
+	v, err := value.Int64()
+	if err != nil {
+		return 0, d.fail(field, err)
+	}
+	return v, nil
+}
 
-	if "error" == coinbaseType {
-		log.Printf("Received coinbase error: %s", coinbaseEvent["message"].(string))
-		return nil
+func (d *realtimeDecoder) timestamp(field, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, d.fail(field, errMissingField)
 	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, d.fail(field, err)
+	}
+	return t, nil
+}
+
+func (d *realtimeDecoder) price(field, value string) (int64, error) {
+	if value == "" {
+		return 0, d.fail(field, errMissingField)
+	}
+	v, err := d.product.ParsePrice(value)
+	if err != nil {
+		return 0, d.fail(field, err)
+	}
+	return v, nil
+}
 
-	coinbaseTime, err := time.Parse(time.RFC3339Nano, coinbaseEvent["time"].(string))
+func (d *realtimeDecoder) size(field, value string) (int64, error) {
+	if value == "" {
+		return 0, d.fail(field, errMissingField)
+	}
+	v, err := d.product.ParseSize(value)
 	if err != nil {
-		log.Fatalf("Failed to parse timestamp %s", coinbaseEvent["time"].(string))
+		return 0, d.fail(field, err)
 	}
+	return v, nil
+}
 
-	coinbaseSide := coinbaseEvent["side"].(string)
-	coinbasePrice, err := strconv.ParseFloat(coinbaseEvent["price"].(string), 64)
+// DecodeRealtimeEvent decodes a message as if its product were priced in
+// cents and sized in satoshi, whatever product it is for.
+func DecodeRealtimeEvent(rawMsg []byte) (*CoinbaseOrderBookCommandBatch, error) {
+	return decodeRealtimeEvent(rawMsg, func(id string) (*ProductMetadata, bool) {
+		return DEFAULT_PRODUCT, true
+	})
+}
+
+// DecodeRealtimeEvent turns a single message from the realtime feed into a
+// batch of order book commands, using the scale of the message's product.
+// Malformed messages produce a *RealtimeDecodeError and error messages from
+// the exchange a *RealtimeError. Messages that aren't part of any product's
+// sequence, like subscription acknowledgements, produce neither a batch nor
+// an error. Messages with prices or sizes for a product that isn't in the
+// registry can't be scaled and are a *RealtimeDecodeError too.
+func (r *ProductRegistry) DecodeRealtimeEvent(rawMsg []byte) (*CoinbaseOrderBookCommandBatch, error) {
+	return decodeRealtimeEvent(rawMsg, r.Get)
+}
+
+func decodeRealtimeEvent(rawMsg []byte, lookup func(id string) (*ProductMetadata, bool)) (*CoinbaseOrderBookCommandBatch, error) {
+	d := &realtimeDecoder{raw: rawMsg}
+
+	var header RealtimeMessageHeader
+	if err := d.unmarshal(&header); err != nil {
+		return nil, err
+	}
+
+	d.msgType = header.Type
+	product, known := lookup(header.ProductID)
+	d.product = product
+
+	if MESSAGE_ERROR == header.Type {
+		var msg ErrorMessage
+		if err := d.unmarshal(&msg); err != nil {
+			return nil, err
+		}
+		return nil, &RealtimeError{Message: msg.Message}
+	}
 
+	if MESSAGE_SUBSCRIPTIONS == header.Type {
+		return nil, nil
+	}
+
+	coinbaseSequenceNumber, err := d.number("sequence", header.Sequence)
 	if err != nil {
-		log.Fatalf("Failed to parse float price %s: %s", coinbaseEvent["price"].(string), err.Error())
-		return nil
+		return nil, err
 	}
 
-	coinbasePriceCents := int64(coinbasePrice * 100)
-	coinbaseSequenceNumber, err := coinbaseEvent["sequence"].(json.Number).Int64()
+	switch header.Type {
+	case MESSAGE_RECEIVED, MESSAGE_OPEN, MESSAGE_DONE, MESSAGE_MATCH, MESSAGE_CHANGE:
+	default:
+		// Messages we don't track still take up a sequence number
+		return &CoinbaseOrderBookCommandBatch{Sequence: coinbaseSequenceNumber, ProductID: header.ProductID}, nil
+	}
+
+	if !known {
+		return nil, d.fail("product_id", errUnknownProduct)
+	}
+
+	coinbaseTime, err := d.timestamp("time", header.Time)
 	if err != nil {
-		log.Fatalf("Failed to parse sequence number %s: %s", coinbaseEvent["sequence"].(json.Number), err.Error())
-		return nil
+		return nil, err
 	}
 
-	switch coinbaseType {
+	var cmds []book.OrderBookCommand
+
+	switch header.Type {
 	case MESSAGE_RECEIVED:
+		var msg ReceivedMessage
+		if err = d.unmarshal(&msg); err != nil {
+			return nil, err
+		}
+		cmds, err = d.decodeReceived(&msg, coinbaseTime)
+	case MESSAGE_OPEN:
+		var msg OpenMessage
+		if err = d.unmarshal(&msg); err != nil {
+			return nil, err
+		}
+		cmds, err = d.decodeOpen(&msg, coinbaseTime)
+	case MESSAGE_DONE:
+		var msg DoneMessage
+		if err = d.unmarshal(&msg); err != nil {
+			return nil, err
+		}
+		cmds, err = d.decodeDone(&msg, coinbaseTime)
+	case MESSAGE_MATCH:
+		var msg MatchMessage
+		if err = d.unmarshal(&msg); err != nil {
+			return nil, err
+		}
+		cmds, err = d.decodeMatch(&msg, coinbaseTime)
+	case MESSAGE_CHANGE:
+		var msg ChangeMessage
+		if err = d.unmarshal(&msg); err != nil {
+			return nil, err
+		}
+		cmds, err = d.decodeChange(&msg, coinbaseTime)
+	}
+
+	if err != nil {
+		return nil, err
+	}
 
-		coinbaseSize, err := strconv.ParseFloat(coinbaseEvent["size"].(string), 64)
+	return &CoinbaseOrderBookCommandBatch{
+		Commands:  cmds,
+		Sequence:  coinbaseSequenceNumber,
+		ProductID: header.ProductID,
+	}, nil
+}
 
-		if err != nil {
-			log.Fatalf("Failed to parse float size: %s", coinbaseEvent["size"].(string))
-			return nil
+func (d *realtimeDecoder) decodeReceived(msg *ReceivedMessage, coinbaseTime time.Time) ([]book.OrderBookCommand, error) {
+	orderId, err := d.id("order_id", msg.OrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Market orders may come without a price and with funds instead of a
+	// size. They never rest on the book, but matches still refer to them.
+	var coinbasePrice, coinbaseSize int64
+
+	if msg.Price != "" || msg.OrderType != ORDER_TYPE_MARKET {
+		if coinbasePrice, err = d.price("price", msg.Price); err != nil {
+			return nil, err
 		}
+	}
 
-		coinbaseSizeSatoshi := int64(coinbaseSize * float64(SATOSHI))
+	if msg.Size != "" || msg.OrderType != ORDER_TYPE_MARKET {
+		if coinbaseSize, err = d.size("size", msg.Size); err != nil {
+			return nil, err
+		}
+	}
 
-		cmds = append(cmds, &book.OrderBookPlacementCommand{
-			Order: book.Order{
-				ID:    book.OrderID(coinbaseEvent["order_id"].(string)),
-				Price: coinbasePriceCents,
-				Side:  coinbaseSide,
-			},
-			Size: coinbaseSizeSatoshi,
-			Time: coinbaseTime,
-		})
-		break
-	case MESSAGE_OPEN:
+	return []book.OrderBookCommand{&book.OrderBookPlacementCommand{
+		Order: book.Order{
+			ID:    orderId,
+			Price: coinbasePrice,
+			Side:  msg.Side,
+		},
+		Size: coinbaseSize,
+		Time: coinbaseTime,
+	}}, nil
+}
 
-		coinbaseSize, err := strconv.ParseFloat(coinbaseEvent["remaining_size"].(string), 64)
+func (d *realtimeDecoder) decodeOpen(msg *OpenMessage, coinbaseTime time.Time) ([]book.OrderBookCommand, error) {
+	orderId, err := d.id("order_id", msg.OrderID)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			log.Fatalf("Failed to parse float size: %s", coinbaseEvent["size"].(string))
-			return nil
-		}
+	coinbaseSize, err := d.size("remaining_size", msg.RemainingSize)
+	if err != nil {
+		return nil, err
+	}
 
-		coinbaseSizeSatoshi := int64(coinbaseSize * float64(SATOSHI))
+	muts := make([]book.OrderMutation, 0, 2)
+	muts = append(muts, &book.OrderSizeMutation{
+		NewSize: coinbaseSize,
+		Time:    coinbaseTime,
+	})
+	muts = append(muts, &book.OrderStateMutation{
+		State: book.STATE_OPEN,
+		Time:  coinbaseTime,
+	})
+
+	return []book.OrderBookCommand{&book.OrderBookMutationCommand{
+		ID:        orderId,
+		Mutations: muts,
+	}}, nil
+}
 
-		muts := make([]book.OrderMutation, 0, 2)
-		muts = append(muts, &book.OrderSizeMutation{
-			NewSize: coinbaseSizeSatoshi,
-			Time:    coinbaseTime,
-		})
-		muts = append(muts, &book.OrderStateMutation{
-			State: book.STATE_OPEN,
-			Time:  coinbaseTime,
-		})
+func (d *realtimeDecoder) decodeDone(msg *DoneMessage, coinbaseTime time.Time) ([]book.OrderBookCommand, error) {
+	orderId, err := d.id("order_id", msg.OrderID)
+	if err != nil {
+		return nil, err
+	}
 
-		cmds = append(cmds, &book.OrderBookMutationCommand{
-			ID:        book.OrderID(coinbaseEvent["order_id"].(string)),
-			Mutations: muts,
-		})
-		break
-	case MESSAGE_DONE:
-		reason := coinbaseEvent["reason"].(string)
+	var coinbaseState string
 
-		coinbaseSize, err := strconv.ParseFloat(coinbaseEvent["remaining_size"].(string), 64)
-		if err != nil {
-			log.Fatalf("Failed to parse float size: %s", coinbaseEvent["remaining_size"].(string))
-			return nil
-		}
-		coinbaseSizeSatoshi := int64(coinbaseSize * float64(SATOSHI))
+	if REASON_FILLED == msg.Reason {
+		coinbaseState = book.STATE_FILLED
+	} else if REASON_CANCELLED == msg.Reason || REASON_CANCELED == msg.Reason {
+		coinbaseState = book.STATE_VOID
+	} else {
+		return nil, d.fail("reason", fmt.Errorf("Unknown reason %s", msg.Reason))
+	}
 
-		var coinbaseState string
+	muts := make([]book.OrderMutation, 0, 2)
 
-		if REASON_FILLED == reason {
-			coinbaseState = book.STATE_FILLED
-		} else if REASON_CANCELLED == reason {
-			coinbaseState = book.STATE_VOID
+	// Market orders are done without a remaining size
+	if msg.RemainingSize != "" {
+		coinbaseSize, err := d.size("remaining_size", msg.RemainingSize)
+		if err != nil {
+			return nil, err
 		}
 
-		muts := make([]book.OrderMutation, 0, 2)
 		muts = append(muts, &book.OrderSizeMutation{
-			NewSize: coinbaseSizeSatoshi,
+			NewSize: coinbaseSize,
 			Time:    coinbaseTime,
 		})
-		muts = append(muts, &book.OrderStateMutation{
-			State: coinbaseState,
-			Time:  coinbaseTime,
-		})
+	}
 
-		cmds = append(cmds, &book.OrderBookMutationCommand{
-			ID:        book.OrderID(coinbaseEvent["order_id"].(string)),
-			Mutations: muts,
-		})
-		break
-	case MESSAGE_MATCH:
-		makerId := book.OrderID(coinbaseEvent["maker_order_id"].(string))
-		takerId := book.OrderID(coinbaseEvent["taker_order_id"].(string))
+	muts = append(muts, &book.OrderStateMutation{
+		State: coinbaseState,
+		Time:  coinbaseTime,
+	})
 
-		coinbaseSize, err := strconv.ParseFloat(coinbaseEvent["size"].(string), 64)
-		if err != nil {
-			log.Fatalf("Failed to parse float size: %s", coinbaseEvent["size"].(string))
-			return nil
-		}
-		coinbaseSizeSatoshi := int64(coinbaseSize * float64(SATOSHI))
-		tradeId, err := coinbaseEvent["trade_id"].(json.Number).Int64()
-		if err != nil {
-			log.Fatalf("Failed to parse trade id %s: %s", coinbaseEvent["trade_id"].(json.Number), err.Error())
-			return nil
-		}
+	return []book.OrderBookCommand{&book.OrderBookMutationCommand{
+		ID:        orderId,
+		Mutations: muts,
+	}}, nil
+}
 
-		takerMuts := []book.OrderMutation{&book.OrderMatchMutation{
-			TradeID:  tradeId,
-			Size:     coinbaseSizeSatoshi,
-			WasMaker: false,
-			MakerID:  makerId,
-			Time:     coinbaseTime,
-		}}
-
-		cmdTaker := &book.OrderBookMutationCommand{
-			ID:        takerId,
-			Mutations: takerMuts,
-		}
+func (d *realtimeDecoder) decodeMatch(msg *MatchMessage, coinbaseTime time.Time) ([]book.OrderBookCommand, error) {
+	makerId, err := d.id("maker_order_id", msg.MakerOrderID)
+	if err != nil {
+		return nil, err
+	}
 
-		cmds = append(cmds, cmdTaker)
+	takerId, err := d.id("taker_order_id", msg.TakerOrderID)
+	if err != nil {
+		return nil, err
+	}
 
-		makerMuts := []book.OrderMutation{&book.OrderMatchMutation{
-			TradeID:  tradeId,
-			Size:     coinbaseSizeSatoshi,
-			WasMaker: true,
-			Time:     coinbaseTime,
-		}}
+	coinbaseSize, err := d.size("size", msg.Size)
+	if err != nil {
+		return nil, err
+	}
 
-		cmdMaker := &book.OrderBookMutationCommand{
-			ID:        makerId,
-			Mutations: makerMuts,
-		}
+	coinbasePrice, err := d.price("price", msg.Price)
+	if err != nil {
+		return nil, err
+	}
 
-		cmds = append(cmds, cmdMaker)
+	tradeId, err := d.number("trade_id", msg.TradeID)
+	if err != nil {
+		return nil, err
+	}
 
-		break
-	case MESSAGE_CHANGE:
-		coinbaseSize, err := strconv.ParseFloat(coinbaseEvent["new_size"].(string), 64)
-		if err != nil {
-			log.Fatalf("Failed to parse float size: %s", coinbaseEvent["new_size"].(string))
-			return nil
-		}
-		coinbaseSizeSatoshi := int64(coinbaseSize * float64(SATOSHI))
+	takerMuts := []book.OrderMutation{&book.OrderMatchMutation{
+		TradeID:  tradeId,
+		Price:    coinbasePrice,
+		Size:     coinbaseSize,
+		WasMaker: false,
+		MakerID:  makerId,
+		TakerID:  takerId,
+		Time:     coinbaseTime,
+	}}
+
+	cmdTaker := &book.OrderBookMutationCommand{
+		ID:        takerId,
+		Mutations: takerMuts,
+	}
 
-		muts := []book.OrderMutation{&book.OrderSizeMutation{
-			NewSize: coinbaseSizeSatoshi,
-			Time:    coinbaseTime,
-		}}
+	makerMuts := []book.OrderMutation{&book.OrderMatchMutation{
+		TradeID:  tradeId,
+		Price:    coinbasePrice,
+		Size:     coinbaseSize,
+		WasMaker: true,
+		MakerID:  makerId,
+		TakerID:  takerId,
+		Time:     coinbaseTime,
+	}}
+
+	cmdMaker := &book.OrderBookMutationCommand{
+		ID:        makerId,
+		Mutations: makerMuts,
+	}
 
-		cmds = append(cmds, &book.OrderBookMutationCommand{
-			ID:        book.OrderID(coinbaseEvent["order_id"].(string)),
-			Mutations: muts,
-		})
-		break
-	case MESSAGE_ERROR:
-		break
+	return []book.OrderBookCommand{cmdTaker, cmdMaker}, nil
+}
+
+func (d *realtimeDecoder) decodeChange(msg *ChangeMessage, coinbaseTime time.Time) ([]book.OrderBookCommand, error) {
+	orderId, err := d.id("order_id", msg.OrderID)
+	if err != nil {
+		return nil, err
 	}
 
-	return &CoinbaseOrderBookCommandBatch{
-		Commands: cmds,
-		Sequence: coinbaseSequenceNumber,
+	coinbaseSize, err := d.size("new_size", msg.NewSize)
+	if err != nil {
+		return nil, err
 	}
+
+	muts := []book.OrderMutation{&book.OrderSizeMutation{
+		NewSize: coinbaseSize,
+		Time:    coinbaseTime,
+	}}
+
+	return []book.OrderBookCommand{&book.OrderBookMutationCommand{
+		ID:        orderId,
+		Mutations: muts,
+	}}, nil
+}
+
+type restOrderBook struct {
+	Sequence json.Number `json:"sequence"`
+	Bids     [][]string  `json:"bids"`
+	Asks     [][]string  `json:"asks"`
 }
 
+// DecodeRESTOrderBook decodes a level 3 order book priced in cents and sized in satoshi.
 func DecodeRESTOrderBook(rawMsg []byte) (coinbaseSequenceNumber int64, batch *CoinbaseOrderBookCommandBatch, err error) {
-	var msg map[string]interface{}
+	return DEFAULT_PRODUCT.DecodeRESTOrderBook(rawMsg)
+}
 
-	decoder := json.NewDecoder(bytes.NewReader(rawMsg))
-	decoder.UseNumber()
+// DecodeRESTOrderBook decodes a level 3 order book for this product.
+func (p *ProductMetadata) DecodeRESTOrderBook(rawMsg []byte) (coinbaseSequenceNumber int64, batch *CoinbaseOrderBookCommandBatch, err error) {
+	var msg restOrderBook
 
-	err = decoder.Decode(&msg)
+	if err = json.Unmarshal(rawMsg, &msg); err != nil {
+		return 0, nil, fmt.Errorf("Error decoding order book: %s", err.Error())
+	}
 
-	coinbaseSequenceNumber, err = msg["sequence"].(json.Number).Int64()
+	coinbaseSequenceNumber, err = msg.Sequence.Int64()
 	if err != nil {
 		return 0, nil, fmt.Errorf("Error parsing sequence number: %s", err.Error())
 	}
 
-	bids := msg["bids"].([]interface{})
-	asks := msg["asks"].([]interface{})
-
-	decodeCoinbaseOrder := func(side string, coinbaseOrder []interface{}) (*book.OrderBookPlacementCommand, error) {
-		priceDollars, err := strconv.ParseFloat(coinbaseOrder[0].(string), 64)
+	decodeCoinbaseOrder := func(side string, coinbaseOrder []string) (*book.OrderBookPlacementCommand, error) {
+		if len(coinbaseOrder) != 3 {
+			return nil, fmt.Errorf("Expected order to have 3 fields, instead %d", len(coinbaseOrder))
+		}
+		price, err := p.ParsePrice(coinbaseOrder[0])
 		if err != nil {
-			return nil, fmt.Errorf("Error parsing order price %s: %s", coinbaseOrder[0].(string), err.Error())
+			return nil, fmt.Errorf("Error parsing price %s: %s", coinbaseOrder[0], err.Error())
 		}
-		priceCents := int64(priceDollars * 100)
-		sizeBitcoins, err := strconv.ParseFloat(coinbaseOrder[1].(string), 64)
+		size, err := p.ParseSize(coinbaseOrder[1])
 		if err != nil {
-			return nil, fmt.Errorf("Error parsing order size %s: %s", coinbaseOrder[1].(string), err.Error())
+			return nil, fmt.Errorf("Error parsing size %s: %s", coinbaseOrder[1], err.Error())
 		}
-		sizeSatoshi := int64(sizeBitcoins * SATOSHI)
-		orderId := coinbaseOrder[2].(string)
+		orderId := coinbaseOrder[2]
 		order := book.Order{
 			ID:    book.OrderID(orderId),
 			Side:  side,
-			Price: priceCents,
+			Price: price,
 		}
 		cmd := &book.OrderBookPlacementCommand{
-			Size:  sizeSatoshi,
+			Size:  size,
 			Order: order,
 			// Time intentionally left zeroed since Coinbase doesn't tell us when it was placed
 		}
 		return cmd, nil
 	}
 
-	cmds := make([]book.OrderBookCommand, 0, len(bids)+len(asks))
+	cmds := make([]book.OrderBookCommand, 0, len(msg.Bids)+len(msg.Asks))
 
-	for _, bid := range bids {
-		orderCmd, err := decodeCoinbaseOrder(book.SIDE_BUY, bid.([]interface{}))
+	for _, bid := range msg.Bids {
+		orderCmd, err := decodeCoinbaseOrder(book.SIDE_BUY, bid)
 		if err == nil {
 			cmds = append(cmds, orderCmd)
 		} else {
 			return 0, nil, err
 		}
 	}
-	for _, ask := range asks {
-		orderCmd, err := decodeCoinbaseOrder(book.SIDE_SELL, ask.([]interface{}))
+	for _, ask := range msg.Asks {
+		orderCmd, err := decodeCoinbaseOrder(book.SIDE_SELL, ask)
 		if err == nil {
 			cmds = append(cmds, orderCmd)
 		} else {
@@ -296,8 +552,9 @@ func DecodeRESTOrderBook(rawMsg []byte) (coinbaseSequenceNumber int64, batch *Co
 	}
 
 	batch = &CoinbaseOrderBookCommandBatch{
-		Commands: cmds,
-		Sequence: coinbaseSequenceNumber,
+		Commands:  cmds,
+		Sequence:  coinbaseSequenceNumber,
+		ProductID: p.ID,
 	}
 
 	return coinbaseSequenceNumber, batch, nil
@@ -4,8 +4,18 @@ import "time"
 import "testing"
 import "github.com/jacobgreenleaf/yeti/book"
 
+// scaledSize is a size in the minor units of the product that
+// DecodeRealtimeEvent decodes every message for.
+func scaledSize(t *testing.T, size string) int64 {
+	v, err := DEFAULT_PRODUCT.Scale.ParseSize(size)
+	if err != nil {
+		t.Fatalf("Unexpected error scaling size %s: %s", size, err.Error())
+	}
+	return v
+}
+
 func TestDecodingReceiveOrders(t *testing.T) {
-	batch := DecodeRealtimeEvent([]byte(`
+	batch, err := DecodeRealtimeEvent([]byte(`
 		{
 			"type": "received",
 			"time": "2014-11-07T08:19:27.028459Z",
@@ -18,6 +28,10 @@ func TestDecodingReceiveOrders(t *testing.T) {
 		}
 	`))
 
+	if err != nil {
+		t.Fatalf("Unexpected error decoding message: %s", err.Error())
+	}
+
 	if batch.Commands == nil || len(batch.Commands) != 1 {
 		t.Fatal("Expected an order book command, but got nil")
 	}
@@ -37,8 +51,8 @@ func TestDecodingReceiveOrders(t *testing.T) {
 	if cmd.Order.Side != book.SIDE_BUY {
 		t.Fatalf("Expected side to be %s, instead %s", book.SIDE_BUY, cmd.Order.Side)
 	}
-	if cmd.Size != int64(SATOSHI/10) {
-		t.Fatalf("Expected size to be 10000000 satoshis, instead %d", cmd.Size)
+	if cmd.Size != scaledSize(t, "0.10") {
+		t.Fatalf("Expected size to be 0.10 at the product's scale, instead %d", cmd.Size)
 	}
 	dt := time.Date(2014, 11, 7, 8, 19, 27, 28459000, time.UTC)
 	if !cmd.Time.Equal(dt) {
@@ -47,7 +61,7 @@ func TestDecodingReceiveOrders(t *testing.T) {
 }
 
 func TestDecodingOpenOrders(t *testing.T) {
-	batch := DecodeRealtimeEvent([]byte(`
+	batch, err := DecodeRealtimeEvent([]byte(`
 		{
 			"type": "open",
 			"time": "2014-11-07T08:19:27.028459Z",
@@ -60,6 +74,10 @@ func TestDecodingOpenOrders(t *testing.T) {
 		}
 	`))
 
+	if err != nil {
+		t.Fatalf("Unexpected error decoding message: %s", err.Error())
+	}
+
 	if batch.Commands == nil || len(batch.Commands) != 1 {
 		t.Fatal("Expected an order book command, but got nil")
 	}
@@ -96,8 +114,8 @@ func TestDecodingOpenOrders(t *testing.T) {
 			}
 		case *book.OrderSizeMutation:
 			hasSizeMutation = true
-			if mut.NewSize != int64(1*SATOSHI) {
-				t.Fatalf("Expected size to be 10000000 satoshis, instead %d", mut.NewSize)
+			if mut.NewSize != scaledSize(t, "1.00") {
+				t.Fatalf("Expected size to be 1.00 at the product's scale, instead %d", mut.NewSize)
 			}
 			if !mut.Time.Equal(dt) {
 				t.Fatalf("Expected size mutation to be at %s instead %s", dt, mut.Time)
@@ -118,7 +136,7 @@ func TestDecodingOpenOrders(t *testing.T) {
 }
 
 func TestDecodingDoneFilledOrders(t *testing.T) {
-	batch := DecodeRealtimeEvent([]byte(`
+	batch, err := DecodeRealtimeEvent([]byte(`
 		{
 			"type": "done",
 			"time": "2014-11-07T08:19:27.028459Z",
@@ -132,6 +150,10 @@ func TestDecodingDoneFilledOrders(t *testing.T) {
 		}
 	`))
 
+	if err != nil {
+		t.Fatalf("Unexpected error decoding message: %s", err.Error())
+	}
+
 	if batch.Commands == nil || len(batch.Commands) != 1 {
 		t.Fatal("Expected an order book command, but got nil")
 	}
@@ -189,7 +211,7 @@ func TestDecodingDoneFilledOrders(t *testing.T) {
 }
 
 func TestDecodingDoneCancelledOrders(t *testing.T) {
-	batch := DecodeRealtimeEvent([]byte(`
+	batch, err := DecodeRealtimeEvent([]byte(`
 		{
 			"type": "done",
 			"time": "2014-11-07T08:19:27.028459Z",
@@ -203,6 +225,10 @@ func TestDecodingDoneCancelledOrders(t *testing.T) {
 		}
 	`))
 
+	if err != nil {
+		t.Fatalf("Unexpected error decoding message: %s", err.Error())
+	}
+
 	if batch.Commands == nil || len(batch.Commands) != 1 {
 		t.Fatal("Expected an order book command, but got nil")
 	}
@@ -239,8 +265,8 @@ func TestDecodingDoneCancelledOrders(t *testing.T) {
 			}
 		case *book.OrderSizeMutation:
 			hasSizeMutation = true
-			if mut.NewSize != int64(SATOSHI/5) {
-				t.Fatalf("Expected size to be 20000 satoshis, instead %d", mut.NewSize)
+			if mut.NewSize != scaledSize(t, "0.2") {
+				t.Fatalf("Expected size to be 0.2 at the product's scale, instead %d", mut.NewSize)
 			}
 			if !mut.Time.Equal(dt) {
 				t.Fatalf("Expected size mutation to be at %s instead %s", dt, mut.Time)
@@ -260,7 +286,7 @@ func TestDecodingDoneCancelledOrders(t *testing.T) {
 }
 
 func TestDecodingMatchOrders(t *testing.T) {
-	batch := DecodeRealtimeEvent([]byte(`
+	batch, err := DecodeRealtimeEvent([]byte(`
 		{
 			"type": "match",
 			"trade_id": 10,
@@ -275,6 +301,10 @@ func TestDecodingMatchOrders(t *testing.T) {
 		}
 	`))
 
+	if err != nil {
+		t.Fatalf("Unexpected error decoding message: %s", err.Error())
+	}
+
 	if batch.Commands == nil {
 		t.Fatal("Expected two order book commands, but got nil")
 	}
@@ -349,10 +379,16 @@ func TestDecodingMatchOrders(t *testing.T) {
 	if takerMutation.MakerID != "ac928c66-ca53-498f-9c13-a110027a60e8" {
 		t.Fatalf("Expected taker maker id to be ac928c66-ca53-498f-9c13-a110027a60e8, instead %s", takerMutation.MakerID)
 	}
+	if takerMutation.Price != 40023 || makerMutation.Price != 40023 {
+		t.Fatalf("Expected both sides to trade at 40023 cents, instead %d and %d", takerMutation.Price, makerMutation.Price)
+	}
+	if makerMutation.TakerID != "132fb6ae-456b-4654-b4e0-d681ac05cea1" {
+		t.Fatalf("Expected maker taker id to be 132fb6ae-456b-4654-b4e0-d681ac05cea1, instead %s", makerMutation.TakerID)
+	}
 }
 
 func TestDecodingChangeOrders(t *testing.T) {
-	batch := DecodeRealtimeEvent([]byte(`
+	batch, err := DecodeRealtimeEvent([]byte(`
 		{
 			"type": "change",
 			"time": "2014-11-07T08:19:27.028459Z",
@@ -366,6 +402,10 @@ func TestDecodingChangeOrders(t *testing.T) {
 		}
 	`))
 
+	if err != nil {
+		t.Fatalf("Unexpected error decoding message: %s", err.Error())
+	}
+
 	if batch.Commands == nil {
 		t.Fatal("Expected order book command, but got nil")
 	}
@@ -403,7 +443,7 @@ func TestDecodingChangeOrders(t *testing.T) {
 }
 
 func TestDecodingError(t *testing.T) {
-	batch := DecodeRealtimeEvent([]byte(`
+	batch, err := DecodeRealtimeEvent([]byte(`
 		{
 			"type": "error",
 			"message": "error message"
@@ -413,4 +453,190 @@ func TestDecodingError(t *testing.T) {
 	if batch != nil {
 		t.Fatal("Expected error messages to return no commands")
 	}
+
+	if realtimeErr, ok := err.(*RealtimeError); !ok || realtimeErr.Message != "error message" {
+		t.Fatalf("Expected a *RealtimeError with the exchange's message, instead %v", err)
+	}
+}
+
+func TestDecodingReceivedMarketOrders(t *testing.T) {
+	batch, err := DecodeRealtimeEvent([]byte(`
+		{
+			"type": "received",
+			"time": "2014-11-07T08:19:27.028459Z",
+			"product_id": "BTC-USD",
+			"sequence": 10,
+			"order_id": "d50ec984-77a8-460a-b958-66f114b0de9b",
+			"funds": "3000.234",
+			"side": "buy",
+			"order_type": "market"
+		}
+	`))
+
+	if err != nil {
+		t.Fatalf("Unexpected error decoding market order: %s", err.Error())
+	}
+
+	cmd := batch.Commands[0].(*book.OrderBookPlacementCommand)
+
+	if cmd.Order.Price != 0 || cmd.Size != 0 {
+		t.Fatalf("Expected market order without price or size to be placed at zero, instead %s", cmd.Order.String())
+	}
+
+	batch, err = DecodeRealtimeEvent([]byte(`
+		{
+			"type": "done",
+			"time": "2014-11-07T08:19:27.028459Z",
+			"product_id": "BTC-USD",
+			"sequence": 11,
+			"order_id": "d50ec984-77a8-460a-b958-66f114b0de9b",
+			"reason": "filled",
+			"side": "buy",
+			"order_type": "market"
+		}
+	`))
+
+	if err != nil {
+		t.Fatalf("Unexpected error decoding done market order: %s", err.Error())
+	}
+
+	muts := batch.Commands[0].(*book.OrderBookMutationCommand).Mutations
+	if len(muts) != 1 {
+		t.Fatalf("Expected only a state mutation for a done market order, instead %d mutations", len(muts))
+	}
+}
+
+func TestDecodingMalformedMessages(t *testing.T) {
+	cases := []struct {
+		raw         string
+		messageType string
+		field       string
+	}{
+		{`{ "type": "received", "time": "2014-11-07T08:19:27.028459Z", "sequence": 10, "order_id": "aaaa", "size": "0.10", "side": "buy" }`, MESSAGE_RECEIVED, "price"},
+		{`{ "type": "open", "time": "2014-11-07T08:19:27.028459Z", "sequence": 10, "order_id": "aaaa", "remaining_size": "abc", "side": "buy" }`, MESSAGE_OPEN, "remaining_size"},
+		{`{ "type": "match", "time": "2014-11-07T08:19:27.028459Z", "sequence": 10, "maker_order_id": "aaaa", "size": "1.0" }`, MESSAGE_MATCH, "taker_order_id"},
+		{`{ "type": "change", "time": "yesterday", "sequence": 10, "order_id": "aaaa", "new_size": "1.0" }`, MESSAGE_CHANGE, "time"},
+		{`{ "type": "done", "time": "2014-11-07T08:19:27.028459Z", "order_id": "aaaa" }`, MESSAGE_DONE, "sequence"},
+		{`{ "type": "done", "time": "2014-11-07T08:19:27.028459Z", "sequence": 10, "order_id": 5 }`, MESSAGE_DONE, "order_id"},
+	}
+
+	for _, c := range cases {
+		batch, err := DecodeRealtimeEvent([]byte(c.raw))
+
+		if batch != nil {
+			t.Fatalf("Expected no batch for malformed message %s", c.raw)
+		}
+
+		decodeErr, ok := err.(*RealtimeDecodeError)
+		if !ok {
+			t.Fatalf("Expected a *RealtimeDecodeError for %s, instead %v", c.raw, err)
+		}
+		if decodeErr.MessageType != c.messageType || decodeErr.Field != c.field {
+			t.Fatalf("Expected error in %s field %s, instead %s field %s", c.messageType, c.field, decodeErr.MessageType, decodeErr.Field)
+		}
+		if string(decodeErr.Raw) != c.raw {
+			t.Fatalf("Expected error to carry the raw message, instead %s", decodeErr.Raw)
+		}
+	}
+}
+
+func TestDecodingUntrackedMessages(t *testing.T) {
+	batch, err := DecodeRealtimeEvent([]byte(`{ "type": "heartbeat", "sequence": 90, "last_trade_id": 20, "product_id": "BTC-USD" }`))
+
+	if err != nil {
+		t.Fatalf("Unexpected error decoding heartbeat: %s", err.Error())
+	}
+	if batch.Sequence != 90 || len(batch.Commands) != 0 {
+		t.Fatalf("Expected an empty batch at sequence 90, instead %d commands at %d", len(batch.Commands), batch.Sequence)
+	}
+}
+
+func TestDecodingSubscriptionAcknowledgements(t *testing.T) {
+	batch, err := DecodeRealtimeEvent([]byte(`{ "type": "subscriptions", "channels": [ { "name": "full", "product_ids": [ "BTC-USD" ] } ] }`))
+
+	if err != nil {
+		t.Fatalf("Unexpected error decoding subscriptions: %s", err.Error())
+	}
+	if batch != nil {
+		t.Fatalf("Expected no batch for a message outside the sequence, instead %d commands at %d", len(batch.Commands), batch.Sequence)
+	}
+}
+
+func TestSkippingUndecodableFeedMessages(t *testing.T) {
+	feed := &OrderBookCommandFeed{Feed: make(chan *CoinbaseOrderBookCommandBatch, 10)}
+
+	feed.decode([]byte(`{ "type": "open", "sequence": 10 }`))
+	feed.decode([]byte(`{ "type": "heartbeat", "sequence": 11 }`))
+	feed.decode([]byte(`{ "type": "subscriptions", "channels": [ { "name": "full", "product_ids": [ "BTC-USD" ] } ] }`))
+
+	if feed.DecodeErrors != 1 {
+		t.Fatalf("Expected one decode error, instead %d", feed.DecodeErrors)
+	}
+	if len(feed.Feed) != 1 {
+		t.Fatalf("Expected one batch on the feed, instead %d", len(feed.Feed))
+	}
+}
+
+func TestDecodingExactPrices(t *testing.T) {
+	batch, err := DecodeRealtimeEvent([]byte(`
+		{
+			"type": "received",
+			"time": "2014-11-07T08:19:27.028459Z",
+			"product_id": "BTC-USD",
+			"sequence": 10,
+			"order_id": "d50ec984-77a8-460a-b958-66f114b0de9b",
+			"size": "0.29",
+			"price": "0.29",
+			"side": "buy"
+		}
+	`))
+
+	if err != nil {
+		t.Fatalf("Unexpected error decoding message: %s", err.Error())
+	}
+
+	cmd := batch.Commands[0].(*book.OrderBookPlacementCommand)
+
+	if cmd.Order.Price != 29 {
+		t.Fatalf("Expected price to be 29 cents, instead %d", cmd.Order.Price)
+	}
+	if cmd.Size != 29000000 {
+		t.Fatalf("Expected size to be 29000000 satoshis, instead %d", cmd.Size)
+	}
+
+	_, err = DecodeRealtimeEvent([]byte(`
+		{
+			"type": "change",
+			"time": "2014-11-07T08:19:27.028459Z",
+			"sequence": 80,
+			"order_id": "ac928c66-ca53-498f-9c13-a110027a60e8",
+			"new_size": "5.235120001",
+			"price": "400.23",
+			"side": "sell"
+		}
+	`))
+
+	if decodeErr, ok := err.(*RealtimeDecodeError); !ok || decodeErr.Field != "new_size" {
+		t.Fatalf("Expected a size with more precision than a satoshi to be rejected, instead %v", err)
+	}
+}
+
+func TestDecodingProductIDs(t *testing.T) {
+	batch, err := DecodeRealtimeEvent([]byte(`{ "type": "heartbeat", "sequence": 90, "product_id": "ETH-USD" }`))
+
+	if err != nil {
+		t.Fatalf("Unexpected error decoding heartbeat: %s", err.Error())
+	}
+	if batch.ProductID != "ETH-USD" {
+		t.Fatalf("Expected batch to carry product ETH-USD, instead %s", batch.ProductID)
+	}
+
+	_, batch, err = DecodeRESTOrderBook([]byte(`{ "sequence": 3, "bids": [], "asks": [] }`))
+
+	if err != nil {
+		t.Fatalf("Unexpected error decoding order book: %s", err.Error())
+	}
+	if batch.ProductID != DEFAULT_PRODUCT.ID {
+		t.Fatalf("Expected snapshot to carry product %s, instead %s", DEFAULT_PRODUCT.ID, batch.ProductID)
+	}
 }
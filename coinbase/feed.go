@@ -0,0 +1,113 @@
+package coinbase
+
+import "fmt"
+import "sync"
+
+// FeedSource is anything that sends realtime order book command batches: the
+// websocket feed, a replay of a recording, or a fake.
+//
+// Subscribe asks for the batches of some products, which are decoded using
+// their metadata, and starts the source if it hasn't started yet. Batches,
+// Errors and States are closed once the source is done, either because it ran
+// out or because it was closed. Errors are informational; the source carries
+// on after sending one where it can. States gets the FEED_STATE_ the source
+// moves into whenever it changes, so that books built from it know when
+// they've missed batches.
+type FeedSource interface {
+	Subscribe(products ...*ProductMetadata) error
+	Batches() <-chan *CoinbaseOrderBookCommandBatch
+	Errors() <-chan error
+	States() <-chan string
+	Close() error
+}
+
+// FakeFeed is a FeedSource that sends whatever it's told to, for tests.
+type FakeFeed struct {
+	Subscribed []*ProductMetadata
+
+	lock    sync.Mutex
+	closed  bool
+	batches chan *CoinbaseOrderBookCommandBatch
+	errors  chan error
+	states  chan string
+}
+
+func NewFakeFeed(bufLen int) *FakeFeed {
+	return &FakeFeed{
+		Subscribed: make([]*ProductMetadata, 0),
+		batches:    make(chan *CoinbaseOrderBookCommandBatch, bufLen),
+		errors:     make(chan error, bufLen),
+		states:     make(chan string, bufLen),
+	}
+}
+
+func (f *FakeFeed) String() string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return fmt.Sprintf("<FakeFeed subscribed to %d products; closed=%t>", len(f.Subscribed), f.closed)
+}
+
+func (f *FakeFeed) Subscribe(products ...*ProductMetadata) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.Subscribed = append(f.Subscribed, products...)
+	return nil
+}
+
+func (f *FakeFeed) Batches() <-chan *CoinbaseOrderBookCommandBatch {
+	return f.batches
+}
+
+func (f *FakeFeed) Errors() <-chan error {
+	return f.errors
+}
+
+func (f *FakeFeed) States() <-chan string {
+	return f.states
+}
+
+// Send sends a batch as if it came from the exchange, blocking if the
+// buffer is full. Batches sent after Close are dropped.
+func (f *FakeFeed) Send(batch *CoinbaseOrderBookCommandBatch) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.closed {
+		f.batches <- batch
+	}
+}
+
+// Fail sends an error, which is dropped after Close like Send's batches.
+func (f *FakeFeed) Fail(err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.closed {
+		f.errors <- err
+	}
+}
+
+// SetState sends a change of state, which is dropped after Close like Send's
+// batches.
+func (f *FakeFeed) SetState(state string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.closed {
+		f.states <- state
+	}
+}
+
+func (f *FakeFeed) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if !f.closed {
+		f.closed = true
+		close(f.batches)
+		close(f.errors)
+		close(f.states)
+	}
+
+	return nil
+}
@@ -0,0 +1,263 @@
+package coinbase
+
+import "context"
+import "errors"
+import "github.com/gorilla/websocket"
+import "io/ioutil"
+import "net/http"
+import "os"
+import "testing"
+import "time"
+import "github.com/jacobgreenleaf/yeti/book"
+
+func newTestSnapshotServer(t *testing.T) (*RESTClient, func()) {
+	return newTestRESTServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/products/BTC-USD":
+			w.Write([]byte(`{ "id": "BTC-USD", "base_currency": "BTC", "quote_currency": "USD", "quote_increment": "0.01" }`))
+		case "/products/BTC-USD/book":
+			w.Write([]byte(`{ "sequence": 10, "bids": [ [ "1.00", "0.01", "aaaa" ] ], "asks": [] }`))
+		default:
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+	})
+}
+
+func newTestInMemoryBook(scale book.Scale) *book.InMemoryOrderBook {
+	return book.NewScaledInMemoryOrderBook(scale)
+}
+
+// maintain runs MaintainForever until the feed is done or ctx is.
+func maintain(ctx context.Context, r *OrderBookRegistry) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		r.MaintainForever(ctx)
+		close(done)
+	}()
+	return done
+}
+
+func TestBootstrappingFromAFakeFeed(t *testing.T) {
+	client, closeServer := newTestSnapshotServer(t)
+	defer closeServer()
+
+	feed := NewFakeFeed(10)
+
+	r, err := BootstrapFrom(client, feed, []string{"BTC-USD"}, newTestInMemoryBook)
+	if err != nil {
+		t.Fatalf("Unexpected error bootstrapping: %s", err.Error())
+	}
+
+	if len(feed.Subscribed) != 1 || feed.Subscribed[0].ID != "BTC-USD" {
+		t.Fatalf("Expected to subscribe to BTC-USD, instead %s", feed)
+	}
+
+	done := maintain(context.Background(), r)
+
+	batch := voidBatch(11, "aaaa")
+	batch.ProductID = "BTC-USD"
+	feed.Send(batch)
+	feed.Fail(errors.New("Something went wrong."))
+	feed.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected MaintainForever to return once the feed was closed")
+	}
+
+	b := r.Get("BTC-USD")
+	if b.Sequence != 11 {
+		t.Fatalf("Expected the book to reach sequence 11, instead %d", b.Sequence)
+	}
+	if order, _ := b.Book.GetOrder("aaaa"); order.State != book.STATE_VOID {
+		t.Fatalf("Expected aaaa to be cancelled, instead %s", order)
+	}
+}
+
+func TestBootstrappingFromAReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recordTestFeed(t, dir, time.Unix(1000, 0))
+	recordTestSnapshots(t, dir, time.Unix(1000, 0))
+
+	reader, err := OpenRecording(dir, "feed")
+	if err != nil {
+		t.Fatalf("Unexpected error opening recording: %s", err.Error())
+	}
+
+	// Everything the replay needs comes from the recording
+	var feed FeedSource = NewReplayFeed(context.Background(), reader, 10)
+
+	r, err := BootstrapFrom(OpenSnapshots(dir, "feed"), feed, []string{"BTC-USD"}, newTestInMemoryBook)
+	if err != nil {
+		t.Fatalf("Unexpected error bootstrapping: %s", err.Error())
+	}
+
+	select {
+	case <-maintain(context.Background(), r):
+	case <-time.After(time.Second):
+		t.Fatal("Expected MaintainForever to return at the end of the replay")
+	}
+
+	b := r.Get("BTC-USD")
+	if b.Sequence != 13 {
+		t.Fatalf("Expected the book to reach sequence 13, instead %d", b.Sequence)
+	}
+	if order, _ := b.Book.GetOrder("bbbb"); order.State != book.STATE_OPEN {
+		t.Fatalf("Expected bbbb to be open, instead %s", order)
+	}
+}
+
+func TestClosingFeedsBeforeStarting(t *testing.T) {
+	feeds := []FeedSource{
+		NewFakeFeed(0),
+		NewReplayFeed(context.Background(), NewRecordingReader(nil), 0),
+	}
+
+	for _, feed := range feeds {
+		feed.Close()
+		feed.Close()
+
+		if _, ok := <-feed.Batches(); ok {
+			t.Fatalf("Expected no batches from %s once closed", feed)
+		}
+		if _, ok := <-feed.Errors(); ok {
+			t.Fatalf("Expected no errors from %s once closed", feed)
+		}
+	}
+}
+
+func TestResyncingWhenTheFeedComesBack(t *testing.T) {
+	client, closeServer := newTestSnapshotServer(t)
+	defer closeServer()
+
+	feed := NewFakeFeed(10)
+
+	r, err := BootstrapFrom(client, feed, []string{"BTC-USD"}, newTestInMemoryBook)
+	if err != nil {
+		t.Fatalf("Unexpected error bootstrapping: %s", err.Error())
+	}
+
+	b := r.Get("BTC-USD")
+
+	r.feedStateChanged(FEED_STATE_STALE)
+	if !b.Book.Stale() {
+		t.Fatal("Expected the book to be stale while the feed is")
+	}
+
+	r.feedStateChanged(FEED_STATE_CONNECTING)
+	r.feedStateChanged(FEED_STATE_LIVE)
+	if b.Book.Stale() || b.Resyncs != 1 {
+		t.Fatalf("Expected the book to be rebuilt once the feed was live, instead stale=%t after %d resyncs", b.Book.Stale(), b.Resyncs)
+	}
+
+	// Live again without having been stale doesn't rebuild anything
+	r.feedStateChanged(FEED_STATE_LIVE)
+	if b.Resyncs != 1 {
+		t.Fatalf("Expected no more resyncs, instead %d", b.Resyncs)
+	}
+}
+
+func TestWaitingForTheFeedBeforeResyncing(t *testing.T) {
+	client, closeServer := newTestSnapshotServer(t)
+	defer closeServer()
+
+	r, err := BootstrapFrom(client, NewFakeFeed(10), []string{"BTC-USD"}, newTestInMemoryBook)
+	if err != nil {
+		t.Fatalf("Unexpected error bootstrapping: %s", err.Error())
+	}
+
+	b := r.Get("BTC-USD")
+
+	r.feedStateChanged(FEED_STATE_STALE)
+
+	// Sent before the connection dropped
+	batch := voidBatch(11, "aaaa")
+	batch.ProductID = "BTC-USD"
+	r.route(batch)
+
+	if !b.Book.Stale() || b.Resyncs != 0 {
+		t.Fatalf("Expected the book to stay stale until the feed is live, instead stale=%t after %d resyncs", b.Book.Stale(), b.Resyncs)
+	}
+
+	r.feedStateChanged(FEED_STATE_CONNECTING)
+	r.feedStateChanged(FEED_STATE_LIVE)
+
+	if b.Book.Stale() || b.Resyncs != 1 {
+		t.Fatalf("Expected the book to be rebuilt once the feed was live, instead stale=%t after %d resyncs", b.Book.Stale(), b.Resyncs)
+	}
+	if b.Sequence != 11 {
+		t.Fatalf("Expected the buffered batch to be applied past the snapshot, instead sequence %d", b.Sequence)
+	}
+}
+
+func TestDrainingTheFeedOnShutdown(t *testing.T) {
+	client, closeServer := newTestSnapshotServer(t)
+	defer closeServer()
+
+	feed := NewFakeFeed(10)
+
+	r, err := BootstrapFrom(client, feed, []string{"BTC-USD"}, newTestInMemoryBook)
+	if err != nil {
+		t.Fatalf("Unexpected error bootstrapping: %s", err.Error())
+	}
+
+	// Already sent when we're asked to stop
+	batch := voidBatch(11, "aaaa")
+	batch.ProductID = "BTC-USD"
+	feed.Send(batch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	select {
+	case <-maintain(ctx, r):
+	case <-time.After(time.Second):
+		t.Fatal("Expected MaintainForever to return once cancelled")
+	}
+
+	if r.Get("BTC-USD").Sequence != 11 {
+		t.Fatalf("Expected batches sent before cancelling to be applied, instead sequence %d", r.Get("BTC-USD").Sequence)
+	}
+	if _, ok := <-feed.Batches(); ok {
+		t.Fatal("Expected the feed to be closed")
+	}
+}
+
+func TestCancellingFeeds(t *testing.T) {
+	url, _, closeServer := newTestWebsocketServer(t, func(n int, socket *websocket.Conn) {
+		drain(socket)
+	})
+	defer closeServer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	realtime, err := connectRealtimeFeed(ctx, url, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error connecting: %s", err.Error())
+	}
+	realtime.Subscribe(DEFAULT_PRODUCT)
+
+	feeds := []FeedSource{
+		realtime,
+		NewReplayFeed(ctx, NewRecordingReader(nil), 0),
+	}
+
+	cancel()
+
+	for _, feed := range feeds {
+		select {
+		case _, ok := <-feed.Batches():
+			if ok {
+				t.Fatalf("Expected no batches from %T once cancelled", feed)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %T to close once cancelled", feed)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package coinbase
+
+import "errors"
+import "fmt"
+import "strings"
+import "sync"
+import "github.com/jacobgreenleaf/yeti/book"
+
+var (
+	errPriceNotOnIncrement = errors.New("Price is not a multiple of the product's quote increment.")
+	errSizeNotOnIncrement  = errors.New("Size is not a multiple of the product's base increment.")
+	errSizeOutOfRange      = errors.New("Size is outside the product's minimum and maximum size.")
+	errUnknownProduct      = errors.New("Product is unknown.")
+)
+
+// ProductMetadata is a product with its increments parsed into the integer
+// minor units that prices and sizes use in the order book.
+type ProductMetadata struct {
+	ID            string
+	BaseCurrency  string
+	QuoteCurrency string
+	Scale         book.Scale
+
+	// The rest are in the minor units described by Scale
+	QuoteIncrement int64
+	BaseIncrement  int64
+	BaseMinSize    int64
+	BaseMaxSize    int64
+}
+
+// DEFAULT_PRODUCT describes BTC-USD, priced in cents and sized in satoshi.
+var DEFAULT_PRODUCT = &ProductMetadata{
+	ID:             "BTC-USD",
+	BaseCurrency:   "BTC",
+	QuoteCurrency:  "USD",
+	Scale:          book.DEFAULT_SCALE,
+	QuoteIncrement: 1,
+	BaseIncrement:  1,
+}
+
+func (p *ProductMetadata) String() string {
+	return fmt.Sprintf("<ProductMetadata %s; quote increment %s; base increment %s>", p.ID, p.Scale.FormatPrice(p.QuoteIncrement), p.Scale.FormatSize(p.BaseIncrement))
+}
+
+// decimalPlaces counts the significant decimal places of an increment like
+// "0.01000000", which has two.
+func decimalPlaces(increment string) int {
+	dot := strings.IndexByte(increment, '.')
+	if dot < 0 {
+		return 0
+	}
+	return len(strings.TrimRight(increment[dot+1:], "0"))
+}
+
+// Metadata derives the product's scale from its increments. Products that
+// don't report a base increment are assumed to be sized in satoshi-like units.
+func (p *Product) Metadata() (*ProductMetadata, error) {
+	scale := book.Scale{
+		PriceDecimals: decimalPlaces(p.QuoteIncrement),
+		SizeDecimals:  book.DEFAULT_SCALE.SizeDecimals,
+	}
+
+	if p.BaseIncrement != "" {
+		scale.SizeDecimals = decimalPlaces(p.BaseIncrement)
+	}
+
+	meta := &ProductMetadata{
+		ID:             p.ID,
+		BaseCurrency:   p.BaseCurrency,
+		QuoteCurrency:  p.QuoteCurrency,
+		Scale:          scale,
+		BaseIncrement:  1,
+		QuoteIncrement: 1,
+	}
+
+	var err error
+
+	if p.QuoteIncrement != "" {
+		if meta.QuoteIncrement, err = scale.ParsePrice(p.QuoteIncrement); err != nil {
+			return nil, fmt.Errorf("Error parsing quote increment %s: %s", p.QuoteIncrement, err.Error())
+		}
+	}
+	if p.BaseIncrement != "" {
+		if meta.BaseIncrement, err = scale.ParseSize(p.BaseIncrement); err != nil {
+			return nil, fmt.Errorf("Error parsing base increment %s: %s", p.BaseIncrement, err.Error())
+		}
+	}
+	if p.BaseMinSize != "" {
+		if meta.BaseMinSize, err = scale.ParseSize(p.BaseMinSize); err != nil {
+			return nil, fmt.Errorf("Error parsing minimum size %s: %s", p.BaseMinSize, err.Error())
+		}
+	}
+	if p.BaseMaxSize != "" {
+		if meta.BaseMaxSize, err = scale.ParseSize(p.BaseMaxSize); err != nil {
+			return nil, fmt.Errorf("Error parsing maximum size %s: %s", p.BaseMaxSize, err.Error())
+		}
+	}
+
+	return meta, nil
+}
+
+// ParsePrice parses a price, rejecting anything finer than the quote increment.
+func (p *ProductMetadata) ParsePrice(price string) (int64, error) {
+	v, err := p.Scale.ParsePrice(price)
+	if err != nil {
+		return 0, err
+	}
+	if p.QuoteIncrement > 0 && v%p.QuoteIncrement != 0 {
+		return 0, errPriceNotOnIncrement
+	}
+	return v, nil
+}
+
+// ParseSize parses a size, rejecting anything finer than the base increment.
+func (p *ProductMetadata) ParseSize(size string) (int64, error) {
+	v, err := p.Scale.ParseSize(size)
+	if err != nil {
+		return 0, err
+	}
+	if p.BaseIncrement > 0 && v%p.BaseIncrement != 0 {
+		return 0, errSizeNotOnIncrement
+	}
+	return v, nil
+}
+
+// validateOrder checks a price and size we're about to send to the exchange.
+// A zero price is not checked, for market orders.
+func (p *ProductMetadata) validateOrder(price, size int64) error {
+	if p.QuoteIncrement > 0 && price%p.QuoteIncrement != 0 {
+		return errPriceNotOnIncrement
+	}
+	if p.BaseIncrement > 0 && size%p.BaseIncrement != 0 {
+		return errSizeNotOnIncrement
+	}
+	if size < p.BaseMinSize || (p.BaseMaxSize > 0 && size > p.BaseMaxSize) {
+		return errSizeOutOfRange
+	}
+	return nil
+}
+
+// ProductRegistry holds the metadata of the products we know about. It is
+// safe for concurrent use.
+type ProductRegistry struct {
+	lock     sync.RWMutex
+	products map[string]*ProductMetadata
+}
+
+func NewProductRegistry() *ProductRegistry {
+	return &ProductRegistry{products: make(map[string]*ProductMetadata)}
+}
+
+func (r *ProductRegistry) Add(p *ProductMetadata) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.products[p.ID] = p
+}
+
+// Get returns the metadata for a product, and whether we know about it at
+// all. A nil registry knows about nothing.
+func (r *ProductRegistry) Get(id string) (*ProductMetadata, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	p, ok := r.products[id]
+	return p, ok
+}
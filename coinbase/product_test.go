@@ -0,0 +1,139 @@
+package coinbase
+
+import "net/http"
+import "testing"
+import "github.com/jacobgreenleaf/yeti/book"
+
+func TestDerivingProductMetadata(t *testing.T) {
+	product := &Product{
+		ID:             "ETH-BTC",
+		BaseCurrency:   "ETH",
+		QuoteCurrency:  "BTC",
+		BaseMinSize:    "0.01000000",
+		BaseMaxSize:    "1000000.00000000",
+		BaseIncrement:  "0.00000001",
+		QuoteIncrement: "0.00001000",
+	}
+
+	meta, err := product.Metadata()
+	if err != nil {
+		t.Fatalf("Unexpected error deriving metadata: %s", err.Error())
+	}
+
+	if meta.Scale.PriceDecimals != 5 || meta.Scale.SizeDecimals != 8 {
+		t.Fatalf("Unexpected scale %s", meta.Scale)
+	}
+	if meta.QuoteIncrement != 1 || meta.BaseIncrement != 1 || meta.BaseMinSize != 1000000 || meta.BaseMaxSize != 100000000000000 {
+		t.Fatalf("Unexpected increments %s", meta)
+	}
+
+	product = &Product{ID: "BTC-EUR", QuoteIncrement: "0.05", BaseMinSize: "0.01"}
+
+	meta, err = product.Metadata()
+	if err != nil {
+		t.Fatalf("Unexpected error deriving metadata: %s", err.Error())
+	}
+
+	if meta.Scale != book.DEFAULT_SCALE || meta.QuoteIncrement != 5 {
+		t.Fatalf("Unexpected scale %s with quote increment %d", meta.Scale, meta.QuoteIncrement)
+	}
+	if _, err = meta.ParsePrice("100.05"); err != nil {
+		t.Fatalf("Unexpected error parsing price on the increment: %s", err.Error())
+	}
+	if _, err = meta.ParsePrice("100.01"); err != errPriceNotOnIncrement {
+		t.Fatalf("Expected price off the increment to be rejected, instead %v", err)
+	}
+	if _, err = meta.NewLimitOrderRequest(book.SIDE_BUY, 10005, 100000); err != errSizeOutOfRange {
+		t.Fatalf("Expected order below the minimum size to be rejected, instead %v", err)
+	}
+}
+
+func TestDecodingScaledProducts(t *testing.T) {
+	products := NewProductRegistry()
+	products.Add(&ProductMetadata{
+		ID:             "ETH-BTC",
+		Scale:          book.Scale{PriceDecimals: 5, SizeDecimals: 8},
+		QuoteIncrement: 1,
+		BaseIncrement:  1,
+	})
+
+	batch, err := products.DecodeRealtimeEvent([]byte(`
+		{
+			"type": "received",
+			"time": "2014-11-07T08:19:27.028459Z",
+			"product_id": "ETH-BTC",
+			"sequence": 10,
+			"order_id": "d50ec984-77a8-460a-b958-66f114b0de9b",
+			"size": "1.5",
+			"price": "0.03012",
+			"side": "buy"
+		}
+	`))
+
+	if err != nil {
+		t.Fatalf("Unexpected error decoding message: %s", err.Error())
+	}
+
+	cmd := batch.Commands[0].(*book.OrderBookPlacementCommand)
+	if cmd.Order.Price != 3012 || cmd.Size != 150000000 {
+		t.Fatalf("Expected price 3012 and size 150000000, instead %d and %d", cmd.Order.Price, cmd.Size)
+	}
+
+	// The same price can't be represented in cents
+	products.Add(DEFAULT_PRODUCT)
+	_, err = products.DecodeRealtimeEvent([]byte(`
+		{
+			"type": "received",
+			"time": "2014-11-07T08:19:27.028459Z",
+			"product_id": "BTC-USD",
+			"sequence": 10,
+			"order_id": "d50ec984-77a8-460a-b958-66f114b0de9b",
+			"size": "1.5",
+			"price": "0.03012",
+			"side": "buy"
+		}
+	`))
+
+	if decodeErr, ok := err.(*RealtimeDecodeError); !ok || decodeErr.Field != "price" {
+		t.Fatalf("Expected a sub-cent BTC-USD price to be rejected, instead %v", err)
+	}
+
+	// Nor can anything be scaled for a product we know nothing about
+	_, err = products.DecodeRealtimeEvent([]byte(`{ "type": "open", "time": "2014-11-07T08:19:27.028459Z", "product_id": "LTC-USD", "sequence": 10, "order_id": "aaaa", "remaining_size": "1.5", "price": "3.01", "side": "buy" }`))
+
+	if decodeErr, ok := err.(*RealtimeDecodeError); !ok || decodeErr.Field != "product_id" {
+		t.Fatalf("Expected an unknown product to be rejected, instead %v", err)
+	}
+}
+
+func TestFetchingProductMetadata(t *testing.T) {
+	client, closeServer := newTestRESTServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/products/ETH-BTC":
+			w.Write([]byte(`{ "id": "ETH-BTC", "base_currency": "ETH", "quote_currency": "BTC", "base_min_size": "0.01", "base_max_size": "1000000", "quote_increment": "0.00001" }`))
+		case "/products/ETH-BTC/book":
+			w.Write([]byte(`{ "sequence": 3, "bids": [ [ "0.03012", "1.5", "aaaa" ] ], "asks": [] }`))
+		default:
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+	})
+	defer closeServer()
+
+	meta, err := client.GetProduct("ETH-BTC")
+	if err != nil {
+		t.Fatalf("Unexpected error fetching product: %s", err.Error())
+	}
+	if meta.Scale.PriceDecimals != 5 {
+		t.Fatalf("Expected 5 price decimals, instead %d", meta.Scale.PriceDecimals)
+	}
+
+	_, batch, err := client.GetOrderBook("ETH-BTC")
+	if err != nil {
+		t.Fatalf("Unexpected error fetching order book: %s", err.Error())
+	}
+
+	cmd := batch.Commands[0].(*book.OrderBookPlacementCommand)
+	if cmd.Order.Price != 3012 {
+		t.Fatalf("Expected order book to be decoded with the product's scale, instead price %d", cmd.Order.Price)
+	}
+}
@@ -0,0 +1,184 @@
+package coinbase
+
+import "compress/gzip"
+import "encoding/json"
+import "fmt"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "sync"
+import "sync/atomic"
+import "time"
+
+const (
+	RECORDING_EXTENSION = ".ndjson.gz"
+
+	// Segment names sort in the order they were started
+	RECORDING_TIME_FORMAT = "20060102T150405.000000000Z"
+
+	// REST responses saved alongside the feed, so that a replay can be
+	// bootstrapped without the REST API
+	SNAPSHOT_EXTENSION = ".json"
+	SNAPSHOT_PRODUCT   = "product"
+	SNAPSHOT_BOOK      = "book"
+)
+
+// RecordedMessage is one line of a recording: a frame from the realtime feed
+// exactly as it was received, and when we received it. Message is written in
+// base64 so that the frame comes back byte for byte, even if it isn't JSON.
+type RecordedMessage struct {
+	Received time.Time `json:"received"`
+	Message  []byte    `json:"message"`
+}
+
+// FeedRecorder writes raw feed messages to gzipped newline delimited JSON
+// files in Dir. A new segment is started once the current one has had
+// MaxSegmentBytes of uncompressed messages written to it, or has been open
+// for MaxSegmentAge of receive time; zero means no limit.
+//
+// Segments are named after Prefix and the receive time of their first
+// message, so listing Dir in order lists the recording in order.
+type FeedRecorder struct {
+	Dir             string
+	Prefix          string
+	MaxSegmentBytes int64
+	MaxSegmentAge   time.Duration
+
+	// Records and Segments count what has been written so far. Read them
+	// with atomic.LoadInt64.
+	Records  int64
+	Segments int64
+
+	lock    sync.Mutex
+	file    *os.File
+	gz      *gzip.Writer
+	opened  time.Time
+	written int64
+}
+
+func NewFeedRecorder(dir, prefix string, maxSegmentBytes int64, maxSegmentAge time.Duration) *FeedRecorder {
+	return &FeedRecorder{
+		Dir:             dir,
+		Prefix:          prefix,
+		MaxSegmentBytes: maxSegmentBytes,
+		MaxSegmentAge:   maxSegmentAge,
+	}
+}
+
+func (r *FeedRecorder) String() string {
+	return fmt.Sprintf("<FeedRecorder to %s of %d records in %d segments>", filepath.Join(r.Dir, r.Prefix), atomic.LoadInt64(&r.Records), atomic.LoadInt64(&r.Segments))
+}
+
+// Record appends a raw message received at received to the current segment,
+// starting a new one first if the current one is full or too old.
+func (r *FeedRecorder) Record(received time.Time, raw []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	line, err := json.Marshal(&RecordedMessage{Received: received, Message: raw})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if r.file != nil && r.full(received) {
+		if err := r.closeSegment(); err != nil {
+			return err
+		}
+	}
+
+	if r.file == nil {
+		if err := r.openSegment(received); err != nil {
+			return err
+		}
+	}
+
+	if _, err := r.gz.Write(line); err != nil {
+		return err
+	}
+
+	r.written += int64(len(line))
+	atomic.AddInt64(&r.Records, 1)
+
+	return nil
+}
+
+// RecordSnapshot saves a raw REST response about product fetched at
+// received, like its metadata (SNAPSHOT_PRODUCT) or its level 3 order book
+// (SNAPSHOT_BOOK), to a file of its own next to the segments. OpenSnapshots
+// serves them back.
+func (r *FeedRecorder) RecordSnapshot(kind, product string, received time.Time, raw []byte) error {
+	name := fmt.Sprintf("%s-%s-%s-%s%s", r.Prefix, kind, product, received.UTC().Format(RECORDING_TIME_FORMAT), SNAPSHOT_EXTENSION)
+
+	// Written in full before it has a name OpenSnapshots would find
+	path := filepath.Join(r.Dir, name)
+	if err := ioutil.WriteFile(path+".tmp", raw, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+func (r *FeedRecorder) full(received time.Time) bool {
+	if r.MaxSegmentBytes > 0 && r.written >= r.MaxSegmentBytes {
+		return true
+	}
+	if r.MaxSegmentAge > 0 && received.Sub(r.opened) >= r.MaxSegmentAge {
+		return true
+	}
+	return false
+}
+
+func (r *FeedRecorder) openSegment(received time.Time) error {
+	name := fmt.Sprintf("%s-%s%s", r.Prefix, received.UTC().Format(RECORDING_TIME_FORMAT), RECORDING_EXTENSION)
+
+	file, err := os.OpenFile(filepath.Join(r.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	r.file = file
+	r.gz = gzip.NewWriter(file)
+	r.opened = received
+	r.written = 0
+	atomic.AddInt64(&r.Segments, 1)
+
+	return nil
+}
+
+func (r *FeedRecorder) closeSegment() error {
+	file, gz := r.file, r.gz
+	r.file, r.gz = nil, nil
+
+	if err := gz.Close(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Flush pushes everything recorded so far out to the current segment, so
+// that it is readable even if the segment is never closed.
+func (r *FeedRecorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.gz == nil {
+		return nil
+	}
+
+	return r.gz.Flush()
+}
+
+// Close finishes the current segment. Recording again starts a new one.
+func (r *FeedRecorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	return r.closeSegment()
+}
@@ -0,0 +1,146 @@
+package coinbase
+
+import "bufio"
+import "compress/gzip"
+import "encoding/json"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+func readRecording(t *testing.T, path string) []RecordedMessage {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Unexpected error opening segment: %s", err.Error())
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Unexpected error reading segment: %s", err.Error())
+	}
+
+	messages := make([]RecordedMessage, 0)
+	scanner := bufio.NewScanner(gz)
+
+	for scanner.Scan() {
+		var msg RecordedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			t.Fatalf("Unexpected error decoding record: %s", err.Error())
+		}
+		messages = append(messages, msg)
+	}
+
+	return messages
+}
+
+func TestRecordingFeedMessages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Room for two of these messages per segment, and a minute per segment
+	raw := []byte(`{"type":"heartbeat","sequence":1}`)
+	r := NewFeedRecorder(dir, "BTC-USD", 100, time.Minute)
+
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if err := r.Record(start.Add(time.Duration(i)*time.Second), raw); err != nil {
+			t.Fatalf("Unexpected error recording: %s", err.Error())
+		}
+	}
+	r.Record(start.Add(2*time.Minute), raw)
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Unexpected error closing recorder: %s", err.Error())
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "BTC-USD-*"+RECORDING_EXTENSION))
+	if len(segments) != 3 || r.Segments != 3 || r.Records != 4 {
+		t.Fatalf("Expected 4 records in 3 segments, instead %s in %v", r, segments)
+	}
+
+	if filepath.Base(segments[0]) != "BTC-USD-20150101T000000.000000000Z.ndjson.gz" {
+		t.Fatalf("Expected segment to be named after its first message, instead %s", segments[0])
+	}
+
+	expected := []int{2, 1, 1}
+	for i, segment := range segments {
+		if n := len(readRecording(t, segment)); n != expected[i] {
+			t.Fatalf("Expected %d records in segment %d, instead %d", expected[i], i, n)
+		}
+	}
+
+	messages := readRecording(t, segments[0])
+	if !messages[1].Received.Equal(start.Add(time.Second)) {
+		t.Fatalf("Expected receive time to be kept, instead %s", messages[1].Received)
+	}
+	if string(messages[1].Message) != string(raw) {
+		t.Fatalf("Expected the raw message to be kept, instead %s", messages[1].Message)
+	}
+}
+
+func TestRecordingWithoutDecoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	feed := &OrderBookCommandFeed{
+		Feed:       make(chan *CoinbaseOrderBookCommandBatch, 10),
+		Recorder:   NewFeedRecorder(dir, "feed", 0, 0),
+		SkipDecode: true,
+	}
+
+	feed.record(time.Now(), []byte(`{ "type": "open", "sequence": 10 }`))
+	feed.Recorder.Close()
+
+	if feed.Recorder.Records != 1 || feed.RecordErrors != 0 {
+		t.Fatalf("Expected one message recorded, instead %s with %d errors", feed.Recorder, feed.RecordErrors)
+	}
+}
+
+func TestRecordingFramesVerbatim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	frames := []string{
+		`{ "type": "error", "message": "<b>&amp;</b>" }`,
+		`not json`,
+		"{\"type\":\"heartbeat\"}\n",
+		"\xff\xfe",
+	}
+
+	r := NewFeedRecorder(dir, "feed", 0, 0)
+	for i, frame := range frames {
+		if err := r.Record(time.Unix(int64(i), 0), []byte(frame)); err != nil {
+			t.Fatalf("Unexpected error recording %q: %s", frame, err.Error())
+		}
+	}
+	r.Close()
+
+	reader, _ := OpenRecording(dir, "feed")
+	defer reader.Close()
+
+	for _, frame := range frames {
+		msg, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Unexpected error reading %q: %s", frame, err.Error())
+		}
+		if string(msg.Message) != frame {
+			t.Fatalf("Expected %q to be read back as it was recorded, instead %q", frame, msg.Message)
+		}
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("Expected the recording to end, instead %v", err)
+	}
+}
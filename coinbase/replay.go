@@ -0,0 +1,433 @@
+package coinbase
+
+import "bufio"
+import "compress/gzip"
+import "context"
+import "encoding/json"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "log"
+import "os"
+import "path/filepath"
+import "sort"
+import "strings"
+import "sync"
+import "sync/atomic"
+import "time"
+
+// RecordingReader reads the messages in a recording back in order, one
+// segment after another.
+type RecordingReader struct {
+	paths []string
+
+	file   *os.File
+	gz     *gzip.Reader
+	lines  *bufio.Reader
+	record int64
+}
+
+func NewRecordingReader(paths []string) *RecordingReader {
+	return &RecordingReader{paths: paths}
+}
+
+// OpenRecording reads every segment written to dir by a FeedRecorder with
+// prefix, in the order they were written.
+func OpenRecording(dir, prefix string) (*RecordingReader, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, prefix+"-*"+RECORDING_EXTENSION))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(paths)
+
+	return NewRecordingReader(paths), nil
+}
+
+func (r *RecordingReader) String() string {
+	return fmt.Sprintf("<RecordingReader of %d segments left>", len(r.paths))
+}
+
+// Next returns the next message in the recording, or io.EOF after the last.
+func (r *RecordingReader) Next() (*RecordedMessage, error) {
+	for {
+		if r.lines == nil {
+			if len(r.paths) == 0 {
+				return nil, io.EOF
+			}
+			if err := r.openSegment(); err != nil {
+				return nil, err
+			}
+		}
+
+		line, err := r.lines.ReadBytes('\n')
+
+		// A segment that was never closed may end part way through a record,
+		// which is dropped along with the rest of the segment
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			r.closeSegment()
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		r.record += 1
+
+		var msg RecordedMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return nil, fmt.Errorf("Error decoding record %d of %s: %s", r.record, r.file.Name(), err.Error())
+		}
+
+		return &msg, nil
+	}
+}
+
+func (r *RecordingReader) openSegment() error {
+	file, err := os.Open(r.paths[0])
+	if err != nil {
+		return err
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.paths = r.paths[1:]
+	r.file = file
+	r.gz = gz
+	r.lines = bufio.NewReader(gz)
+	r.record = 0
+
+	return nil
+}
+
+func (r *RecordingReader) closeSegment() error {
+	file := r.file
+	r.file, r.gz, r.lines = nil, nil, nil
+
+	return file.Close()
+}
+
+// Close stops reading, skipping any segments that haven't been read yet.
+func (r *RecordingReader) Close() error {
+	r.paths = nil
+
+	if r.file == nil {
+		return nil
+	}
+
+	return r.closeSegment()
+}
+
+// ReplayFeed plays a recording back as the same batches that the realtime
+// feed it was recorded from sent on its Feed, so whatever reads them can't
+// tell the difference.
+//
+// Speed paces the batches by the time the messages were received: 1 is real
+// time, 2 twice as fast and so on, and 0 as fast as they can be read. Start
+// and Stop, if set, limit the replay to the messages received from Start up
+// to but not including Stop. Seek skips everything before the first batch at
+// or past that sequence number. DecodeErrors and Replayed count the messages
+// that couldn't be decoded and the batches sent; read them with
+// atomic.LoadInt64.
+//
+// As a FeedSource, the first Subscribe starts the replay, and every product
+// in the recording is replayed whatever was subscribed to. A replay is live
+// from the moment it starts.
+type ReplayFeed struct {
+	DecodeErrors int64
+	Replayed     int64
+	Feed         chan *CoinbaseOrderBookCommandBatch
+	Products     *ProductRegistry
+
+	Speed float64
+	Start time.Time
+	Stop  time.Time
+	Seek  int64
+
+	reader *RecordingReader
+	errors chan error
+	states chan string
+	now    func() time.Time
+	sleep  func(time.Duration)
+
+	lock    sync.Mutex
+	started bool
+	stopped bool
+	stop    chan struct{}
+}
+
+// NewReplayFeed replays reader, stopping early if ctx is done.
+func NewReplayFeed(ctx context.Context, reader *RecordingReader, bufLen int) *ReplayFeed {
+	feed := &ReplayFeed{
+		Feed:     make(chan *CoinbaseOrderBookCommandBatch, bufLen),
+		Products: NewProductRegistry(),
+		reader:   reader,
+		errors:   make(chan error, 1),
+		states:   make(chan string, 1),
+		now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+
+	feed.sleep = func(d time.Duration) {
+		select {
+		case <-time.After(d):
+		case <-feed.stop:
+		}
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			feed.Close()
+		case <-feed.stop:
+		}
+	}()
+
+	return feed
+}
+
+func (feed *ReplayFeed) String() string {
+	return fmt.Sprintf("<ReplayFeed of %s at %gx; %d batches replayed>", feed.reader, feed.Speed, atomic.LoadInt64(&feed.Replayed))
+}
+
+// Replay sends the recording on Feed, closing it once the recording or the
+// replay is over. Only errors reading the recording and Close stop it early.
+func (feed *ReplayFeed) Replay() error {
+	defer close(feed.Feed)
+	defer feed.reader.Close()
+
+	var first, started time.Time
+	seeking := feed.Seek > 0
+
+	for {
+		msg, err := feed.reader.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !feed.Start.IsZero() && msg.Received.Before(feed.Start) {
+			continue
+		}
+		if !feed.Stop.IsZero() && !msg.Received.Before(feed.Stop) {
+			return nil
+		}
+
+		batch, err := feed.Products.DecodeRealtimeEvent(msg.Message)
+
+		if err == nil && batch == nil {
+			continue
+		}
+
+		if seeking {
+			if err != nil || batch.Sequence < feed.Seek {
+				continue
+			}
+			seeking = false
+		}
+
+		if err != nil {
+			if _, ok := err.(*RealtimeDecodeError); ok {
+				atomic.AddInt64(&feed.DecodeErrors, 1)
+			}
+			log.Printf("Skipping message: %s", err.Error())
+			continue
+		}
+
+		if feed.Speed > 0 {
+			if first.IsZero() {
+				first, started = msg.Received, feed.now()
+			}
+
+			due := time.Duration(float64(msg.Received.Sub(first)) / feed.Speed)
+			if wait := due - feed.now().Sub(started); wait > 0 {
+				feed.sleep(wait)
+			}
+		}
+
+		select {
+		case feed.Feed <- batch:
+			atomic.AddInt64(&feed.Replayed, 1)
+		case <-feed.stop:
+			return nil
+		}
+	}
+}
+
+// Subscribe adds the metadata of products to decode the recording with, and
+// starts replaying it if it hasn't started yet.
+func (feed *ReplayFeed) Subscribe(products ...*ProductMetadata) error {
+	for _, product := range products {
+		feed.Products.Add(product)
+	}
+
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+
+	if !feed.started && !feed.stopped {
+		feed.started = true
+		feed.states <- FEED_STATE_LIVE
+		go func() {
+			defer close(feed.states)
+			defer close(feed.errors)
+			if err := feed.Replay(); err != nil {
+				feed.errors <- err
+			}
+		}()
+	}
+
+	return nil
+}
+
+func (feed *ReplayFeed) Batches() <-chan *CoinbaseOrderBookCommandBatch {
+	return feed.Feed
+}
+
+func (feed *ReplayFeed) Errors() <-chan error {
+	return feed.errors
+}
+
+func (feed *ReplayFeed) States() <-chan string {
+	return feed.states
+}
+
+// Close stops the replay. Feed is closed once it has stopped, or straight
+// away if it never started.
+func (feed *ReplayFeed) Close() error {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+
+	if feed.stopped {
+		return nil
+	}
+
+	feed.stopped = true
+	close(feed.stop)
+
+	if !feed.started {
+		close(feed.Feed)
+		close(feed.errors)
+		close(feed.states)
+		return feed.reader.Close()
+	}
+
+	return nil
+}
+
+// RecordedSnapshots is a SnapshotSource that serves the REST responses a
+// FeedRecorder saved alongside a recording, so that a replay of it can be
+// bootstrapped without the REST API.
+//
+// GetProduct serves the latest metadata recorded for a product. GetOrderBook
+// serves its level 3 snapshots one after another in the order they were
+// taken, starting with the first taken at or after Start, and fails once
+// they run out, so each resync during the replay picks up the next one.
+type RecordedSnapshots struct {
+	Start time.Time
+
+	dir    string
+	prefix string
+
+	lock   sync.Mutex
+	served map[string]int
+}
+
+// OpenSnapshots serves the snapshots saved to dir by a FeedRecorder with
+// prefix.
+func OpenSnapshots(dir, prefix string) *RecordedSnapshots {
+	return &RecordedSnapshots{dir: dir, prefix: prefix, served: make(map[string]int)}
+}
+
+func (s *RecordedSnapshots) String() string {
+	return fmt.Sprintf("<RecordedSnapshots in %s>", filepath.Join(s.dir, s.prefix))
+}
+
+// paths lists the snapshots of one kind for product in the order they were
+// taken.
+func (s *RecordedSnapshots) paths(kind, product string) ([]string, error) {
+	prefix := fmt.Sprintf("%s-%s-%s-", s.prefix, kind, product)
+
+	matches, err := filepath.Glob(filepath.Join(s.dir, prefix+"*"+SNAPSHOT_EXTENSION))
+	if err != nil {
+		return nil, err
+	}
+
+	// The glob also matches products whose IDs start with this one's, like
+	// BTC-USD-PERP for BTC-USD, so only a timestamp may follow the prefix
+	paths := make([]string, 0, len(matches))
+	for _, path := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), prefix), SNAPSHOT_EXTENSION)
+		if _, err := time.Parse(RECORDING_TIME_FORMAT, stamp); err == nil {
+			paths = append(paths, path)
+		}
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+func (s *RecordedSnapshots) GetProduct(id string) (*ProductMetadata, error) {
+	paths, err := s.paths(SNAPSHOT_PRODUCT, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("No metadata recorded for %s in %s", id, s.dir)
+	}
+
+	raw, err := ioutil.ReadFile(paths[len(paths)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeRESTProduct(raw)
+}
+
+func (s *RecordedSnapshots) GetOrderBook(product string) (int64, *CoinbaseOrderBookCommandBatch, error) {
+	meta, err := s.GetProduct(product)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	paths, err := s.paths(SNAPSHOT_BOOK, product)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if !s.Start.IsZero() {
+		start := s.Start.UTC().Format(RECORDING_TIME_FORMAT)
+		for len(paths) > 0 && snapshotTime(paths[0]) < start {
+			paths = paths[1:]
+		}
+	}
+
+	s.lock.Lock()
+	served := s.served[product]
+	s.served[product] = served + 1
+	s.lock.Unlock()
+
+	if served >= len(paths) {
+		return 0, nil, fmt.Errorf("No more order book snapshots recorded for %s in %s", product, s.dir)
+	}
+
+	raw, err := ioutil.ReadFile(paths[served])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return meta.DecodeRESTOrderBook(raw)
+}
+
+// snapshotTime is the time a snapshot was taken, as formatted in its name.
+func snapshotTime(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), SNAPSHOT_EXTENSION)
+	return name[len(name)-len(RECORDING_TIME_FORMAT):]
+}
@@ -0,0 +1,289 @@
+package coinbase
+
+import "context"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "testing"
+import "time"
+import "github.com/jacobgreenleaf/yeti/book"
+
+// recordTestFeed records a subscription acknowledgement and then an order
+// being received, opened and cancelled at sequences 11 to 13, one second apart
+// from start, into two segments.
+func recordTestFeed(t *testing.T, dir string, start time.Time) {
+	r := NewFeedRecorder(dir, "feed", 0, 2*time.Second)
+
+	messages := []string{
+		`{"type":"subscriptions","channels":[]}`,
+		`{"type":"received","time":"2014-11-07T08:19:27.028459Z","product_id":"BTC-USD","sequence":11,"order_id":"bbbb","size":"0.10","price":"1.05","side":"buy"}`,
+		`{"type":"open","time":"2014-11-07T08:19:28.028459Z","product_id":"BTC-USD","sequence":12,"order_id":"bbbb","remaining_size":"0.10","price":"1.05","side":"buy"}`,
+		`{"type":"done","time":"2014-11-07T08:19:29.028459Z","product_id":"BTC-USD","sequence":13,"order_id":"aaaa","reason":"canceled","remaining_size":"0.01","price":"1.00","side":"buy"}`,
+	}
+
+	for i, msg := range messages {
+		if err := r.Record(start.Add(time.Duration(i)*time.Second), []byte(msg)); err != nil {
+			t.Fatalf("Unexpected error recording: %s", err.Error())
+		}
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Unexpected error closing recorder: %s", err.Error())
+	}
+}
+
+// recordTestSnapshots saves BTC-USD's metadata and a snapshot at sequence 10
+// with aaaa resting on the book, taken at start, and another at sequence 12
+// taken a few seconds later.
+func recordTestSnapshots(t *testing.T, dir string, start time.Time) {
+	r := NewFeedRecorder(dir, "feed", 0, 0)
+
+	snapshots := []struct {
+		kind string
+		at   time.Time
+		raw  string
+	}{
+		{SNAPSHOT_PRODUCT, start, `{ "id": "BTC-USD", "base_currency": "BTC", "quote_currency": "USD", "quote_increment": "0.01" }`},
+		{SNAPSHOT_BOOK, start, `{ "sequence": 10, "bids": [ [ "1.00", "0.01", "aaaa" ] ], "asks": [] }`},
+		{SNAPSHOT_BOOK, start.Add(3 * time.Second), `{ "sequence": 12, "bids": [ [ "1.00", "0.01", "aaaa" ], [ "1.05", "0.10", "bbbb" ] ], "asks": [] }`},
+	}
+
+	for _, snapshot := range snapshots {
+		if err := r.RecordSnapshot(snapshot.kind, "BTC-USD", snapshot.at, []byte(snapshot.raw)); err != nil {
+			t.Fatalf("Unexpected error recording snapshot: %s", err.Error())
+		}
+	}
+}
+
+func openTestRecording(t *testing.T, dir string) *ReplayFeed {
+	reader, err := OpenRecording(dir, "feed")
+	if err != nil {
+		t.Fatalf("Unexpected error opening recording: %s", err.Error())
+	}
+
+	feed := NewReplayFeed(context.Background(), reader, 10)
+	feed.Products.Add(DEFAULT_PRODUCT)
+
+	return feed
+}
+
+func replayAll(t *testing.T, feed *ReplayFeed) []*CoinbaseOrderBookCommandBatch {
+	done := make(chan error, 1)
+	go func() { done <- feed.Replay() }()
+
+	batches := make([]*CoinbaseOrderBookCommandBatch, 0)
+	for batch := range feed.Feed {
+		batches = append(batches, batch)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error replaying: %s", err.Error())
+	}
+
+	return batches
+}
+
+func sequences(batches []*CoinbaseOrderBookCommandBatch) string {
+	seqs := make([]int64, 0, len(batches))
+	for _, batch := range batches {
+		seqs = append(seqs, batch.Sequence)
+	}
+	return fmt.Sprint(seqs)
+}
+
+func TestReplayingIntoABook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recordTestFeed(t, dir, time.Unix(1000, 0))
+
+	r := &OrderBookRegistry{Books: map[string]*CoinbaseOrderBook{
+		"BTC-USD": newTestBook(t, DEFAULT_PRODUCT, `{ "sequence": 10, "bids": [ [ "1.00", "0.01", "aaaa" ] ], "asks": [] }`),
+	}}
+	b := r.Get("BTC-USD")
+	if err := b.synchronize(); err != nil {
+		t.Fatalf("Unexpected error synchronizing book: %s", err.Error())
+	}
+
+	feed := openTestRecording(t, dir)
+	for _, batch := range replayAll(t, feed) {
+		r.route(batch)
+	}
+
+	if feed.DecodeErrors != 0 || feed.Replayed != 3 {
+		t.Fatalf("Expected 3 batches replayed and the subscription skipped, instead %s with %d decode errors", feed, feed.DecodeErrors)
+	}
+	if b.Sequence != 13 || b.Gaps != 0 {
+		t.Fatalf("Expected the book to reach sequence 13 without gaps, instead %d with %d gaps", b.Sequence, b.Gaps)
+	}
+
+	if order, _ := b.Book.GetOrder("aaaa"); order.State != book.STATE_VOID {
+		t.Fatalf("Expected aaaa to be cancelled, instead %s", order)
+	}
+	if order, _ := b.Book.GetOrder("bbbb"); order.State != book.STATE_OPEN || order.Price != 105 {
+		t.Fatalf("Expected bbbb to be open at 105, instead %s", order)
+	}
+}
+
+func TestReplayingPartOfARecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Unix(1000, 0)
+	recordTestFeed(t, dir, start)
+
+	feed := openTestRecording(t, dir)
+	feed.Seek = 12
+	if seqs := sequences(replayAll(t, feed)); seqs != "[12 13]" {
+		t.Fatalf("Expected seeking to start at sequence 12, instead %s", seqs)
+	}
+
+	feed = openTestRecording(t, dir)
+	feed.Start = start.Add(2 * time.Second)
+	feed.Stop = start.Add(3 * time.Second)
+	if seqs := sequences(replayAll(t, feed)); seqs != "[12]" {
+		t.Fatalf("Expected only the message received at start+2s, instead %s", seqs)
+	}
+}
+
+func TestPacingReplays(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recordTestFeed(t, dir, time.Unix(1000, 0))
+
+	feed := openTestRecording(t, dir)
+	feed.Speed = 2
+
+	clock := time.Unix(0, 0)
+	waits := make([]time.Duration, 0)
+	feed.now = func() time.Time { return clock }
+	feed.sleep = func(d time.Duration) {
+		waits = append(waits, d)
+		clock = clock.Add(d)
+	}
+
+	replayAll(t, feed)
+
+	// Messages received a second apart, replayed at twice the speed
+	if fmt.Sprint(waits) != "[500ms 500ms]" {
+		t.Fatalf("Expected to wait half a second between batches, instead %v", waits)
+	}
+}
+
+func TestReadingUnclosedSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewFeedRecorder(dir, "feed", 0, 0)
+	r.Record(time.Unix(1, 0), []byte(`{"type":"heartbeat","sequence":1}`))
+	r.Record(time.Unix(2, 0), []byte(`{"type":"heartbeat","sequence":2}`))
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Unexpected error flushing recorder: %s", err.Error())
+	}
+
+	reader, _ := OpenRecording(dir, "feed")
+	defer reader.Close()
+
+	for i := 1; i <= 2; i++ {
+		msg, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Unexpected error reading record %d: %s", i, err.Error())
+		}
+		if msg.Received.Unix() != int64(i) {
+			t.Fatalf("Expected record %d to be received at %d, instead %s", i, i, msg.Received)
+		}
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("Expected the end of an unclosed segment to end the recording, instead %v", err)
+	}
+
+	r.Close()
+}
+
+func TestServingRecordedSnapshots(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Unix(1000, 0)
+	recordTestSnapshots(t, dir, start)
+
+	snapshots := OpenSnapshots(dir, "feed")
+
+	meta, err := snapshots.GetProduct("BTC-USD")
+	if err != nil {
+		t.Fatalf("Unexpected error getting product: %s", err.Error())
+	}
+	if meta.ID != "BTC-USD" || meta.Scale.PriceDecimals != 2 {
+		t.Fatalf("Expected the recorded BTC-USD metadata, instead %s", meta)
+	}
+	if _, err := snapshots.GetProduct("ETH-USD"); err == nil {
+		t.Fatal("Expected no metadata for a product that wasn't recorded")
+	}
+
+	for _, expected := range []int64{10, 12} {
+		seq, _, err := snapshots.GetOrderBook("BTC-USD")
+		if err != nil {
+			t.Fatalf("Unexpected error getting order book: %s", err.Error())
+		}
+		if seq != expected {
+			t.Fatalf("Expected snapshots in the order they were taken, instead sequence %d for %d", seq, expected)
+		}
+	}
+	if _, _, err := snapshots.GetOrderBook("BTC-USD"); err == nil {
+		t.Fatal("Expected an error once the snapshots ran out")
+	}
+
+	snapshots = OpenSnapshots(dir, "feed")
+	snapshots.Start = start.Add(time.Second)
+	if seq, _, _ := snapshots.GetOrderBook("BTC-USD"); seq != 12 {
+		t.Fatalf("Expected the first snapshot taken after Start, instead sequence %d", seq)
+	}
+}
+
+func TestServingSnapshotsOfProductsSharingAPrefix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Unix(1000, 0)
+	recordTestSnapshots(t, dir, start)
+
+	// Named so that a looser match would serve them first
+	r := NewFeedRecorder(dir, "feed", 0, 0)
+	for _, product := range []string{"BTC-USDC", "BTC-USD-PERP"} {
+		r.RecordSnapshot(SNAPSHOT_PRODUCT, product, start.Add(time.Second), []byte(`{ "id": "`+product+`", "base_currency": "BTC", "quote_currency": "USD", "quote_increment": "0.001" }`))
+		r.RecordSnapshot(SNAPSHOT_BOOK, product, start.Add(-time.Second), []byte(`{ "sequence": 99, "bids": [], "asks": [] }`))
+	}
+
+	snapshots := OpenSnapshots(dir, "feed")
+
+	if meta, err := snapshots.GetProduct("BTC-USD"); err != nil || meta.ID != "BTC-USD" {
+		t.Fatalf("Expected BTC-USD's own metadata, instead %v (%v)", meta, err)
+	}
+	if seq, _, err := snapshots.GetOrderBook("BTC-USD"); err != nil || seq != 10 {
+		t.Fatalf("Expected BTC-USD's own first snapshot at sequence 10, instead %d (%v)", seq, err)
+	}
+	if seq, _, err := snapshots.GetOrderBook("BTC-USD-PERP"); err != nil || seq != 99 {
+		t.Fatalf("Expected BTC-USD-PERP's snapshot at sequence 99, instead %d (%v)", seq, err)
+	}
+}
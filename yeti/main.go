@@ -1,58 +1,270 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/jacobgreenleaf/yeti/analytics"
 	"github.com/jacobgreenleaf/yeti/book"
 	"github.com/jacobgreenleaf/yeti/coinbase"
+	"io/ioutil"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	//"container/list"
 	"time"
 	//"github.com/cactus/go-statsd-client/statsd"
 	"log"
+	"os"
+)
+
+const (
+	// EXIT_OK is for shutting down when asked to, EXIT_ERROR for failing to
+	// start or to shut down cleanly, and EXIT_FEED_LOST for the feed ending
+	// without being asked to.
+	EXIT_OK        = 0
+	EXIT_ERROR     = 1
+	EXIT_FEED_LOST = 2
+
+	// SHUTDOWN_TIMEOUT is how long to wait for in-flight batches to drain
+	SHUTDOWN_TIMEOUT = 10 * time.Second
 )
 
 func main() {
+	ctx := shutdownOnSignal()
+
+	if len(os.Args) > 1 && os.Args[1] == "record" {
+		os.Exit(record(ctx, os.Args[2:]))
+	}
+
+	snapshotPath := flag.String("snapshot", "yeti-snapshot.json", "Where to write the book when shutting down")
+	replayDir := flag.String("replay", "", "Replay the recording in this directory instead of connecting to the exchange")
+	speed := flag.Float64("speed", 0, "Replay at this multiple of real time, or as fast as possible if 0")
+	flag.Parse()
+
+	os.Exit(run(ctx, *snapshotPath, *replayDir, *speed))
+}
+
+// shutdownOnSignal returns a context that is cancelled by the first SIGINT or
+// SIGTERM. A second one kills the process as usual.
+func shutdownOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		log.Printf("Received %s, shutting down...", sig)
+		cancel()
+	}()
+
+	return ctx
+}
+
+// run follows the BTC-USD order book until ctx is done or the feed ends. With
+// a replayDir, the feed and the snapshots the book is built from are read
+// from a recording made by the record command, paced by speed, rather than
+// the exchange.
+func run(ctx context.Context, snapshotPath, replayDir string, speed float64) int {
 	var err error
 
-	log.Printf("Connecting to Coinbase Exchange real-time API...")
+	// Shared so that trades aren't counted twice when the book is rebuilt
+	tape := book.NewTradeTape(100000)
+	candles := book.NewCandleAggregator(book.DEFAULT_CANDLE_GRANULARITIES, 5*time.Second, 1000)
+	tape.OnTrade = candles.Add
+
+	newBook := func(scale book.Scale) *book.InMemoryOrderBook {
+		b := book.NewScaledInMemoryOrderBook(scale)
+		b.Retention = book.RetentionPolicy{KeepFor: time.Minute}
+		b.Tape = tape
+		return b
+	}
+
+	var feed coinbase.FeedSource
+	var source coinbase.SnapshotSource
+
+	if replayDir != "" {
+		log.Printf("Replaying %s and synchronizing BTC-USD order book...", replayDir)
+
+		reader, err := coinbase.OpenRecording(replayDir, "feed")
+
+		if err != nil {
+			log.Printf("Error opening recording: %s", err.Error())
+			return EXIT_ERROR
+		}
+
+		replay := coinbase.NewReplayFeed(ctx, reader, 1000)
+		replay.Speed = speed
+
+		feed = replay
+		source = coinbase.OpenSnapshots(replayDir, "feed")
+	} else {
+		log.Printf("Connecting to Coinbase Exchange and synchronizing BTC-USD order book...")
+
+		feed, err = coinbase.ConnectRealtimeFeed(ctx, 1000)
 
-	feed, err := coinbase.ConnectRealtimeFeed(1000)
+		if err != nil {
+			log.Printf("Error connecting to realtime feed: %s", err.Error())
+			return EXIT_ERROR
+		}
+
+		source = coinbase.NewRESTClient()
+	}
+
+	books, err := coinbase.BootstrapFrom(source, feed, []string{"BTC-USD"}, newBook)
 
 	if err != nil {
-		log.Fatalf("Error upgrading coinbase exchange feed connection to WebSocket: %s", err.Error())
+		log.Printf("Error bootstrapping coinbase exchange order book: %s", err.Error())
+		feed.Close()
+		return EXIT_ERROR
 	}
 
-	log.Printf("Connected. Subscribing to BTC-USD...")
+	cbBook := books.Get("BTC-USD")
 
-	feed.Subscribe("BTC-USD")
+	log.Printf("Synchronized at sequence %d.", atomic.LoadInt64(&cbBook.Sequence))
 
-	log.Printf("Synchronizing order book...")
+	maintained := make(chan struct{})
+	go func() {
+		books.MaintainForever(ctx)
+		close(maintained)
+	}()
 
-	orderBook := book.NewInMemoryOrderBook()
+	// Ends once the candles are closed after the feed is done with
+	logged := make(chan struct{})
+	go func() {
+		for candle := range candles.C {
+			if candle.Granularity == time.Minute {
+				log.Printf("Candle: %s", candle)
+			}
+		}
+		close(logged)
+	}()
 
-	var batch *coinbase.CoinbaseOrderBookCommandBatch = nil
+	analyzer := &analytics.Analyzer{Window: time.Minute, Depth: 10, DistanceBucket: 100}
 
-	go feed.ReadForever()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
+	status := EXIT_OK
+
+loop:
 	for {
 		select {
-		case batch = <-feed.Feed:
-			if batch == nil {
-				continue
+		case <-ticker.C:
+			logBook(cbBook, tape, analyzer)
+		case <-maintained:
+			// A replay ends when the recording does
+			if replayDir != "" {
+				log.Printf("Replay finished")
+				break loop
 			}
+			log.Printf("Realtime feed ended unexpectedly")
+			status = EXIT_FEED_LOST
+			break loop
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	select {
+	case <-maintained:
+	case <-time.After(SHUTDOWN_TIMEOUT):
+		log.Printf("Timed out waiting for in-flight batches to drain")
+		return EXIT_ERROR
+	}
 
-			err = batch.Apply(orderBook)
+	// No more trades are coming, so the bars still in progress are as done as
+	// they'll get
+	candles.Close()
+	<-logged
 
-			if err != nil {
-				log.Printf("Failed to apply order book command: %s", err.Error())
-			}
+	if err := writeSnapshot(snapshotPath, cbBook); err != nil {
+		log.Printf("Error writing final snapshot: %s", err.Error())
+		return EXIT_ERROR
+	}
+
+	log.Printf("Wrote final snapshot at sequence %d to %s", atomic.LoadInt64(&cbBook.Sequence), snapshotPath)
+	log.Printf("Exiting...")
+
+	return status
+}
+
+func logBook(cbBook *coinbase.CoinbaseOrderBook, tape *book.TradeTape, analyzer *analytics.Analyzer) {
+	gaps, resyncs, failedResyncs := atomic.LoadInt64(&cbBook.Gaps), atomic.LoadInt64(&cbBook.Resyncs), atomic.LoadInt64(&cbBook.FailedResyncs)
+
+	if cbBook.Book.Stale() {
+		log.Printf("Order book is stale. Gaps: %d\tResyncs: %d\tFailed resyncs: %d", gaps, resyncs, failedResyncs)
+		return
+	}
 
-			openOrders := book.CalculateNumberOfOpenOrdersInMemory(orderBook, time.Now())
-			bid, median, ask, spread := book.CalculateBidMedianAskSpreadInMemory(orderBook, time.Now())
+	var scale book.Scale
 
-			log.Printf("There are %d open orders. Bid: %d\tMed: %d\tAsk: %d\tSpread: %d", openOrders, bid, median, ask, spread)
+	cbBook.Book.Read(func(orderBook *book.InMemoryOrderBook) {
+		openOrders := book.CalculateNumberOfOpenOrdersInMemory(orderBook, time.Now())
+		bid, median, ask, spread := book.CalculateBidMedianAskSpreadInMemory(orderBook, time.Now())
 
-			orderBook.Vacuum()
+		scale = orderBook.Scale
+
+		log.Printf("There are %d open orders. Bid: %s\tMed: %s\tAsk: %s\tSpread: %s\tGaps: %d\tResyncs: %d\tFailed resyncs: %d", openOrders, scale.FormatPrice(bid), scale.FormatPrice(median), scale.FormatPrice(ask), scale.FormatPrice(spread), gaps, resyncs, failedResyncs)
+
+		for _, side := range []string{book.SIDE_BUY, book.SIDE_SELL} {
+			for _, level := range orderBook.Depth(side, 3) {
+				log.Printf("\t%s %s x %s in %d orders", side, scale.FormatPrice(level.Price), scale.FormatSize(level.Size), level.Orders)
+			}
 		}
+	})
+
+	lastMinute := tape.Window(time.Minute)
+	log.Printf("%d trades in the last minute for %s; VWAP: %s", lastMinute.Trades, scale.FormatSize(lastMinute.Volume), scale.FormatPrice(lastMinute.VWAP))
+
+	// The analyzer walks back through the book's history, so give it a copy
+	// rather than keeping the feed out of the book while it does
+	copied := cbBook.Book.Copy()
+	flow := analyzer.Report(copied, copied.LatestMutationTime)
+	log.Printf("Last minute: %d placed, %d cancels, %d fills (%.2f cancels per fill); median lifetime %s; imbalance %.2f top, %.2f depth; OFI %s", flow.Placed, flow.Cancels, flow.Fills, flow.CancelToFill, flow.MedianLifetime, flow.Imbalance, flow.DepthImbalance, scale.FormatSize(flow.OrderFlowImbalance))
+
+	vacuumed := cbBook.Book.Vacuum()
+
+	if len(vacuumed.Orders) > 0 {
+		log.Printf("Vacuumed %d orders and %d price levels finished by %s", len(vacuumed.Orders), len(vacuumed.PriceLevels), vacuumed.Horizon)
 	}
+}
 
-	log.Printf("Exiting...")
+// finalSnapshot is what's left of the book when we shut down.
+type finalSnapshot struct {
+	Product  string                      `json:"product"`
+	Sequence int64                       `json:"sequence"`
+	Time     time.Time                   `json:"time"`
+	Stale    bool                        `json:"stale"`
+	Bids     []book.AggregatedPriceLevel `json:"bids"`
+	Asks     []book.AggregatedPriceLevel `json:"asks"`
+}
+
+// writeSnapshot writes every level of the book to path, replacing whatever
+// was there only once it has all been written.
+func writeSnapshot(path string, cbBook *coinbase.CoinbaseOrderBook) error {
+	snapshot := finalSnapshot{
+		Product:  cbBook.Product.ID,
+		Sequence: atomic.LoadInt64(&cbBook.Sequence),
+		Time:     time.Now(),
+		Stale:    cbBook.Book.Stale(),
+	}
+
+	cbBook.Book.Read(func(orderBook *book.InMemoryOrderBook) {
+		snapshot.Bids = orderBook.Depth(book.SIDE_BUY, 0)
+		snapshot.Asks = orderBook.Depth(book.SIDE_SELL, 0)
+	})
+
+	data, err := json.Marshal(&snapshot)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/jacobgreenleaf/yeti/coinbase"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// record captures the raw realtime feed of some products to disk without
+// building any books, until ctx is done. Their metadata, and a level 3
+// snapshot of each whenever the feed goes live, are saved alongside it so
+// that the recording can be replayed without the REST API.
+//
+//	yeti record [-dir DIR] [-segment-bytes N] [-segment-age D] PRODUCT...
+func record(ctx context.Context, args []string) int {
+	flags := flag.NewFlagSet("record", flag.ExitOnError)
+	dir := flags.String("dir", ".", "Directory to write segments to")
+	segmentBytes := flags.Int64("segment-bytes", 64<<20, "Start a new segment after this many uncompressed bytes")
+	segmentAge := flags.Duration("segment-age", time.Hour, "Start a new segment after this long")
+	flags.Parse(args)
+
+	products := flags.Args()
+	if len(products) == 0 {
+		products = []string{"BTC-USD"}
+	}
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Printf("Error creating recording directory: %s", err.Error())
+		return EXIT_ERROR
+	}
+
+	feed, err := coinbase.ConnectRealtimeFeed(ctx, 0)
+
+	if err != nil {
+		log.Printf("Error connecting to realtime feed: %s", err.Error())
+		return EXIT_ERROR
+	}
+
+	recorder := coinbase.NewFeedRecorder(*dir, "feed", *segmentBytes, *segmentAge)
+	feed.Recorder = recorder
+	feed.SkipDecode = true
+
+	log.Printf("Recording %v to %s", products, *dir)
+
+	client := coinbase.NewRESTClient()
+
+	metas := make([]*coinbase.ProductMetadata, 0, len(products))
+	for _, product := range products {
+		meta, err := recordProduct(client, recorder, product)
+		if err != nil {
+			log.Printf("Error recording %s metadata: %s", product, err.Error())
+			feed.Close()
+			return EXIT_ERROR
+		}
+		metas = append(metas, meta)
+	}
+
+	if err := feed.Subscribe(metas...); err != nil {
+		log.Printf("Error subscribing to realtime feed: %s", err.Error())
+		feed.Close()
+		return EXIT_ERROR
+	}
+
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	status := EXIT_OK
+
+	// Nothing is decoded so nothing is sent on Feed, but it is closed once the
+	// feed stops reading and so stops recording
+	stopped := feed.Batches()
+	states := feed.States()
+
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			if err := recorder.Flush(); err != nil {
+				log.Printf("Error flushing recording: %s", err.Error())
+			}
+
+			log.Printf("Recorded %d messages in %d segments; %d errors; feed is %s after %d reconnects", atomic.LoadInt64(&recorder.Records), atomic.LoadInt64(&recorder.Segments), atomic.LoadInt64(&feed.RecordErrors), feed.State(), atomic.LoadInt64(&feed.Reconnects))
+		case state, ok := <-states:
+			if !ok {
+				states = nil
+				continue
+			}
+
+			// Whatever replays this needs a snapshot to start from, and
+			// another to pick up from after every gap
+			if state == coinbase.FEED_STATE_LIVE {
+				for _, product := range products {
+					if err := recordBook(client, recorder, product); err != nil {
+						log.Printf("Error recording %s order book snapshot: %s", product, err.Error())
+					}
+				}
+			}
+		case <-stopped:
+			log.Printf("Realtime feed ended unexpectedly")
+			status = EXIT_FEED_LOST
+			break loop
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	// Closing the recorder while the feed is still recording would start a
+	// new segment for whatever came in after it
+	select {
+	case <-stopped:
+	case <-time.After(SHUTDOWN_TIMEOUT):
+		log.Printf("Timed out waiting for the feed to stop")
+		return EXIT_ERROR
+	}
+
+	if err := recorder.Close(); err != nil {
+		log.Printf("Error closing recording: %s", err.Error())
+		return EXIT_ERROR
+	}
+
+	log.Printf("Recorded %d messages in %d segments to %s", atomic.LoadInt64(&recorder.Records), atomic.LoadInt64(&recorder.Segments), *dir)
+
+	return status
+}
+
+// recordProduct saves a product's metadata to the recording and decodes it.
+func recordProduct(client *coinbase.RESTClient, recorder *coinbase.FeedRecorder, product string) (*coinbase.ProductMetadata, error) {
+	raw, err := client.GetRawProduct(product)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recorder.RecordSnapshot(coinbase.SNAPSHOT_PRODUCT, product, time.Now(), raw); err != nil {
+		return nil, err
+	}
+
+	return coinbase.DecodeRESTProduct(raw)
+}
+
+// recordBook saves a level 3 snapshot of a product's order book to the
+// recording, stamped with when it was asked for.
+func recordBook(client *coinbase.RESTClient, recorder *coinbase.FeedRecorder, product string) error {
+	requested := time.Now()
+
+	raw, err := client.GetRawOrderBook(product)
+	if err != nil {
+		return err
+	}
+
+	return recorder.RecordSnapshot(coinbase.SNAPSHOT_BOOK, product, requested, raw)
+}